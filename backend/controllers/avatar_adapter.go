@@ -3,9 +3,11 @@ package controllers
 import (
 	"backend/interfaces"
 	"backend/models"
+	"backend/services"
 	"context"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -13,12 +15,20 @@ import (
 )
 
 type AvatarController struct {
-	db interfaces.DatabaseService
+	db         interfaces.DatabaseService
+	storage    interfaces.StorageService
+	versions   *services.AvatarVersionService
+	ratings    *services.AvatarRatingService
+	moderation *services.ModerationService
 }
 
-func NewAvatarController(db interfaces.DatabaseService) *AvatarController {
+func NewAvatarController(db interfaces.DatabaseService, storage interfaces.StorageService) *AvatarController {
 	return &AvatarController{
-		db: db,
+		db:         db,
+		storage:    storage,
+		versions:   services.GetAvatarVersionService(),
+		ratings:    services.NewAvatarRatingService(db),
+		moderation: services.GetModerationService(),
 	}
 }
 
@@ -38,6 +48,11 @@ func (ac *AvatarController) CreateAvatar(c *gin.Context) {
 		return
 	}
 
+	if req.IsPublic && ac.moderation.IsBanned(context.Background(), userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not allowed to publish avatars"})
+		return
+	}
+
 	now := time.Now().Unix()
 	avatar := models.Avatar{
 		ID:              uuid.New().String(),
@@ -52,8 +67,14 @@ func (ac *AvatarController) CreateAvatar(c *gin.Context) {
 		CreatedAt:       now,
 		UpdatedAt:       now,
 	}
+	applyAvatarDialogue(&avatar, &req)
+	if err := applyAvatarDiscovery(&avatar, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ac.moderation.ScreenAvatar(context.Background(), &avatar)
 
-	err := ac.db.SaveAvatar(context.Background(), &avatar)
+	err := ac.versions.Create(context.Background(), &avatar, models.AvatarChangeCreated, userID)
 	if err != nil {
 		log.Printf("Error creating avatar: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create avatar"})
@@ -87,7 +108,7 @@ func (ac *AvatarController) GetAvatar(c *gin.Context) {
 	}
 
 	// Check if the avatar is public or belongs to the user
-	if !avatar.IsPublic && avatar.OwnerID != userID {
+	if !avatar.VisibleTo(userID) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "You don't have access to this avatar"})
 		return
 	}
@@ -106,13 +127,8 @@ func (ac *AvatarController) GetAvatar(c *gin.Context) {
 		}
 	}
 
-	// Return the avatar with ownership information
-	avatarResponse := models.AvatarResponse{
-		Avatar:  *avatar,
-		IsOwner: avatar.OwnerID == userID,
-	}
-
-	c.JSON(http.StatusOK, avatarResponse)
+	// Return the avatar with ownership, rating and vote information
+	c.JSON(http.StatusOK, ac.newAvatarResponses(userID, []*models.Avatar{avatar})[0])
 }
 
 // GetUserAvatars gets all avatars owned by the current user
@@ -131,40 +147,46 @@ func (ac *AvatarController) GetUserAvatars(c *gin.Context) {
 		return
 	}
 
-	var avatarResponses []models.AvatarResponse
-	for _, avatar := range avatars {
-		avatarResponse := models.AvatarResponse{
-			Avatar:  *avatar,
-			IsOwner: true, // All are owned by the user
-		}
-		avatarResponses = append(avatarResponses, avatarResponse)
-	}
-
-	c.JSON(http.StatusOK, gin.H{"avatars": avatarResponses})
+	c.JSON(http.StatusOK, gin.H{"avatars": ac.newAvatarResponses(userID, avatars)})
 }
 
-// GetPublicAvatars gets all public avatars for the marketplace
+// GetPublicAvatars lists public avatars for the marketplace. The listing can be searched
+// and filtered by tag or category, and is returned in pages of the requested order.
 func (ac *AvatarController) GetPublicAvatars(c *gin.Context) {
 	userID := c.GetString("userId")
 	// For public avatars, we allow access even without authentication
 
-	avatars, err := ac.db.GetPublicAvatars(context.Background())
+	query := models.AvatarQuery{
+		Search:   c.Query("search"),
+		Tag:      c.Query("tag"),
+		Category: c.Query("category"),
+		Sort:     c.Query("sort"),
+		Cursor:   c.Query("cursor"),
+	}
+	if limit := c.Query("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a number"})
+			return
+		}
+		query.Limit = parsed
+	}
+	if err := services.NormalizeAvatarQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := ac.db.SearchPublicAvatars(context.Background(), query)
 	if err != nil {
 		log.Printf("Error getting public avatars: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve avatars"})
 		return
 	}
 
-	var avatarResponses []models.AvatarResponse
-	for _, avatar := range avatars {
-		avatarResponse := models.AvatarResponse{
-			Avatar:  *avatar,
-			IsOwner: userID != "" && avatar.OwnerID == userID,
-		}
-		avatarResponses = append(avatarResponses, avatarResponse)
-	}
-
-	c.JSON(http.StatusOK, gin.H{"avatars": avatarResponses})
+	c.JSON(http.StatusOK, gin.H{
+		"avatars":    ac.newAvatarResponses(userID, page.Avatars),
+		"nextCursor": page.NextCursor,
+	})
 }
 
 // UpdateAvatar updates an existing avatar
@@ -202,18 +224,31 @@ func (ac *AvatarController) UpdateAvatar(c *gin.Context) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to update this avatar"})
 		return
 	}
+	if req.IsPublic && !avatar.IsPublic && ac.moderation.IsBanned(context.Background(), userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not allowed to publish avatars"})
+		return
+	}
 
-	// Update avatar fields
+	// Update avatar fields, keeping the previous persona for the version history
+	previous := avatar.Snapshot()
 	avatar.Name = req.Name
 	avatar.Description = req.Description
 	avatar.Story = req.Story
 	avatar.Persona = req.Persona
-	avatar.ProfileImageURL = req.ProfileImageURL
+	if req.ProfileImageURL != "" {
+		avatar.ProfileImageURL = req.ProfileImageURL
+	}
 	avatar.IsPublic = req.IsPublic
 	avatar.CreatorNickname = req.CreatorNickname
+	applyAvatarDialogue(avatar, &req)
+	if err := applyAvatarDiscovery(avatar, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	avatar.UpdatedAt = time.Now().Unix()
+	ac.moderation.ScreenAvatar(context.Background(), avatar)
 
-	err = ac.db.UpdateAvatar(context.Background(), avatar)
+	err = ac.versions.Update(context.Background(), avatar, previous, userID)
 	if err != nil {
 		log.Printf("Error updating avatar: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update avatar"})
@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"backend/models"
+	"backend/services"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// maxCharacterCardSize limits uploaded character cards, including PNG images
+const maxCharacterCardSize = 20 << 20
+
+var unsafeFilenamePattern = regexp.MustCompile(`[^\p{L}\p{N}_-]+`)
+
+// ImportAvatar creates a private avatar from a character card. The card can be JSON or a PNG
+// with the card embedded, sent as the request body or as a multipart "file" field.
+// For PNG cards the image becomes the profile image.
+func (ac *AvatarController) ImportAvatar(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		log.Printf("User ID not found in context")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	data, err := readCharacterCardUpload(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	cardJSON := data
+	var imageData []byte
+	if services.IsPNG(data) {
+		imageData = data
+		if cardJSON, err = services.ReadCharacterCardFromPNG(data); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	card, err := services.ParseCharacterCard(cardJSON)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	avatar := services.CharacterCardToAvatar(card, time.Now().Unix())
+	avatar.ID = uuid.New().String()
+	avatar.OwnerID = userID
+
+	if imageData != nil {
+		storagePath := fmt.Sprintf("avatars/%s/%s.png", userID, avatar.ID)
+		imageURL, err := ac.storage.UploadBase64Image(base64.StdEncoding.EncodeToString(imageData), storagePath)
+		if err != nil {
+			// The character is still usable without its picture
+			log.Printf("Error uploading imported avatar image: %v", err)
+		} else {
+			avatar.ProfileImageURL = imageURL
+		}
+	}
+
+	if err := ac.versions.Create(context.Background(), avatar, models.AvatarChangeCreated, userID); err != nil {
+		log.Printf("Error saving imported avatar: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create avatar"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, avatar)
+}
+
+// ExportAvatar downloads an avatar as a character card, as JSON (default) or as its
+// profile image with the card embedded (?format=png)
+func (ac *AvatarController) ExportAvatar(c *gin.Context) {
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "png" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or png"})
+		return
+	}
+
+	avatar, ok := ac.getReadableAvatar(c)
+	if !ok {
+		return
+	}
+
+	cardJSON, err := json.Marshal(services.AvatarToCharacterCard(avatar))
+	if err != nil {
+		log.Printf("Error encoding character card: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export avatar"})
+		return
+	}
+
+	filename := strings.Trim(unsafeFilenamePattern.ReplaceAllString(avatar.Name, "_"), "_")
+	if filename == "" {
+		filename = "character"
+	}
+
+	if format == "json" {
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, filename))
+		c.Data(http.StatusOK, "application/json", cardJSON)
+		return
+	}
+
+	// Without a reachable profile image the card is embedded in a placeholder
+	imageData, err := fetchProfileImage(avatar.ProfileImageURL)
+	if err != nil {
+		log.Printf("Could not fetch profile image of avatar %s: %v", avatar.ID, err)
+	}
+
+	pngData, err := services.WriteCharacterCardToPNG(services.ToPNG(imageData), cardJSON)
+	if err != nil {
+		log.Printf("Error embedding character card: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export avatar"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.png"`, filename))
+	c.Data(http.StatusOK, "image/png", pngData)
+}
+
+// readCharacterCardUpload returns the uploaded card from a multipart "file" field or the raw body
+func readCharacterCardUpload(c *gin.Context) ([]byte, error) {
+	var reader io.Reader = c.Request.Body
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			return nil, fmt.Errorf("file is required: %v", err)
+		}
+		file, err := fileHeader.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file: %v", err)
+		}
+		defer file.Close()
+		reader = file
+	}
+
+	data, err := io.ReadAll(io.LimitReader(reader, maxCharacterCardSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read character card: %v", err)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("character card is empty")
+	}
+	if len(data) > maxCharacterCardSize {
+		return nil, fmt.Errorf("character card is larger than %d MB", maxCharacterCardSize>>20)
+	}
+	return data, nil
+}
+
+// fetchProfileImage loads an avatar's profile image. Only images in this app's storage or
+// data URIs are loaded, so avatars can't make the server fetch arbitrary addresses.
+func fetchProfileImage(imageURL string) ([]byte, error) {
+	if imageURL == "" {
+		return nil, fmt.Errorf("avatar has no profile image")
+	}
+
+	encoded, err := services.LoadStoredImage(imageURL)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(encoded)
+}
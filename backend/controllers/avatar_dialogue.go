@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"backend/models"
+	"backend/services"
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+// applyAvatarDialogue copies the greetings and example dialogues of a request onto an avatar,
+// dropping empty entries. Fields missing from the request are left unchanged.
+func applyAvatarDialogue(avatar *models.Avatar, req *models.AvatarRequest) {
+	if req.Greetings != nil {
+		avatar.Greetings = []string{}
+		for _, greeting := range *req.Greetings {
+			if strings.TrimSpace(greeting) != "" {
+				avatar.Greetings = append(avatar.Greetings, greeting)
+			}
+		}
+	}
+
+	if req.ExampleDialogues != nil {
+		avatar.ExampleDialogues = []models.ExampleDialogue{}
+		for _, dialogue := range *req.ExampleDialogues {
+			if strings.TrimSpace(dialogue.User) != "" && strings.TrimSpace(dialogue.Avatar) != "" {
+				avatar.ExampleDialogues = append(avatar.ExampleDialogues, dialogue)
+			}
+		}
+	}
+}
+
+// pickGreeting returns the avatar's greeting at the given index, or a random one when no index is given.
+// It returns false if the avatar has no greetings.
+func pickGreeting(avatar *models.Avatar, index *int) (string, bool) {
+	if len(avatar.Greetings) == 0 {
+		return "", false
+	}
+	if index != nil {
+		return avatar.Greetings[*index], true
+	}
+	return avatar.Greetings[rand.Intn(len(avatar.Greetings))], true
+}
+
+// exampleDialogueMessages turns the avatar's example dialogues into few-shot turns,
+// framed so the model doesn't take them for part of the conversation
+func (cc *ChatController) exampleDialogueMessages(avatar *models.Avatar) []services.OpenRouterMessage {
+	if len(avatar.ExampleDialogues) == 0 {
+		return nil
+	}
+
+	messages := []services.OpenRouterMessage{{
+		Role:    "system",
+		Content: fmt.Sprintf("The following example exchanges show how %s talks. They are not part of the conversation.", avatar.Name),
+	}}
+	for _, dialogue := range avatar.ExampleDialogues {
+		messages = append(messages,
+			services.OpenRouterMessage{Role: "user", Content: dialogue.User},
+			services.OpenRouterMessage{Role: "assistant", Content: dialogue.Avatar},
+		)
+	}
+	messages = append(messages, services.OpenRouterMessage{
+		Role:    "system",
+		Content: "End of examples. The actual conversation starts now.",
+	})
+	return messages
+}
@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"backend/models"
+	"backend/services"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// applyAvatarDiscovery copies the tags and category of a request onto an avatar.
+// Fields missing from the request are left unchanged.
+func applyAvatarDiscovery(avatar *models.Avatar, req *models.AvatarRequest) error {
+	if req.Tags != nil {
+		tags := services.NormalizeAvatarTags(*req.Tags)
+		if len(tags) > services.MaxAvatarTags {
+			return fmt.Errorf("an avatar can have at most %d tags", services.MaxAvatarTags)
+		}
+		avatar.Tags = tags
+	}
+
+	if req.Category != nil {
+		category := strings.ToLower(strings.TrimSpace(*req.Category))
+		if category != "" && !models.IsAvatarCategory(category) {
+			return fmt.Errorf("unknown category %q", category)
+		}
+		avatar.Category = category
+	}
+	return nil
+}
+
+// GetAvatarCategories lists the categories avatars can be filed under
+func (ac *AvatarController) GetAvatarCategories(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"categories": models.AvatarCategories})
+}
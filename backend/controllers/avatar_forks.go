@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"backend/models"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ForkAvatar copies a public avatar, or one of the caller's own, into the caller's collection
+// so it can be customized.
+// The copy is private, remembers where it came from and credits the original creator.
+func (ac *AvatarController) ForkAvatar(c *gin.Context) {
+	var req models.AvatarForkRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		log.Printf("Error binding JSON: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	source, ok := ac.getReadableAvatar(c)
+	if !ok {
+		return
+	}
+	userID := c.GetString("userId")
+
+	originalCreator := source.OriginalCreatorNickname
+	if originalCreator == "" {
+		originalCreator = source.CreatorNickname
+	}
+
+	now := time.Now().Unix()
+	fork := &models.Avatar{
+		ID:               uuid.New().String(),
+		Name:             source.Name,
+		Description:      source.Description,
+		Story:            source.Story,
+		Persona:          source.Persona,
+		ProfileImageURL:  source.ProfileImageURL,
+		IsPublic:         false,
+		OwnerID:          userID,
+		CreatorNickname:  source.CreatorNickname,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+		Greetings:        append([]string(nil), source.Greetings...),
+		ExampleDialogues: append([]models.ExampleDialogue(nil), source.ExampleDialogues...),
+		PromptTemplates:  append(models.PromptTemplateOverrides(nil), source.PromptTemplates...),
+		Lorebook:         append(models.Lorebook(nil), source.Lorebook...),
+		Tags:             append([]string(nil), source.Tags...),
+		Category:         source.Category,
+
+		ForkedFrom:              source.ID,
+		ForkedFromVersion:       source.Version,
+		ForkLineage:             append(append([]string(nil), source.ForkLineage...), source.ID),
+		OriginalCreatorNickname: originalCreator,
+	}
+	if name := strings.TrimSpace(req.Name); name != "" {
+		fork.Name = name
+	}
+	if nickname := strings.TrimSpace(req.CreatorNickname); nickname != "" {
+		fork.CreatorNickname = nickname
+	}
+
+	if err := ac.versions.Create(context.Background(), fork, models.AvatarChangeForked, userID); err != nil {
+		log.Printf("Error creating fork: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fork avatar"})
+		return
+	}
+
+	// The fork exists either way, so a failed count is only logged
+	if err := ac.db.UpdateAvatarStats(context.Background(), source.ID, func(stats *models.AvatarStats) {
+		stats.ForkCount++
+	}); err != nil {
+		log.Printf("Error counting fork of avatar %s: %v", source.ID, err)
+	}
+
+	c.JSON(http.StatusCreated, fork)
+}
@@ -0,0 +1,124 @@
+package controllers
+
+import (
+	"backend/models"
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// GetAvatarLorebook returns the lorebook entries of an avatar
+func (ac *AvatarController) GetAvatarLorebook(c *gin.Context) {
+	avatar, ok := ac.getOwnedAvatar(c)
+	if !ok {
+		return
+	}
+
+	entries := avatar.Lorebook
+	if entries == nil {
+		entries = models.Lorebook{}
+	}
+	c.JSON(http.StatusOK, gin.H{"entries": entries})
+}
+
+// CreateAvatarLorebookEntry adds an entry to an avatar's lorebook
+func (ac *AvatarController) CreateAvatarLorebookEntry(c *gin.Context) {
+	var req models.LorebookEntryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !req.HasValidKeywords() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Keywords must not be empty"})
+		return
+	}
+
+	avatar, ok := ac.getOwnedAvatar(c)
+	if !ok {
+		return
+	}
+
+	now := time.Now().Unix()
+	entry := models.LorebookEntry{
+		ID:        uuid.New().String(),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	req.Apply(&entry)
+	avatar.Lorebook = append(avatar.Lorebook, entry)
+	avatar.UpdatedAt = now
+
+	if err := ac.db.UpdateAvatar(context.Background(), avatar); err != nil {
+		log.Printf("Error saving lorebook entry: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update avatar"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, entry)
+}
+
+// UpdateAvatarLorebookEntry replaces an entry of an avatar's lorebook
+func (ac *AvatarController) UpdateAvatarLorebookEntry(c *gin.Context) {
+	var req models.LorebookEntryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !req.HasValidKeywords() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Keywords must not be empty"})
+		return
+	}
+
+	avatar, ok := ac.getOwnedAvatar(c)
+	if !ok {
+		return
+	}
+
+	i := avatar.Lorebook.Index(c.Param("entryID"))
+	if i < 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lorebook entry not found"})
+		return
+	}
+
+	now := time.Now().Unix()
+	req.Apply(&avatar.Lorebook[i])
+	avatar.Lorebook[i].UpdatedAt = now
+	avatar.UpdatedAt = now
+
+	if err := ac.db.UpdateAvatar(context.Background(), avatar); err != nil {
+		log.Printf("Error updating lorebook entry: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update avatar"})
+		return
+	}
+
+	c.JSON(http.StatusOK, avatar.Lorebook[i])
+}
+
+// DeleteAvatarLorebookEntry removes an entry from an avatar's lorebook
+func (ac *AvatarController) DeleteAvatarLorebookEntry(c *gin.Context) {
+	avatar, ok := ac.getOwnedAvatar(c)
+	if !ok {
+		return
+	}
+
+	i := avatar.Lorebook.Index(c.Param("entryID"))
+	if i < 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lorebook entry not found"})
+		return
+	}
+
+	avatar.Lorebook = append(avatar.Lorebook[:i], avatar.Lorebook[i+1:]...)
+	avatar.UpdatedAt = time.Now().Unix()
+
+	if err := ac.db.UpdateAvatar(context.Background(), avatar); err != nil {
+		log.Printf("Error deleting lorebook entry: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update avatar"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Lorebook entry deleted successfully"})
+}
@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"backend/models"
+	"backend/services"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// GenerateAvatarPortrait starts a job generating profile image candidates from the avatar's
+// name, description and persona. The user picks one with SelectAvatarPortrait.
+func (c *ImageController) GenerateAvatarPortrait(ctx *gin.Context) {
+	respond := func(status int, message string) {
+		ctx.JSON(status, gin.H{"error": message})
+	}
+
+	// The body is optional
+	var req models.AvatarPortraitRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		respond(http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+
+	avatar, ok := loadOwnedAvatar(ctx, c.db)
+	if !ok {
+		return
+	}
+	userID := ctx.GetString("userId")
+
+	if req.AspectRatio != "" && (req.Width != nil || req.Height != nil) {
+		respond(http.StatusBadRequest, "Set either an aspect ratio or a width and height")
+		return
+	}
+
+	model, err := services.GetImageModelRegistry().Resolve(req.Model, models.ImageOperationGenerate)
+	if err != nil {
+		respond(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Square images with several candidates to choose from, unless the user asked otherwise
+	prompt := services.BuildAvatarPortraitPrompt(avatar, req.Details)
+	inputs := req.ImageGenerationParams.Inputs()
+	inputs["prompt"] = prompt
+	if param := model.Param(model.InputName("num_outputs")); param != nil && req.NumOutputs == nil {
+		candidates := models.DefaultAvatarPortraitCandidates
+		if param.Max != nil && float64(candidates) > *param.Max {
+			candidates = int(*param.Max)
+		}
+		inputs["num_outputs"] = candidates
+	}
+	if model.Param(model.InputName("aspect_ratio")) != nil && req.AspectRatio == "" && req.Width == nil && req.Height == nil {
+		inputs["aspect_ratio"] = "1:1"
+	}
+
+	model, input, err := buildImageInput(model.ID, models.ImageOperationGenerate, req.Input, inputs)
+	if err != nil {
+		respond(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	enhanceKey, ok := c.getEnhancementAPIKey(userID, req.EnhancePrompt, respond)
+	if !ok {
+		return
+	}
+
+	// Get the image provider, with the user's API key for Replicate
+	imageService, ok := c.getImageProvider(ctx, userID)
+	if !ok {
+		return // getImageProvider already set the error response
+	}
+
+	log.Printf("Generating portrait for avatar %s with prompt: %s", avatar.ID, prompt)
+
+	job := c.jobManager.CreateJob(userID, models.AvatarPortraitJobType, map[string]interface{}{
+		"avatarId": avatar.ID,
+		"prompt":   prompt,
+		"model":    model.ID,
+	})
+
+	go func() {
+		c.jobManager.UpdateJobStatus(job.ID, models.JobStatusProcessing)
+
+		enhancedPrompt := c.applyPromptEnhancement(enhanceKey, userID, prompt, models.ImageOperationGenerate, model, input, req.PromptEnhancementOptions)
+		imageService.RunModelAsync(model, input, func(imageURLs []string, err error) {
+			if err != nil {
+				log.Printf("Error generating portrait for job %s: %v", job.ID, err)
+				c.jobManager.FailJob(job.ID, fmt.Sprintf("Failed to generate portrait: %v", err))
+				return
+			}
+
+			c.jobManager.CompleteJob(job.ID, newImageRecords(userID, prompt, enhancedPrompt, "portrait", imageURLs))
+		})
+	}()
+
+	// Return the job ID immediately
+	ctx.JSON(http.StatusAccepted, models.JobResponse{
+		Success: true,
+		Job:     job,
+	})
+}
+
+// SelectAvatarPortrait stores a candidate of a completed portrait job as the avatar's
+// profile image
+func (c *ImageController) SelectAvatarPortrait(ctx *gin.Context) {
+	respond := func(status int, message string) {
+		ctx.JSON(status, gin.H{"error": message})
+	}
+
+	var req models.AvatarPortraitSelectRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		respond(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	avatar, ok := loadOwnedAvatar(ctx, c.db)
+	if !ok {
+		return
+	}
+	userID := ctx.GetString("userId")
+
+	job, exists := c.jobManager.GetJob(req.JobID)
+	if !exists || job.UserID != userID || job.Type != models.AvatarPortraitJobType || job.Data["avatarId"] != avatar.ID {
+		respond(http.StatusNotFound, "Portrait job not found")
+		return
+	}
+	if job.Status != models.JobStatusCompleted {
+		respond(http.StatusConflict, "The portrait job has not completed")
+		return
+	}
+
+	var candidate *models.Image
+	for i := range job.Results {
+		if job.Results[i].ID == req.ImageID {
+			candidate = &job.Results[i]
+			break
+		}
+	}
+	if candidate == nil {
+		respond(http.StatusNotFound, "Portrait not found")
+		return
+	}
+
+	storagePath := fmt.Sprintf("avatars/%s/%s_%s.png", userID, avatar.ID, uuid.New().String())
+	imageURL, err := uploadImage(c.storage, candidate.URL, storagePath)
+	if err != nil {
+		log.Printf("Error storing portrait for avatar %s: %v", avatar.ID, err)
+		respond(http.StatusInternalServerError, "Failed to store the portrait")
+		return
+	}
+
+	previous := avatar.Snapshot()
+	avatar.ProfileImageURL = imageURL
+	avatar.UpdatedAt = time.Now().Unix()
+	services.GetModerationService().ScreenAvatar(context.Background(), avatar)
+
+	if err := c.versions.Update(context.Background(), avatar, previous, userID); err != nil {
+		log.Printf("Error updating avatar: %v", err)
+		respond(http.StatusInternalServerError, "Failed to update avatar")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, avatar)
+}
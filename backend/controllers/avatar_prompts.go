@@ -0,0 +1,126 @@
+package controllers
+
+import (
+	"backend/interfaces"
+	"backend/models"
+	"backend/services"
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getOwnedAvatar loads an avatar and verifies that the current user owns it
+func (ac *AvatarController) getOwnedAvatar(c *gin.Context) (*models.Avatar, bool) {
+	return loadOwnedAvatar(c, ac.db)
+}
+
+// loadOwnedAvatar loads the avatar of the "id" parameter and verifies that the current user
+// owns it. On failure the error response has been written.
+func loadOwnedAvatar(c *gin.Context, db interfaces.DatabaseService) (*models.Avatar, bool) {
+	avatarID := c.Param("id")
+	if avatarID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Avatar ID is required"})
+		return nil, false
+	}
+
+	userID := c.GetString("userId")
+	if userID == "" {
+		log.Printf("User ID not found in context")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return nil, false
+	}
+
+	avatar, err := db.GetAvatar(context.Background(), avatarID)
+	if err != nil {
+		log.Printf("Error getting avatar: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Avatar not found"})
+		return nil, false
+	}
+
+	if avatar.OwnerID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to update this avatar"})
+		return nil, false
+	}
+
+	return avatar, true
+}
+
+// GetAvatarPromptTemplates returns every version of the prompt templates overridden on an avatar
+func (ac *AvatarController) GetAvatarPromptTemplates(c *gin.Context) {
+	avatar, ok := ac.getOwnedAvatar(c)
+	if !ok {
+		return
+	}
+
+	templates := avatar.PromptTemplates
+	if templates == nil {
+		templates = models.PromptTemplateOverrides{}
+	}
+	c.JSON(http.StatusOK, gin.H{"templates": templates})
+}
+
+// SetAvatarPromptTemplate saves a new version of a prompt template override for an avatar
+func (ac *AvatarController) SetAvatarPromptTemplate(c *gin.Context) {
+	name := c.Param("name")
+	if !services.IsPromptTemplateName(name) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown prompt template"})
+		return
+	}
+
+	var req models.PromptTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := services.ValidatePromptTemplate(req.Text); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	avatar, ok := ac.getOwnedAvatar(c)
+	if !ok {
+		return
+	}
+
+	now := time.Now().Unix()
+	var template models.PromptTemplate
+	avatar.PromptTemplates, template = avatar.PromptTemplates.Add(name, req.Text, now)
+	avatar.UpdatedAt = now
+
+	if err := ac.db.UpdateAvatar(context.Background(), avatar); err != nil {
+		log.Printf("Error saving avatar prompt template: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update avatar"})
+		return
+	}
+
+	template.Source = models.PromptSourceAvatar
+	c.JSON(http.StatusOK, template)
+}
+
+// DeleteAvatarPromptTemplate removes an avatar's override, so the global template applies again
+func (ac *AvatarController) DeleteAvatarPromptTemplate(c *gin.Context) {
+	name := c.Param("name")
+	if !services.IsPromptTemplateName(name) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown prompt template"})
+		return
+	}
+
+	avatar, ok := ac.getOwnedAvatar(c)
+	if !ok {
+		return
+	}
+
+	avatar.PromptTemplates = avatar.PromptTemplates.Remove(name)
+	avatar.UpdatedAt = time.Now().Unix()
+
+	if err := ac.db.UpdateAvatar(context.Background(), avatar); err != nil {
+		log.Printf("Error deleting avatar prompt template: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update avatar"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Prompt template override removed"})
+}
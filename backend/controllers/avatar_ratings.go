@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"backend/models"
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newAvatarResponses adds ownership, the average rating and the user's own vote to avatars
+func (ac *AvatarController) newAvatarResponses(userID string, avatars []*models.Avatar) []models.AvatarResponse {
+	votes, err := ac.ratings.Votes(context.Background(), userID)
+	if err != nil {
+		// The votes are only shown, so the avatars are still listed without them
+		log.Printf("Error getting votes of user %s: %v", userID, err)
+	}
+
+	responses := []models.AvatarResponse{}
+	for _, avatar := range avatars {
+		vote := votes[avatar.ID]
+		responses = append(responses, models.AvatarResponse{
+			Avatar:        *avatar,
+			IsOwner:       userID != "" && avatar.OwnerID == userID,
+			RatingAverage: avatar.RatingAverage(),
+			Liked:         vote.Liked,
+			MyRating:      vote.Rating,
+		})
+	}
+	return responses
+}
+
+// LikeAvatar likes an avatar; liking it again changes nothing
+func (ac *AvatarController) LikeAvatar(c *gin.Context) {
+	ac.vote(c, func(userID, avatarID string) error {
+		_, err := ac.ratings.Like(context.Background(), userID, avatarID, true)
+		return err
+	})
+}
+
+// UnlikeAvatar removes the user's like from an avatar
+func (ac *AvatarController) UnlikeAvatar(c *gin.Context) {
+	ac.vote(c, func(userID, avatarID string) error {
+		_, err := ac.ratings.Like(context.Background(), userID, avatarID, false)
+		return err
+	})
+}
+
+// RateAvatar sets the user's rating of an avatar, replacing an earlier one
+func (ac *AvatarController) RateAvatar(c *gin.Context) {
+	var req models.AvatarRatingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("Error binding JSON: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Rating < models.MinAvatarRating || req.Rating > models.MaxAvatarRating {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Rating must be between 1 and 5"})
+		return
+	}
+
+	ac.vote(c, func(userID, avatarID string) error {
+		_, err := ac.ratings.Rate(context.Background(), userID, avatarID, req.Rating)
+		return err
+	})
+}
+
+// DeleteAvatarRating removes the user's rating of an avatar
+func (ac *AvatarController) DeleteAvatarRating(c *gin.Context) {
+	ac.vote(c, func(userID, avatarID string) error {
+		_, err := ac.ratings.Rate(context.Background(), userID, avatarID, 0)
+		return err
+	})
+}
+
+// vote checks that the user may vote on the avatar, applies the vote and returns the updated avatar.
+// Owners can't vote on their own avatars.
+func (ac *AvatarController) vote(c *gin.Context, apply func(userID, avatarID string) error) {
+	avatar, ok := ac.getReadableAvatar(c)
+	if !ok {
+		return
+	}
+
+	userID := c.GetString("userId")
+	if avatar.OwnerID == userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can't vote on your own avatar"})
+		return
+	}
+
+	if err := apply(userID, avatar.ID); err != nil {
+		log.Printf("Error voting on avatar %s: %v", avatar.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save vote"})
+		return
+	}
+
+	updated, err := ac.db.GetAvatar(context.Background(), avatar.ID)
+	if err != nil {
+		log.Printf("Error getting avatar: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get avatar"})
+		return
+	}
+
+	c.JSON(http.StatusOK, ac.newAvatarResponses(userID, []*models.Avatar{updated})[0])
+}
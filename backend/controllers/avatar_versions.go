@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"backend/models"
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getReadableAvatar loads an avatar that is public or owned by the current user
+func (ac *AvatarController) getReadableAvatar(c *gin.Context) (*models.Avatar, bool) {
+	avatarID := c.Param("id")
+	if avatarID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Avatar ID is required"})
+		return nil, false
+	}
+
+	userID := c.GetString("userId")
+	if userID == "" {
+		log.Printf("User ID not found in context")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return nil, false
+	}
+
+	avatar, err := ac.db.GetAvatar(context.Background(), avatarID)
+	if err != nil {
+		log.Printf("Error getting avatar: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Avatar not found"})
+		return nil, false
+	}
+
+	// Check if the avatar is public or belongs to the user
+	if !avatar.VisibleTo(userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You don't have access to this avatar"})
+		return nil, false
+	}
+
+	return avatar, true
+}
+
+// versionParam parses the :version path parameter
+func versionParam(c *gin.Context) (int, bool) {
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil || version < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid version"})
+		return 0, false
+	}
+	return version, true
+}
+
+// GetAvatarVersions lists the version history of an avatar, oldest first
+func (ac *AvatarController) GetAvatarVersions(c *gin.Context) {
+	avatar, ok := ac.getReadableAvatar(c)
+	if !ok {
+		return
+	}
+
+	versions, err := ac.versions.List(context.Background(), avatar.ID)
+	if err != nil {
+		log.Printf("Error getting avatar versions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get avatar versions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"currentVersion": avatar.Version,
+		"versions":       versions,
+	})
+}
+
+// GetAvatarVersion returns a single version of an avatar
+func (ac *AvatarController) GetAvatarVersion(c *gin.Context) {
+	avatar, ok := ac.getReadableAvatar(c)
+	if !ok {
+		return
+	}
+
+	version, ok := versionParam(c)
+	if !ok {
+		return
+	}
+
+	avatarVersion, err := ac.versions.Get(context.Background(), avatar.ID, version)
+	if err != nil {
+		log.Printf("Error getting avatar version: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, avatarVersion)
+}
+
+// RevertAvatar restores an earlier version of an avatar. The restored state is
+// recorded as a new version, so the revert itself can be undone.
+func (ac *AvatarController) RevertAvatar(c *gin.Context) {
+	avatar, ok := ac.getOwnedAvatar(c)
+	if !ok {
+		return
+	}
+
+	version, ok := versionParam(c)
+	if !ok {
+		return
+	}
+
+	if _, err := ac.versions.Get(context.Background(), avatar.ID, version); err != nil {
+		log.Printf("Error getting avatar version: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+		return
+	}
+
+	if _, err := ac.versions.Revert(context.Background(), avatar, version, c.GetString("userId")); err != nil {
+		log.Printf("Error reverting avatar: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revert avatar"})
+		return
+	}
+
+	// The restored text has to pass the same checks as an edit
+	if ac.moderation.ScreenAvatar(context.Background(), avatar) {
+		if err := ac.db.UpdateAvatar(context.Background(), avatar); err != nil {
+			log.Printf("Error saving avatar moderation state: %v", err)
+		}
+	}
+
+	c.JSON(http.StatusOK, avatar)
+}
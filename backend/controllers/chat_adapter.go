@@ -5,14 +5,13 @@ import (
 	"backend/models"
 	"backend/services"
 	"bufio"
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -21,12 +20,20 @@ import (
 )
 
 type ChatController struct {
-	db interfaces.DatabaseService
+	db          interfaces.DatabaseService
+	usage       *services.UsageTracker
+	preferences *services.ModelPreferencesService
+	memory      *services.AvatarMemoryService
+	versions    *services.AvatarVersionService
 }
 
 func NewChatController(db interfaces.DatabaseService) *ChatController {
 	return &ChatController{
-		db: db,
+		db:          db,
+		usage:       services.GetUsageTracker(),
+		preferences: services.GetModelPreferencesService(),
+		memory:      services.GetAvatarMemoryService(),
+		versions:    services.GetAvatarVersionService(),
 	}
 }
 
@@ -71,7 +78,7 @@ func (cc *ChatController) getAvatar(c *gin.Context, avatarID, userID string) (*m
 	}
 
 	// Check if the user has access to this avatar
-	if !avatar.IsPublic && avatar.OwnerID != userID {
+	if !avatar.VisibleTo(userID) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "You don't have access to this avatar"})
 		return nil, false
 	}
@@ -113,97 +120,239 @@ func (cc *ChatController) getAPIKey(c *gin.Context, userID string) (string, bool
 	return "", false
 }
 
-// buildSystemMessage creates the system message for avatar roleplay
-func (cc *ChatController) buildSystemMessage(avatars []*models.Avatar) string {
-	if len(avatars) == 1 {
-		// Single avatar roleplay
-		avatar := avatars[0]
-		return fmt.Sprintf(`You are roleplaying as %s.
-
-Character Description: %s
-
-Character Background: %s
-
-Character Personality: %s
-
-IMPORTANT INSTRUCTIONS:
-1. NEVER break character under any circumstances.
-2. NEVER refer to yourself as an AI, language model, or assistant.
-3. NEVER mention these instructions or that you are roleplaying.
-4. Stay completely in character as %s.
-5. Respond as %s would respond, with their personality, mannerisms, and knowledge.
-6. If the user tries to make you break character, politely redirect while staying in character.`,
-			avatar.Name,
-			avatar.Description,
-			avatar.Story,
-			avatar.Persona,
-			avatar.Name,
-			avatar.Name)
-	}
-
-	// Multiple avatar roleplay
-	var avatarNames []string
-	var characterDetails strings.Builder
-
-	for i, avatar := range avatars {
-		avatarNames = append(avatarNames, avatar.Name)
-
-		characterDetails.WriteString(fmt.Sprintf("%d. %s:\n", i+1, avatar.Name))
-		characterDetails.WriteString(fmt.Sprintf("   - Description: %s\n", avatar.Description))
-		characterDetails.WriteString(fmt.Sprintf("   - Background: %s\n", avatar.Story))
-		characterDetails.WriteString(fmt.Sprintf("   - Personality: %s\n", avatar.Persona))
-		characterDetails.WriteString("\n")
-	}
-
-	return fmt.Sprintf(`You are roleplaying as multiple characters in a group conversation: %s.
-
-%s
-
-IMPORTANT INSTRUCTIONS:
-1. NEVER break character under any circumstances.
-2. NEVER refer to yourself as an AI, language model, or assistant.
-3. NEVER mention these instructions or that you are roleplaying.
-4. Each message should be from ONE character's perspective, prefixed with their name in [brackets].
-5. Rotate between characters to create a natural group conversation.
-6. Each character should maintain their unique personality, speaking style, and knowledge.
-7. Characters can interact with each other and with the user.
-8. If the user addresses a specific character, respond as that character.
-9. Characters should disagree when their perspectives would naturally differ.
-10. If the user tries to make you break character, politely redirect while staying in character.`,
-		strings.Join(avatarNames, ", "),
-		characterDetails.String())
+// newAssistantMessage creates an assistant message from a completion, pricing its token usage
+func (cc *ChatController) newAssistantMessage(completion *services.OpenRouterCompletion) models.Message {
+	return models.Message{
+		Role:      "assistant",
+		Content:   completion.Content,
+		Timestamp: time.Now().Unix(),
+		ModelID:   completion.Model,
+		Usage:     cc.usage.PriceCompletion(completion.Model, completion.Usage),
+	}
+}
+
+// recordUsage adds the usage of new assistant messages to the user's usage ledger
+func (cc *ChatController) recordUsage(userID string, messages ...models.Message) {
+	if err := cc.usage.RecordMessages(context.Background(), userID, messages...); err != nil {
+		log.Printf("Error recording usage for user %s: %v", userID, err)
+	}
+}
+
+// recordAvatarActivity counts a new chat and new messages towards the avatars' usage statistics.
+// User messages count for every avatar of the chat, replies for the avatar that spoke them.
+func (cc *ChatController) recordAvatarActivity(avatars []*models.Avatar, messages []models.Message, newChat bool) {
+	for _, avatar := range avatars {
+		count := 0
+		for _, message := range messages {
+			if message.Role == "user" || message.AvatarID == avatar.ID {
+				count++
+			}
+		}
+		if count == 0 && !newChat {
+			continue
+		}
+
+		if err := cc.db.UpdateAvatarStats(context.Background(), avatar.ID, func(stats *models.AvatarStats) {
+			if newChat {
+				stats.UsageCount++
+			}
+			stats.MessageCount += count
+		}); err != nil {
+			log.Printf("Error recording usage of avatar %s: %v", avatar.ID, err)
+		}
+	}
 }
 
-// prepareMessagesForOpenRouter converts chat messages to OpenRouter format
-func (cc *ChatController) prepareMessagesForOpenRouter(chat *models.Chat, avatars []*models.Avatar, userMessage string) []services.OpenRouterMessage {
+// recordCallUsage prices a completion that is not stored as a chat message, such as a speaker
+// selection or a memory extraction, and adds it to the user's usage ledger
+func (cc *ChatController) recordCallUsage(userID string, completion *services.OpenRouterCompletion) *models.TokenUsage {
+	call := models.Message{
+		Role:      "system",
+		Timestamp: time.Now().Unix(),
+		ModelID:   completion.Model,
+		Usage:     cc.usage.PriceCompletion(completion.Model, completion.Usage),
+	}
+	cc.recordUsage(userID, call)
+	return call.Usage
+}
+
+// buildSystemMessage renders the system prompt template for the avatar whose turn it is
+func (cc *ChatController) buildSystemMessage(chat *models.Chat, speaker *models.Avatar, avatars []*models.Avatar) string {
+	prompt, err := services.GetPromptTemplateService().Render(chat, speaker, avatars)
+	if err != nil {
+		log.Printf("Error rendering system prompt for chat %s: %v", chat.ID, err)
+		return ""
+	}
+	return prompt.Content
+}
+
+// prepareMessagesForOpenRouter converts chat messages to OpenRouter format from the speaker's point of view.
+// In group chats, messages of the other avatars are passed as named user turns so the model only ever
+// writes as the speaker. With showImages the recent images of user turns are sent as image parts.
+func (cc *ChatController) prepareMessagesForOpenRouter(chat *models.Chat, avatars []*models.Avatar, speaker *models.Avatar, showImages bool) []services.OpenRouterMessage {
 	var messages []services.OpenRouterMessage
 
 	// Add system message
 	systemMessage := services.OpenRouterMessage{
 		Role:    "system",
-		Content: cc.buildSystemMessage(avatars),
+		Content: cc.buildSystemMessage(chat, speaker, avatars),
 	}
 	messages = append(messages, systemMessage)
 
-	// Add chat history
-	for _, msg := range chat.Messages {
+	// Add what the speaker remembers about the user from earlier chats
+	if memories := cc.relevantMemories(chat, speaker); memories != "" {
 		messages = append(messages, services.OpenRouterMessage{
-			Role:    msg.Role,
-			Content: msg.Content,
+			Role:    "system",
+			Content: memories,
+		})
+	}
+
+	// Add the lorebook entries triggered by the recent messages
+	if lore := cc.triggeredLore(chat, speaker); lore != "" {
+		messages = append(messages, services.OpenRouterMessage{
+			Role:    "system",
+			Content: lore,
 		})
 	}
 
-	// Add new user message if provided
-	if userMessage != "" {
+	// Tell the speaker how to send pictures if the chat allows it
+	if chat.AvatarImages {
 		messages = append(messages, services.OpenRouterMessage{
-			Role:    "user",
-			Content: userMessage,
+			Role:    "system",
+			Content: services.AvatarImageInstructions,
 		})
 	}
 
+	// Add the speaker's example dialogues as few-shot turns
+	messages = append(messages, cc.exampleDialogueMessages(speaker)...)
+
+	// Add chat history. Images the model doesn't see are described in the text.
+	var shown map[int]bool
+	if showImages {
+		shown = shownImageMessages(chat, avatars, speaker)
+	}
+	for i, msg := range chat.Messages {
+		content := msg.Content
+		if !shown[i] {
+			if pictures := services.DescribeAttachments(msg); pictures != "" {
+				content = strings.TrimSpace(content + "\n" + pictures)
+			}
+		}
+
+		message := services.OpenRouterMessage{
+			Role:    msg.Role,
+			Content: content,
+		}
+		if msg.Role != "user" && sentAsUserTurn(msg, avatars, speaker) {
+			message.Role = "user"
+			message.Content = fmt.Sprintf("%s: %s", services.SpeakerName(msg, avatars), content)
+		}
+		if shown[i] {
+			var imageURLs []string
+			for _, url := range services.MessageImageURLs(msg) {
+				imageURLs = append(imageURLs, services.ModelImageURL(url))
+			}
+			message.Parts = services.ImageContentParts(message.Content, imageURLs)
+		}
+
+		messages = append(messages, message)
+	}
+
 	return messages
 }
 
+// relevantMemories formats the speaker's memories that best match the recent conversation,
+// or returns an empty string if the speaker remembers nothing about the user
+func (cc *ChatController) relevantMemories(chat *models.Chat, speaker *models.Avatar) string {
+	recent := recentMessageText(chat, memoryContextMessages)
+	memories, err := cc.memory.Relevant(context.Background(), chat.UserID, speaker.ID, recent, maxInjectedMemories)
+	if err != nil {
+		log.Printf("Error loading memories of avatar %s: %v", speaker.ID, err)
+		return ""
+	}
+	if len(memories) == 0 {
+		return ""
+	}
+
+	var content strings.Builder
+	content.WriteString(fmt.Sprintf("What %s remembers about the user from earlier conversations:\n", speaker.Name))
+	for _, memory := range memories {
+		content.WriteString("- " + memory.Content + "\n")
+	}
+	return content.String()
+}
+
+// triggeredLore formats the lorebook entries of the chat and the speaker whose keywords appear
+// in the recent messages, or returns an empty string if none were triggered
+func (cc *ChatController) triggeredLore(chat *models.Chat, speaker *models.Avatar) string {
+	recent := recentMessageText(chat, services.LorebookScanDepth)
+	entries := services.SelectLoreEntries(recent, services.LorebookTokenBudget(), chat.Lorebook, speaker.Lorebook)
+	if len(entries) == 0 {
+		return ""
+	}
+
+	var content strings.Builder
+	content.WriteString("Background information relevant to the conversation:")
+	for _, entry := range entries {
+		content.WriteString("\n\n" + entry.Content)
+	}
+	return content.String()
+}
+
+// recentMessageText joins the content of the last count messages of the chat
+func recentMessageText(chat *models.Chat, count int) string {
+	var recent strings.Builder
+	start := len(chat.Messages) - count
+	if start < 0 {
+		start = 0
+	}
+	for _, msg := range chat.Messages[start:] {
+		recent.WriteString(msg.Content + "\n")
+	}
+	return recent.String()
+}
+
+// planTurn picks the avatars that reply next. The cost of letting the model pick
+// the speaker is added to the chat's and the user's usage.
+func (cc *ChatController) planTurn(openRouterService *services.OpenRouterService, userID string, chat *models.Chat, avatars []*models.Avatar, userMessage string) []*models.Avatar {
+	plan := services.NewGroupChatOrchestrator(openRouterService).PlanTurn(chat, avatars, userMessage)
+
+	if plan.Selection != nil {
+		chat.Usage.Add(cc.recordCallUsage(userID, plan.Selection))
+	}
+
+	return plan.Speakers
+}
+
+// generateReplies makes one completion per speaker, each with its own persona prompt, and appends
+// the replies to the chat so later speakers can react to earlier ones. If a later speaker fails,
+// the replies so far are kept.
+func (cc *ChatController) generateReplies(openRouterService *services.OpenRouterService, chat *models.Chat, avatars []*models.Avatar, speakers []*models.Avatar) ([]models.Message, error) {
+	var replies []models.Message
+
+	showImages := cc.showsImages(chat)
+	for _, speaker := range speakers {
+		messages := cc.prepareMessagesForOpenRouter(chat, avatars, speaker, showImages)
+		completion, err := openRouterService.SendCompletionWithFallback(chat.ModelChain(), messages)
+		if err != nil {
+			if len(replies) == 0 {
+				return nil, err
+			}
+			log.Printf("Error getting reply from %s, keeping earlier replies: %v", speaker.Name, err)
+			break
+		}
+
+		reply := cc.newAssistantMessage(completion)
+		reply.AvatarID = speaker.ID
+		cc.takeImageRequest(chat, &reply)
+		chat.Messages = append(chat.Messages, reply)
+		chat.Usage.Add(reply.Usage)
+		replies = append(replies, reply)
+	}
+
+	return replies, nil
+}
+
 // GetChats returns all chats for the user
 func (cc *ChatController) GetChats(c *gin.Context) {
 	userID, ok := cc.getUserID(c)
@@ -278,6 +427,37 @@ func (cc *ChatController) CreateChat(c *gin.Context) {
 		return
 	}
 
+	if !req.TurnStrategy.IsValid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid turn strategy"})
+		return
+	}
+
+	if len(req.FallbackModelIDs) > models.MaxFallbackModels {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d fallback models are allowed", models.MaxFallbackModels)})
+		return
+	}
+
+	if req.GreetingIndex != nil && (*req.GreetingIndex < 0 || *req.GreetingIndex >= len(avatars[0].Greetings)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid greeting index"})
+		return
+	}
+
+	// Fall back to the user's model preferences if no model was requested
+	modelID := req.ModelID
+	if modelID == "" {
+		resolved, err := cc.preferences.ResolveModel(context.Background(), userID, avatarIDs)
+		if err != nil {
+			log.Printf("Error getting model preferences: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get model preferences"})
+			return
+		}
+		modelID = resolved
+		if modelID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "modelId is required when no default model is set"})
+			return
+		}
+	}
+
 	// Get user's API key
 	apiKey, ok := cc.getAPIKey(c, userID)
 	if !ok {
@@ -305,30 +485,47 @@ func (cc *ChatController) CreateChat(c *gin.Context) {
 		Title:     chatTitle,
 		CreatedAt: now,
 		UpdatedAt: now,
-		ModelID:   req.ModelID,
+		ModelID:   modelID,
 		AvatarIDs: avatarIDs,
 		AvatarID:  avatarIDs[0], // For backward compatibility, use the first avatar
 		Messages:  []models.Message{},
-	}
 
-	// Send initial message to OpenRouter
-	openRouterService := services.NewOpenRouterService(apiKey)
-	messages := cc.prepareMessagesForOpenRouter(&chat, avatars, "")
+		FallbackModelIDs: req.FallbackModelIDs,
+		TurnStrategy:     req.TurnStrategy,
+		AvatarImages:     req.AvatarImages,
+	}
 
-	log.Printf("Sending welcome message to OpenRouter with model ID: %s", req.ModelID)
-	response, err := openRouterService.SendMessage(req.ModelID, messages)
-	if err != nil {
-		log.Printf("Error from OpenRouter: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+	if req.PinAvatarVersions {
+		chat.AvatarVersions = make(map[string]int)
+		for _, avatar := range avatars {
+			version, err := cc.versions.CurrentVersion(context.Background(), avatar)
+			if err != nil {
+				log.Printf("Error pinning avatar %s: %v", avatar.ID, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to pin avatar versions"})
+				return
+			}
+			chat.AvatarVersions[avatar.ID] = version
+		}
 	}
 
-	// Add assistant's welcome message to the chat
-	chat.Messages = append(chat.Messages, models.Message{
-		Role:      "assistant",
-		Content:   response,
-		Timestamp: now,
-	})
+	openRouterService := services.NewOpenRouterService(apiKey).WithContext(c.Request.Context())
+
+	// The first avatar opens with one of its greetings, or with a welcome message written by the model
+	if greeting, ok := pickGreeting(avatars[0], req.GreetingIndex); ok {
+		chat.Messages = append(chat.Messages, models.Message{
+			Role:      "assistant",
+			Content:   greeting,
+			Timestamp: now,
+			AvatarID:  avatars[0].ID,
+		})
+	} else {
+		log.Printf("Sending welcome message to OpenRouter with model ID: %s", modelID)
+		if _, err := cc.generateReplies(openRouterService, &chat, avatars, avatars[:1]); err != nil {
+			log.Printf("Error from OpenRouter: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
 
 	// If the user provided an initial message, add it too
 	if req.Message != "" {
@@ -338,35 +535,29 @@ func (cc *ChatController) CreateChat(c *gin.Context) {
 			Timestamp: now,
 		})
 
-		// Get a response to the user's message
-		messages = cc.prepareMessagesForOpenRouter(&chat, avatars, "")
-		messages = append(messages, services.OpenRouterMessage{
-			Role:    "user",
-			Content: req.Message,
-		})
+		cc.rememberFromCommand(&chat, avatars, req.Message)
 
-		userResponse, err := openRouterService.SendMessage(req.ModelID, messages)
-		if err != nil {
+		// Get a response to the user's message
+		speakers := cc.planTurn(openRouterService, userID, &chat, avatars, req.Message)
+		if _, err := cc.generateReplies(openRouterService, &chat, avatars, speakers); err != nil {
 			log.Printf("Error from OpenRouter for user message: %v", err)
 			// Continue anyway, we at least have the welcome message
-		} else {
-			// Add the response to the user's message
-			chat.Messages = append(chat.Messages, models.Message{
-				Role:      "assistant",
-				Content:   userResponse,
-				Timestamp: time.Now().Unix(),
-			})
 		}
 	}
 
 	// Save chat to database
-	err = cc.db.SaveChat(context.Background(), &chat)
+	err := cc.db.SaveChat(context.Background(), &chat)
 	if err != nil {
 		log.Printf("Error saving chat to database: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save chat"})
 		return
 	}
 
+	cc.recordUsage(userID, chat.Messages...)
+	cc.startChatImages(&chat)
+
+	cc.recordAvatarActivity(avatars, chat.Messages, true)
+
 	c.JSON(http.StatusOK, chat)
 }
 
@@ -378,14 +569,18 @@ func (cc *ChatController) SendMessage(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		Message string `json:"message" binding:"required"`
-	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, 50<<20) // 50MB limit for images
+
+	var req models.ChatMessageRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		log.Printf("Error binding JSON: %v", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if strings.TrimSpace(req.Message) == "" && len(req.Images) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A message or an image is required"})
+		return
+	}
 
 	userID, ok := cc.getUserID(c)
 	if !ok {
@@ -398,25 +593,20 @@ func (cc *ChatController) SendMessage(c *gin.Context) {
 		return
 	}
 
-	// Handle backward compatibility for avatarIDs
-	var avatarIDs []string
-	if len(chat.AvatarIDs) > 0 {
-		avatarIDs = chat.AvatarIDs
-	} else if chat.AvatarID != "" {
-		avatarIDs = []string{chat.AvatarID}
-	} else {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "No avatars associated with this chat"})
+	// Get the avatars for this chat, as of the versions the chat is pinned to
+	avatars, ok := cc.getChatAvatars(c, chat, userID)
+	if !ok {
 		return
 	}
 
-	// Get the avatars for this chat
-	avatars, ok := cc.getMultipleAvatars(c, avatarIDs, userID)
+	// Get user's API key
+	apiKey, ok := cc.getAPIKey(c, userID)
 	if !ok {
 		return
 	}
 
-	// Get user's API key
-	apiKey, ok := cc.getAPIKey(c, userID)
+	// Store the images sent with the message
+	attachments, ok := cc.uploadMessageImages(c, apiKey, chat, req.Images)
 	if !ok {
 		return
 	}
@@ -424,40 +614,54 @@ func (cc *ChatController) SendMessage(c *gin.Context) {
 	// Add the user message to the chat
 	now := time.Now().Unix()
 	userMessage := models.Message{
-		Role:      "user",
-		Content:   req.Message,
-		Timestamp: now,
+		Role:        "user",
+		Content:     req.Message,
+		Timestamp:   now,
+		Attachments: attachments,
 	}
 	chat.Messages = append(chat.Messages, userMessage)
 	chat.UpdatedAt = now
 
-	// Prepare messages for OpenRouter
-	messages := cc.prepareMessagesForOpenRouter(chat, avatars, "")
+	// Image commands are answered with a picture instead of avatar replies
+	if prompt, ok := services.ParseImageCommand(req.Message); ok {
+		last := &chat.Messages[len(chat.Messages)-1]
+		last.Attachments = append(last.Attachments, cc.requestImage(chat, prompt))
+		if err := cc.saveChat(chat); err != nil {
+			log.Printf("Error updating chat: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update chat"})
+			return
+		}
+		cc.startChatImages(chat)
+		cc.recordAvatarActivity(avatars, chat.Messages[len(chat.Messages)-1:], false)
+		c.JSON(http.StatusOK, chat)
+		return
+	}
+
+	cc.rememberFromCommand(chat, avatars, req.Message)
 
-	// Send message to OpenRouter
-	openRouterService := services.NewOpenRouterService(apiKey)
-	response, err := openRouterService.SendMessage(chat.ModelID, messages)
+	// Send message to OpenRouter, one completion per avatar whose turn it is
+	openRouterService := services.NewOpenRouterService(apiKey).WithContext(c.Request.Context())
+	speakers := cc.planTurn(openRouterService, userID, chat, avatars, req.Message)
+	replies, err := cc.generateReplies(openRouterService, chat, avatars, speakers)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-
-	// Add assistant's response to messages
-	chat.Messages = append(chat.Messages, models.Message{
-		Role:      "assistant",
-		Content:   response,
-		Timestamp: time.Now().Unix(),
-	})
 	chat.UpdatedAt = now
 
 	// Update chat in database
-	err = cc.db.UpdateChat(context.Background(), chat)
+	err = cc.saveChat(chat)
 	if err != nil {
 		log.Printf("Error updating chat: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update chat"})
 		return
 	}
 
+	cc.recordUsage(userID, replies...)
+	cc.startChatImages(chat)
+	cc.recordAvatarActivity(avatars, append([]models.Message{userMessage}, replies...), false)
+	cc.extractMemoriesInBackground(apiKey, chat, avatars)
+
 	c.JSON(http.StatusOK, chat)
 }
 
@@ -469,14 +673,18 @@ func (cc *ChatController) SendMessageStream(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		Message string `json:"message" binding:"required"`
-	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, 50<<20) // 50MB limit for images
+
+	var req models.ChatMessageRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		log.Printf("Error binding JSON: %v", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if strings.TrimSpace(req.Message) == "" && len(req.Images) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A message or an image is required"})
+		return
+	}
 
 	log.Printf("Received streaming request for chat %s with message: %s", chatID, req.Message)
 
@@ -491,25 +699,20 @@ func (cc *ChatController) SendMessageStream(c *gin.Context) {
 		return
 	}
 
-	// Handle backward compatibility for avatarIDs
-	var avatarIDs []string
-	if len(chat.AvatarIDs) > 0 {
-		avatarIDs = chat.AvatarIDs
-	} else if chat.AvatarID != "" {
-		avatarIDs = []string{chat.AvatarID}
-	} else {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "No avatars associated with this chat"})
+	// Get the avatars for this chat, as of the versions the chat is pinned to
+	avatars, ok := cc.getChatAvatars(c, chat, userID)
+	if !ok {
 		return
 	}
 
-	// Get the avatars for this chat
-	avatars, ok := cc.getMultipleAvatars(c, avatarIDs, userID)
+	// Get user's API key
+	apiKey, ok := cc.getAPIKey(c, userID)
 	if !ok {
 		return
 	}
 
-	// Get user's API key
-	apiKey, ok := cc.getAPIKey(c, userID)
+	// Store the images sent with the message
+	attachments, ok := cc.uploadMessageImages(c, apiKey, chat, req.Images)
 	if !ok {
 		return
 	}
@@ -517,22 +720,28 @@ func (cc *ChatController) SendMessageStream(c *gin.Context) {
 	// Add user message to chat
 	now := time.Now().Unix()
 	chat.Messages = append(chat.Messages, models.Message{
-		Role:      "user",
-		Content:   req.Message,
-		Timestamp: now,
+		Role:        "user",
+		Content:     req.Message,
+		Timestamp:   now,
+		Attachments: attachments,
 	})
 	chat.UpdatedAt = now
 
+	// Image commands are answered with a picture instead of avatar replies
+	prompt, isImageCommand := services.ParseImageCommand(req.Message)
+	if isImageCommand {
+		last := &chat.Messages[len(chat.Messages)-1]
+		last.Attachments = append(last.Attachments, cc.requestImage(chat, prompt))
+	}
+
 	// Update chat in database with the user message FIRST
 	log.Printf("Saving user message to database for chat %s", chatID)
-	err := cc.db.UpdateChat(context.Background(), chat)
+	err := cc.saveChat(chat)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to save chat: %v", err)})
 		return
 	}
-
-	// Prepare messages for OpenRouter
-	messages := cc.prepareMessagesForOpenRouter(chat, avatars, "")
+	cc.recordAvatarActivity(avatars, chat.Messages[len(chat.Messages)-1:], false)
 
 	// Set headers for SSE
 	c.Writer.Header().Set("Content-Type", "text/event-stream")
@@ -540,123 +749,170 @@ func (cc *ChatController) SendMessageStream(c *gin.Context) {
 	c.Writer.Header().Set("Connection", "keep-alive")
 	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 
-	// Create a request to OpenRouter
-	reqBody := services.OpenRouterRequest{
-		Model:    chat.ModelID,
-		Messages: messages,
-		Stream:   true,
-	}
-
-	jsonBody, err := json.Marshal(reqBody)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to marshal request: %v", err)})
+	if isImageCommand {
+		cc.startChatImages(chat)
+		if err := cc.writeAttachmentEvent(c, chat, len(chat.Messages)-1); err == nil {
+			c.Writer.WriteString("data: [DONE]\n\n")
+			c.Writer.Flush()
+		}
 		return
 	}
 
-	httpReq, err := http.NewRequest("POST", services.OPENROUTER_API_URL, bytes.NewBuffer(jsonBody))
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to create request: %v", err)})
-		return
-	}
-
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
-	httpReq.Header.Set("HTTP-Referer", "https://github.com/") // Required by OpenRouter
-	httpReq.Header.Set("X-Title", "Chat Application")
-
-	// Create a channel to collect the full response
-	fullResponseChan := make(chan string, 1)
-
-	// Log that we're starting to stream
-	log.Printf("Starting to stream response for chat %s", chatID)
-
-	// Create a pipe to duplicate the response body
-	pr, pw := io.Pipe()
-
-	// Start a goroutine to collect the full response from the pipe
-	go func() {
-		defer pr.Close()
-		var fullResponse strings.Builder
-		scanner := bufio.NewScanner(pr)
-
-		for scanner.Scan() {
-			line := scanner.Text()
-			if strings.HasPrefix(line, "data: ") && line != "data: [DONE]" {
-				jsonStr := strings.TrimPrefix(line, "data: ")
-				if jsonStr != "" {
-					var streamResp services.OpenRouterStreamResponse
-					if err := json.Unmarshal([]byte(jsonStr), &streamResp); err == nil {
-						if len(streamResp.Choices) > 0 {
-							content := streamResp.Choices[0].Delta.Content
-							if content != "" {
-								fullResponse.WriteString(content)
-							}
-						}
-					}
-				}
+	cc.rememberFromCommand(chat, avatars, req.Message)
+
+	// Stream one reply per avatar whose turn it is
+	openRouterService := services.NewOpenRouterService(apiKey).WithContext(c.Request.Context())
+	speakers := cc.planTurn(openRouterService, userID, chat, avatars, req.Message)
+
+	var replies []models.Message
+	showImages := cc.showsImages(chat)
+	for i, speaker := range speakers {
+		messages := cc.prepareMessagesForOpenRouter(chat, avatars, speaker, showImages)
+
+		// Open the stream to OpenRouter, retrying and falling back to other models if needed
+		resp, usedModel, err := openRouterService.OpenStreamWithFallback(chat.ModelChain(), messages)
+		if err != nil {
+			if i == 0 {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("OpenRouter API error: %v", err)})
+				return
 			}
+			log.Printf("Error opening stream for %s, keeping earlier replies: %v", speaker.Name, err)
+			break
 		}
 
-		fullResponseChan <- fullResponse.String()
-	}()
+		// Log that we're starting to stream
+		log.Printf("Starting to stream response from %s for chat %s", speaker.Name, chatID)
 
-	// Make the HTTP request
-	client := &http.Client{}
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to send request: %v", err)})
-		return
+		completion, err := cc.streamReply(c, resp, usedModel, speaker)
+		resp.Body.Close()
+
+		if completion.Content != "" {
+			// Add the assistant's response to the chat so the next speaker sees it
+			assistantMessage := cc.newAssistantMessage(completion)
+			assistantMessage.AvatarID = speaker.ID
+			cc.takeImageRequest(chat, &assistantMessage)
+			chat.Messages = append(chat.Messages, assistantMessage)
+			chat.Usage.Add(assistantMessage.Usage)
+			replies = append(replies, assistantMessage)
+
+			if err == nil && len(assistantMessage.Attachments) > 0 {
+				err = cc.writeAttachmentEvent(c, chat, len(chat.Messages)-1)
+			}
+		}
+
+		if err != nil {
+			log.Printf("Error writing to client: %v", err)
+			break
+		}
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("OpenRouter API error: %s", string(body))})
-		return
+	// End the stream once every speaker replied
+	c.Writer.WriteString("data: [DONE]\n\n")
+	c.Writer.Flush()
+
+	if len(replies) > 0 {
+		chat.UpdatedAt = time.Now().Unix()
+
+		// Save the updated chat to database
+		err = cc.saveChat(chat)
+		if err != nil {
+			log.Printf("Error saving streamed response to database: %v", err)
+		} else {
+			log.Printf("Successfully saved streamed response to database for chat %s", chatID)
+			cc.recordUsage(userID, replies...)
+			cc.startChatImages(chat)
+			cc.recordAvatarActivity(avatars, replies, false)
+			cc.extractMemoriesInBackground(apiKey, chat, avatars)
+		}
 	}
+}
 
-	// Create a TeeReader to duplicate the response body
-	teeReader := io.TeeReader(resp.Body, pw)
+// streamReply forwards one streamed completion to the client and collects it.
+// Each reply is preceded by a "speaker" event naming the avatar; the [DONE] marker
+// is held back and sent once after the last reply of the turn. Image requests are
+// removed from the forwarded text and sent as attachment events instead.
+func (cc *ChatController) streamReply(c *gin.Context, resp *http.Response, usedModel string, speaker *models.Avatar) (*services.OpenRouterCompletion, error) {
+	var fullResponse strings.Builder
+	completion := &services.OpenRouterCompletion{Model: usedModel}
+	filter := &services.ImageRequestFilter{}
 
-	// Stream the response to the client
-	scanner := bufio.NewScanner(teeReader)
+	speakerEvent, _ := json.Marshal(gin.H{"avatarId": speaker.ID, "name": speaker.Name})
+	if _, err := c.Writer.WriteString(fmt.Sprintf("event: speaker\ndata: %s\n\n", speakerEvent)); err != nil {
+		return completion, err
+	}
+	c.Writer.Flush()
+
+	scanner := bufio.NewScanner(resp.Body)
 	for scanner.Scan() {
 		line := scanner.Text()
 
+		if line == "data: [DONE]" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "data: ") {
+			var streamResp services.OpenRouterStreamResponse
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &streamResp); err == nil {
+				if len(streamResp.Choices) > 0 {
+					delta := streamResp.Choices[0].Delta.Content
+					fullResponse.WriteString(delta)
+					if shown := filter.Write(delta); shown != delta {
+						line = "data: " + withDeltaContent(strings.TrimPrefix(line, "data: "), shown)
+					}
+				}
+				if streamResp.Model != "" {
+					completion.Model = streamResp.Model
+				}
+				// The usage arrives in the last chunk
+				if streamResp.Usage != nil {
+					completion.Usage = streamResp.Usage
+				}
+			}
+		}
+
 		// Write the SSE data to the client
-		_, err := c.Writer.WriteString(line + "\n")
-		if err != nil {
-			log.Printf("Error writing to client: %v", err)
-			break
+		if _, err := c.Writer.WriteString(line + "\n"); err != nil {
+			completion.Content = fullResponse.String()
+			return completion, err
 		}
 
 		// Flush the response to ensure real-time streaming
 		c.Writer.Flush()
 	}
 
-	// Close the writer to signal the goroutine
-	pw.Close()
+	completion.Content = fullResponse.String()
 
-	// Wait for the full response and save it to the database
-	fullResponse := <-fullResponseChan
+	// Send the text held back for an image request that was never closed
+	if held := filter.Flush(); held != "" {
+		chunk, _ := json.Marshal(gin.H{"model": completion.Model, "choices": []gin.H{{"index": 0, "delta": gin.H{"content": held}}}})
+		if _, err := c.Writer.WriteString(fmt.Sprintf("data: %s\n\n", chunk)); err != nil {
+			return completion, err
+		}
+		c.Writer.Flush()
+	}
 
-	if fullResponse != "" {
-		// Add the assistant's response to the chat
-		chat.Messages = append(chat.Messages, models.Message{
-			Role:      "assistant",
-			Content:   fullResponse,
-			Timestamp: time.Now().Unix(),
-		})
-		chat.UpdatedAt = time.Now().Unix()
+	return completion, nil
+}
 
-		// Save the updated chat to database
-		err = cc.db.UpdateChat(context.Background(), chat)
-		if err != nil {
-			log.Printf("Error saving streamed response to database: %v", err)
-		} else {
-			log.Printf("Successfully saved streamed response to database for chat %s", chatID)
+// withDeltaContent replaces the text of a streamed chunk, keeping its other fields
+func withDeltaContent(data, content string) string {
+	var chunk map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &chunk); err == nil {
+		if choices, ok := chunk["choices"].([]interface{}); ok && len(choices) > 0 {
+			if choice, ok := choices[0].(map[string]interface{}); ok {
+				if delta, ok := choice["delta"].(map[string]interface{}); ok {
+					delta["content"] = content
+					if updated, err := json.Marshal(chunk); err == nil {
+						return string(updated)
+					}
+				}
+			}
 		}
 	}
+
+	// The chunk parsed as a stream response before, so this is not expected
+	fallback, _ := json.Marshal(gin.H{"choices": []gin.H{{"index": 0, "delta": gin.H{"content": content}}}})
+	return string(fallback)
 }
 
 // SetAPIKey sets or updates the user's OpenRouter API key
@@ -692,29 +948,59 @@ func (cc *ChatController) SetAPIKey(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "API key updated successfully"})
 }
 
-// GetModels returns the list of available models from OpenRouter
+// GetModels returns the available models from the cached model catalog.
+// Supported query parameters: q, modality, minContext, maxPromptPrice, maxCompletionPrice, free.
 func (cc *ChatController) GetModels(c *gin.Context) {
-	userID, ok := cc.getUserID(c)
-	if !ok {
-		return
-	}
-
-	// Get user's API key
-	apiKey, ok := cc.getAPIKey(c, userID)
-	if !ok {
+	filter, err := parseModelFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Get models from OpenRouter
-	openRouterService := services.NewOpenRouterService(apiKey)
-	models, err := openRouterService.GetModels()
+	// Get models from the catalog
+	available, err := services.GetModelCatalog().Search(filter)
 	if err != nil {
 		log.Printf("Error getting models from OpenRouter: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, models)
+	c.JSON(http.StatusOK, available)
+}
+
+// parseModelFilter reads the model catalog filter from the query string
+func parseModelFilter(c *gin.Context) (services.ModelFilter, error) {
+	filter := services.ModelFilter{
+		Query:    c.Query("q"),
+		Modality: c.Query("modality"),
+		FreeOnly: c.Query("free") == "true",
+	}
+
+	if value := c.Query("minContext"); value != "" {
+		minContext, err := strconv.Atoi(value)
+		if err != nil || minContext < 0 {
+			return filter, fmt.Errorf("minContext must be a non-negative number")
+		}
+		filter.MinContext = minContext
+	}
+
+	if value := c.Query("maxPromptPrice"); value != "" {
+		price, err := strconv.ParseFloat(value, 64)
+		if err != nil || price < 0 {
+			return filter, fmt.Errorf("maxPromptPrice must be a non-negative number")
+		}
+		filter.MaxPromptPrice = &price
+	}
+
+	if value := c.Query("maxCompletionPrice"); value != "" {
+		price, err := strconv.ParseFloat(value, 64)
+		if err != nil || price < 0 {
+			return filter, fmt.Errorf("maxCompletionPrice must be a non-negative number")
+		}
+		filter.MaxCompletionPrice = &price
+	}
+
+	return filter, nil
 }
 
 // GetAPIKeyStatus checks if the user has set an API key
@@ -747,7 +1033,7 @@ func (cc *ChatController) GetCredits(c *gin.Context) {
 	}
 
 	// Get credits from OpenRouter
-	openRouterService := services.NewOpenRouterService(apiKey)
+	openRouterService := services.NewOpenRouterService(apiKey).WithContext(c.Request.Context())
 	credits, err := openRouterService.GetCredits()
 	if err != nil {
 		log.Printf("Error getting credits from OpenRouter: %v", err)
@@ -785,4 +1071,30 @@ func (cc *ChatController) DeleteChat(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Chat deleted successfully"})
-} 
\ No newline at end of file
+} 
+
+// GetUsage returns the user's token usage and cost, broken down by day and model
+func (cc *ChatController) GetUsage(c *gin.Context) {
+	userID, ok := cc.getUserID(c)
+	if !ok {
+		return
+	}
+
+	days := 30
+	if daysParam := c.Query("days"); daysParam != "" {
+		parsed, err := strconv.Atoi(daysParam)
+		if err != nil || parsed < 1 || parsed > services.UsageLedgerDays {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("days must be a number between 1 and %d", services.UsageLedgerDays)})
+			return
+		}
+		days = parsed
+	}
+
+	report, err := cc.usage.GetReport(context.Background(), userID, days)
+	if err != nil {
+		log.Printf("Error loading usage of user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load usage"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
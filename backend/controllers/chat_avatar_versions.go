@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"backend/models"
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PinAvatarVersion pins a chat to a version of one of its avatars, so later edits of the
+// avatar don't change how it behaves in this chat. Version 0 removes the pin.
+func (cc *ChatController) PinAvatarVersion(c *gin.Context) {
+	var req models.AvatarVersionPinRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("Error binding JSON: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Version < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid version"})
+		return
+	}
+
+	chat, ok := cc.getOwnedChat(c)
+	if !ok {
+		return
+	}
+
+	inChat := req.AvatarID == chat.AvatarID
+	for _, avatarID := range chat.AvatarIDs {
+		inChat = inChat || avatarID == req.AvatarID
+	}
+	if !inChat {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Avatar is not part of this chat"})
+		return
+	}
+
+	if req.Version == 0 {
+		delete(chat.AvatarVersions, req.AvatarID)
+	} else {
+		if _, ok := cc.getAvatar(c, req.AvatarID, chat.UserID); !ok {
+			return
+		}
+		if _, err := cc.versions.Get(context.Background(), req.AvatarID, req.Version); err != nil {
+			log.Printf("Error getting avatar version: %v", err)
+			c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+			return
+		}
+
+		if chat.AvatarVersions == nil {
+			chat.AvatarVersions = make(map[string]int)
+		}
+		chat.AvatarVersions[req.AvatarID] = req.Version
+	}
+
+	chat.UpdatedAt = time.Now().Unix()
+	if err := cc.saveChat(chat); err != nil {
+		log.Printf("Error updating chat: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update chat"})
+		return
+	}
+
+	c.JSON(http.StatusOK, chat)
+}
@@ -0,0 +1,399 @@
+package controllers
+
+import (
+	"backend/interfaces"
+	"backend/models"
+	"backend/services"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// chatSaveLocks serialize the saves of a chat, picked by a hash of the chat ID, so a finished
+// image and a reply saved at the same time don't overwrite each other
+var chatSaveLocks [64]sync.Mutex
+
+// lockChat locks the saves of a chat and returns the unlock function
+func lockChat(chatID string) func() {
+	hash := fnv.New32a()
+	hash.Write([]byte(chatID))
+	mu := &chatSaveLocks[hash.Sum32()%uint32(len(chatSaveLocks))]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// saveChat saves a chat that was loaded earlier in the request. Images that finished since
+// then are taken from the stored chat, so a slow reply doesn't reset them to pending.
+func (cc *ChatController) saveChat(chat *models.Chat) error {
+	defer lockChat(chat.ID)()
+
+	stored, err := cc.db.GetChat(context.Background(), chat.ID)
+	if err == nil {
+		finished := make(map[string]models.MessageAttachment)
+		for _, msg := range stored.Messages {
+			for _, attachment := range msg.Attachments {
+				if attachment.JobID != "" && attachment.Status != models.AttachmentStatusPending {
+					finished[attachment.JobID] = attachment
+				}
+			}
+		}
+
+		for i := range chat.Messages {
+			for j, attachment := range chat.Messages[i].Attachments {
+				if update, ok := finished[attachment.JobID]; ok && attachment.Status == models.AttachmentStatusPending {
+					chat.Messages[i].Attachments[j] = update
+				}
+			}
+		}
+	}
+
+	return cc.db.UpdateChat(context.Background(), chat)
+}
+
+// maxHistoryImages caps the images of earlier messages sent to vision models; older ones
+// are described in text
+const maxHistoryImages = 8
+
+// UpdateAvatarImages allows or forbids the avatars of a chat to send generated pictures
+func (cc *ChatController) UpdateAvatarImages(c *gin.Context) {
+	chatID := c.Param("chatID")
+	if chatID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Chat ID is required"})
+		return
+	}
+
+	var req models.AvatarImagesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, ok := cc.getUserID(c)
+	if !ok {
+		return
+	}
+
+	// Get the chat and verify ownership
+	chat, ok := cc.getChat(c, chatID, userID, true)
+	if !ok {
+		return
+	}
+
+	chat.AvatarImages = *req.Enabled
+	chat.UpdatedAt = time.Now().Unix()
+
+	if err := cc.saveChat(chat); err != nil {
+		log.Printf("Error updating avatar images setting: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update chat"})
+		return
+	}
+
+	c.JSON(http.StatusOK, chat)
+}
+
+// uploadMessageImages stores the base64 images a user sends with a message and returns them as
+// attachments. The chat's model must accept images. On failure the error response has been written.
+func (cc *ChatController) uploadMessageImages(c *gin.Context, apiKey string, chat *models.Chat, images []string) ([]models.MessageAttachment, bool) {
+	if len(images) == 0 {
+		return nil, true
+	}
+	if len(images) > models.MaxMessageImages {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d images can be sent with a message", models.MaxMessageImages)})
+		return nil, false
+	}
+	extensions := make([]string, len(images))
+	for i, image := range images {
+		extension, ok := services.ImageDataURIExtension(image)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Images must be base64 data URIs of PNG, JPEG, GIF or WebP images"})
+			return nil, false
+		}
+		extensions[i] = extension
+	}
+
+	accepts, err := cc.acceptsImages(chat.ModelID)
+	if err != nil {
+		log.Printf("Error checking image support of model %s: %v", chat.ModelID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to check whether the model accepts images"})
+		return nil, false
+	}
+	if !accepts {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("The model %s doesn't accept images", chat.ModelID)})
+		return nil, false
+	}
+
+	var attachments []models.MessageAttachment
+	for i, image := range images {
+		storagePath := fmt.Sprintf("chats/%s/%s/%s.%s", chat.UserID, chat.ID, uuid.New().String(), extensions[i])
+		url, err := services.GetStorageService().UploadBase64Image(image, storagePath)
+		if err != nil {
+			log.Printf("Error uploading chat image: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload image to storage"})
+			return nil, false
+		}
+
+		attachments = append(attachments, models.MessageAttachment{
+			Type:        models.AttachmentTypeImage,
+			Status:      models.AttachmentStatusReady,
+			URL:         url,
+			StoragePath: storagePath,
+		})
+	}
+	return attachments, true
+}
+
+// acceptsImages looks up in the model catalog whether a model takes images as input
+func (cc *ChatController) acceptsImages(modelID string) (bool, error) {
+	model, err := services.GetModelCatalog().Get(modelID)
+	if err != nil {
+		return false, err
+	}
+	return model.AcceptsImages(), nil
+}
+
+// showsImages reports whether the images of the chat are sent to its model. Models that don't
+// accept images, or whose capabilities are unknown, get the images described in text.
+func (cc *ChatController) showsImages(chat *models.Chat) bool {
+	hasImages := false
+	for _, msg := range chat.Messages {
+		if len(services.MessageImageURLs(msg)) > 0 {
+			hasImages = true
+			break
+		}
+	}
+	if !hasImages {
+		return false
+	}
+
+	accepts, err := cc.acceptsImages(chat.ModelID)
+	if err != nil {
+		log.Printf("Error checking image support of model %s, describing images instead: %v", chat.ModelID, err)
+		return false
+	}
+	return accepts
+}
+
+// shownImageMessages picks the messages whose images are sent to a vision model, newest first
+// until maxHistoryImages images are included. Only user turns can carry images, so the speaker's
+// own messages are left out.
+func shownImageMessages(chat *models.Chat, avatars []*models.Avatar, speaker *models.Avatar) map[int]bool {
+	shown := make(map[int]bool)
+	count := 0
+	for i := len(chat.Messages) - 1; i >= 0; i-- {
+		msg := chat.Messages[i]
+		if !sentAsUserTurn(msg, avatars, speaker) {
+			continue
+		}
+
+		images := len(services.MessageImageURLs(msg))
+		if images == 0 {
+			continue
+		}
+		if count+images > maxHistoryImages {
+			break
+		}
+		count += images
+		shown[i] = true
+	}
+	return shown
+}
+
+// sentAsUserTurn reports whether a message is passed to the speaker's model as a user turn:
+// the user's messages and, in group chats, the messages of the other avatars
+func sentAsUserTurn(msg models.Message, avatars []*models.Avatar, speaker *models.Avatar) bool {
+	if msg.Role == "user" {
+		return true
+	}
+	return len(avatars) > 1 && msg.Role == "assistant" && msg.AvatarID != "" && msg.AvatarID != speaker.ID
+}
+
+// requestImage creates the image job for a picture requested in a chat and returns the pending
+// attachment. The job only runs when startChatImages is called after the chat was saved, so its
+// result always finds the message.
+func (cc *ChatController) requestImage(chat *models.Chat, prompt string) models.MessageAttachment {
+	job := services.GetJobManager().CreateJob(chat.UserID, services.ChatImageJobType, map[string]interface{}{
+		"chatId": chat.ID,
+		"prompt": prompt,
+	})
+
+	return models.MessageAttachment{
+		Type:   models.AttachmentTypeImage,
+		Status: models.AttachmentStatusPending,
+		JobID:  job.ID,
+		Prompt: prompt,
+	}
+}
+
+// takeImageRequest removes the image request from an avatar's reply and, if the chat allows
+// avatars to send pictures, attaches the requested image
+func (cc *ChatController) takeImageRequest(chat *models.Chat, reply *models.Message) {
+	content, prompt := services.ExtractImageRequest(reply.Content)
+	reply.Content = content
+	if prompt == "" || !chat.AvatarImages {
+		return
+	}
+
+	reply.Attachments = append(reply.Attachments, cc.requestImage(chat, prompt))
+}
+
+// writeAttachmentEvent sends the attachments of a streamed message as an "attachment" event
+func (cc *ChatController) writeAttachmentEvent(c *gin.Context, chat *models.Chat, index int) error {
+	msg := chat.Messages[index]
+	for _, attachment := range msg.Attachments {
+		data, _ := json.Marshal(gin.H{"messageIndex": index, "avatarId": msg.AvatarID, "attachment": attachment})
+		if _, err := c.Writer.WriteString(fmt.Sprintf("event: attachment\ndata: %s\n\n", data)); err != nil {
+			return err
+		}
+	}
+	c.Writer.Flush()
+	return nil
+}
+
+// startChatImages runs the jobs of the chat's pending images. Images whose job was lost,
+// e.g. by a restart, are marked as failed.
+func (cc *ChatController) startChatImages(chat *models.Chat) {
+	jobs := services.GetJobManager()
+	for _, msg := range chat.Messages {
+		for _, attachment := range msg.Attachments {
+			if attachment.Status != models.AttachmentStatusPending || attachment.JobID == "" {
+				continue
+			}
+
+			job, exists := jobs.GetJob(attachment.JobID)
+			if !exists {
+				go cc.finishChatImage(chat.ID, chat.UserID, attachment, nil, errors.New("the image job was lost"))
+				continue
+			}
+			if job.Status != models.JobStatusPending {
+				continue
+			}
+
+			jobs.UpdateJobStatus(job.ID, models.JobStatusProcessing)
+			cc.generateChatImage(chat.ID, chat.UserID, attachment)
+		}
+	}
+}
+
+// generateChatImage generates the image of an attachment with the default generation model
+func (cc *ChatController) generateChatImage(chatID, userID string, attachment models.MessageAttachment) {
+	provider, err := cc.getChatImageProvider(userID)
+	if err != nil {
+		go cc.finishChatImage(chatID, userID, attachment, nil, err)
+		return
+	}
+
+	model, input, err := buildImageInput("", models.ImageOperationGenerate, nil, map[string]interface{}{
+		"prompt": attachment.Prompt,
+	})
+	if err != nil {
+		go cc.finishChatImage(chatID, userID, attachment, nil, err)
+		return
+	}
+
+	log.Printf("Generating image for chat %s with prompt: %s", chatID, attachment.Prompt)
+	provider.RunModelAsync(model, input, func(imageURLs []string, err error) {
+		cc.finishChatImage(chatID, userID, attachment, imageURLs, err)
+	})
+}
+
+// getChatImageProvider returns the configured image provider, with the user's API key for Replicate
+func (cc *ChatController) getChatImageProvider(userID string) (interfaces.ImageProvider, error) {
+	if !services.ImageProviderUsesReplicate() {
+		return services.NewImageProvider(""), nil
+	}
+
+	setting, err := cc.db.GetUserSetting(context.Background(), userID, "replicate")
+	if err == nil {
+		if key, ok := setting["key"].(string); ok && key != "" {
+			return services.NewImageProvider(key), nil
+		}
+	}
+	return nil, errors.New("Replicate API key not found. Please set your Replicate API key first.")
+}
+
+// finishChatImage stores a generated image in the user's gallery, completes its job and
+// links the gallery image into the message
+func (cc *ChatController) finishChatImage(chatID, userID string, attachment models.MessageAttachment, imageURLs []string, err error) {
+	jobs := services.GetJobManager()
+
+	imageURLs, err = services.RequireImageOutput(imageURLs, err)
+	var image *models.Image
+	if err == nil {
+		image, err = cc.saveChatImage(userID, attachment.Prompt, imageURLs[0])
+	}
+
+	switch {
+	case err != nil:
+		log.Printf("Error generating image for chat %s: %v", chatID, err)
+		jobs.FailJob(attachment.JobID, fmt.Sprintf("Failed to generate image: %v", err))
+		attachment.Status = models.AttachmentStatusFailed
+		attachment.Error = fmt.Sprintf("Failed to generate image: %v", err)
+	case image.Moderation.IsHidden():
+		jobs.FailJob(attachment.JobID, "The image was hidden by moderation")
+		attachment.Status = models.AttachmentStatusFailed
+		attachment.Error = "The image was hidden by moderation"
+	default:
+		jobs.CompleteJob(attachment.JobID, []models.Image{*image})
+		attachment.Status = models.AttachmentStatusReady
+		attachment.URL = image.URL
+		attachment.ImageID = image.ID
+	}
+
+	if err := cc.updateAttachment(chatID, attachment); err != nil {
+		log.Printf("Error linking image of job %s into chat %s: %v", attachment.JobID, chatID, err)
+	}
+}
+
+// saveChatImage copies a generated image to storage and adds it to the user's gallery
+func (cc *ChatController) saveChatImage(userID, prompt, imageURL string) (*models.Image, error) {
+	storagePath := fmt.Sprintf("gallery/%s/%s.png", userID, uuid.New().String())
+	downloadURL, err := uploadImage(services.GetStorageService(), imageURL, storagePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload image to storage: %v", err)
+	}
+
+	image := &models.Image{
+		ID:          uuid.New().String(),
+		UserID:      userID,
+		URL:         downloadURL,
+		Prompt:      prompt,
+		CreatedAt:   time.Now().Unix(),
+		StoragePath: storagePath,
+		Type:        "chat",
+	}
+	services.GetModerationService().ScreenImage(context.Background(), image)
+
+	if err := cc.db.SaveImage(context.Background(), image); err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+// updateAttachment replaces the attachment with the same job ID in the saved chat
+func (cc *ChatController) updateAttachment(chatID string, attachment models.MessageAttachment) error {
+	defer lockChat(chatID)()
+
+	chat, err := cc.db.GetChat(context.Background(), chatID)
+	if err != nil {
+		return err
+	}
+
+	for i := range chat.Messages {
+		for j := range chat.Messages[i].Attachments {
+			if chat.Messages[i].Attachments[j].JobID == attachment.JobID {
+				chat.Messages[i].Attachments[j] = attachment
+				return cc.db.UpdateChat(context.Background(), chat)
+			}
+		}
+	}
+	return fmt.Errorf("attachment not found")
+}
@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"backend/models"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// getOwnedChat loads the chat from the URL and verifies that the current user owns it
+func (cc *ChatController) getOwnedChat(c *gin.Context) (*models.Chat, bool) {
+	chatID := c.Param("chatID")
+	if chatID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Chat ID is required"})
+		return nil, false
+	}
+
+	userID, ok := cc.getUserID(c)
+	if !ok {
+		return nil, false
+	}
+
+	return cc.getChat(c, chatID, userID, true)
+}
+
+// GetChatLorebook returns the lorebook entries of a chat
+func (cc *ChatController) GetChatLorebook(c *gin.Context) {
+	chat, ok := cc.getOwnedChat(c)
+	if !ok {
+		return
+	}
+
+	entries := chat.Lorebook
+	if entries == nil {
+		entries = models.Lorebook{}
+	}
+	c.JSON(http.StatusOK, gin.H{"entries": entries})
+}
+
+// CreateChatLorebookEntry adds an entry to a chat's lorebook
+func (cc *ChatController) CreateChatLorebookEntry(c *gin.Context) {
+	var req models.LorebookEntryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !req.HasValidKeywords() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Keywords must not be empty"})
+		return
+	}
+
+	chat, ok := cc.getOwnedChat(c)
+	if !ok {
+		return
+	}
+
+	now := time.Now().Unix()
+	entry := models.LorebookEntry{
+		ID:        uuid.New().String(),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	req.Apply(&entry)
+	chat.Lorebook = append(chat.Lorebook, entry)
+	chat.UpdatedAt = now
+
+	if err := cc.saveChat(chat); err != nil {
+		log.Printf("Error saving lorebook entry: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update chat"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, entry)
+}
+
+// UpdateChatLorebookEntry replaces an entry of a chat's lorebook
+func (cc *ChatController) UpdateChatLorebookEntry(c *gin.Context) {
+	var req models.LorebookEntryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !req.HasValidKeywords() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Keywords must not be empty"})
+		return
+	}
+
+	chat, ok := cc.getOwnedChat(c)
+	if !ok {
+		return
+	}
+
+	i := chat.Lorebook.Index(c.Param("entryID"))
+	if i < 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lorebook entry not found"})
+		return
+	}
+
+	now := time.Now().Unix()
+	req.Apply(&chat.Lorebook[i])
+	chat.Lorebook[i].UpdatedAt = now
+	chat.UpdatedAt = now
+
+	if err := cc.saveChat(chat); err != nil {
+		log.Printf("Error updating lorebook entry: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update chat"})
+		return
+	}
+
+	c.JSON(http.StatusOK, chat.Lorebook[i])
+}
+
+// DeleteChatLorebookEntry removes an entry from a chat's lorebook
+func (cc *ChatController) DeleteChatLorebookEntry(c *gin.Context) {
+	chat, ok := cc.getOwnedChat(c)
+	if !ok {
+		return
+	}
+
+	i := chat.Lorebook.Index(c.Param("entryID"))
+	if i < 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lorebook entry not found"})
+		return
+	}
+
+	chat.Lorebook = append(chat.Lorebook[:i], chat.Lorebook[i+1:]...)
+	chat.UpdatedAt = time.Now().Unix()
+
+	if err := cc.saveChat(chat); err != nil {
+		log.Printf("Error deleting lorebook entry: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update chat"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Lorebook entry deleted successfully"})
+}
@@ -0,0 +1,252 @@
+package controllers
+
+import (
+	"backend/models"
+	"backend/services"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxInjectedMemories   = 8 // Memories added to the prompt of each reply
+	memoryContextMessages = 6 // Recent messages used to pick the relevant memories
+)
+
+// rememberFromCommand saves the fact of a "remember" command in the user's message for every avatar of the chat
+func (cc *ChatController) rememberFromCommand(chat *models.Chat, avatars []*models.Avatar, message string) {
+	content, ok := services.ParseRememberCommand(message)
+	if !ok {
+		return
+	}
+
+	for _, avatar := range avatars {
+		if _, err := cc.memory.Add(context.Background(), chat.UserID, avatar.ID, content, models.MemorySourceUser, chat.ID); err != nil {
+			log.Printf("Error saving memory for avatar %s: %v", avatar.ID, err)
+		}
+	}
+}
+
+// extractMemoriesInBackground extracts memories from the chat once enough new messages have accumulated
+func (cc *ChatController) extractMemoriesInBackground(apiKey string, chat *models.Chat, avatars []*models.Avatar) {
+	needed, err := cc.memory.NeedsExtraction(context.Background(), chat, avatars)
+	if err != nil {
+		log.Printf("Error checking memory extraction of chat %s: %v", chat.ID, err)
+		return
+	}
+	if !needed {
+		return
+	}
+
+	// Work on a snapshot, the handler may keep using the chat
+	snapshot := *chat
+	snapshot.Messages = append([]models.Message(nil), chat.Messages...)
+
+	go func() {
+		openRouterService := services.NewOpenRouterService(apiKey)
+		memories, completion, err := cc.memory.ExtractIfNeeded(context.Background(), openRouterService, &snapshot, avatars)
+		if completion != nil {
+			cc.recordCallUsage(snapshot.UserID, completion)
+		}
+		if errors.Is(err, services.ErrExtractionInProgress) {
+			return
+		}
+		if err != nil {
+			log.Printf("Error extracting memories from chat %s: %v", snapshot.ID, err)
+			return
+		}
+		log.Printf("Extracted %d memories from chat %s", len(memories), snapshot.ID)
+	}()
+}
+
+// ExtractChatMemories extracts memories from the messages of a chat that have not been scanned yet,
+// e.g. when the user leaves a conversation
+func (cc *ChatController) ExtractChatMemories(c *gin.Context) {
+	chatID := c.Param("chatID")
+	if chatID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Chat ID is required"})
+		return
+	}
+
+	userID, ok := cc.getUserID(c)
+	if !ok {
+		return
+	}
+
+	chat, ok := cc.getChat(c, chatID, userID, true)
+	if !ok {
+		return
+	}
+
+	avatars, ok := cc.getChatAvatars(c, chat, userID)
+	if !ok {
+		return
+	}
+
+	apiKey, ok := cc.getAPIKey(c, userID)
+	if !ok {
+		return
+	}
+
+	result := models.MemoryExtractionResult{}
+	memories, completion, err := cc.memory.Extract(context.Background(), services.NewOpenRouterService(apiKey).WithContext(c.Request.Context()), chat, avatars)
+	if completion != nil {
+		result.Usage = cc.recordCallUsage(userID, completion)
+	}
+	if errors.Is(err, services.ErrExtractionInProgress) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Memories are already being extracted"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error extracting memories from chat %s: %v", chatID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to extract memories"})
+		return
+	}
+
+	result.Memories = memories
+	c.JSON(http.StatusOK, result)
+}
+
+// GetMemories returns what an avatar remembers about the user
+func (cc *ChatController) GetMemories(c *gin.Context) {
+	avatarID := c.Param("avatarID")
+	if avatarID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Avatar ID is required"})
+		return
+	}
+
+	userID, ok := cc.getUserID(c)
+	if !ok {
+		return
+	}
+
+	memories, err := cc.memory.List(context.Background(), userID, avatarID)
+	if err != nil {
+		log.Printf("Error getting memories: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get memories"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"memories": memories})
+}
+
+// AddMemory saves a memory for an avatar, like a "remember" command in a chat
+func (cc *ChatController) AddMemory(c *gin.Context) {
+	avatarID := c.Param("avatarID")
+	if avatarID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Avatar ID is required"})
+		return
+	}
+
+	var req models.MemoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, ok := cc.getUserID(c)
+	if !ok {
+		return
+	}
+
+	// Only allow memories for avatars the user can chat with
+	if _, ok := cc.getAvatar(c, avatarID, userID); !ok {
+		return
+	}
+
+	memory, err := cc.memory.Add(context.Background(), userID, avatarID, req.Content, models.MemorySourceUser, "")
+	if err != nil {
+		log.Printf("Error adding memory: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save memory"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, memory)
+}
+
+// UpdateMemory edits a memory
+func (cc *ChatController) UpdateMemory(c *gin.Context) {
+	avatarID := c.Param("avatarID")
+	memoryID := c.Param("memoryID")
+	if avatarID == "" || memoryID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Avatar ID and memory ID are required"})
+		return
+	}
+
+	var req models.MemoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, ok := cc.getUserID(c)
+	if !ok {
+		return
+	}
+
+	memory, err := cc.memory.Update(context.Background(), userID, avatarID, memoryID, req.Content)
+	if errors.Is(err, services.ErrMemoryNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Memory not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating memory: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save memory"})
+		return
+	}
+
+	c.JSON(http.StatusOK, memory)
+}
+
+// DeleteMemory deletes a memory
+func (cc *ChatController) DeleteMemory(c *gin.Context) {
+	avatarID := c.Param("avatarID")
+	memoryID := c.Param("memoryID")
+	if avatarID == "" || memoryID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Avatar ID and memory ID are required"})
+		return
+	}
+
+	userID, ok := cc.getUserID(c)
+	if !ok {
+		return
+	}
+
+	err := cc.memory.Delete(context.Background(), userID, avatarID, memoryID)
+	if errors.Is(err, services.ErrMemoryNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Memory not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting memory: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete memory"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Memory deleted successfully"})
+}
+
+// ClearMemories deletes everything an avatar remembers about the user
+func (cc *ChatController) ClearMemories(c *gin.Context) {
+	avatarID := c.Param("avatarID")
+	if avatarID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Avatar ID is required"})
+		return
+	}
+
+	userID, ok := cc.getUserID(c)
+	if !ok {
+		return
+	}
+
+	if err := cc.memory.Clear(context.Background(), userID, avatarID); err != nil {
+		log.Printf("Error clearing memories: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete memories"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Memories deleted successfully"})
+}
@@ -0,0 +1,199 @@
+package controllers
+
+import (
+	"backend/models"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetModelPreferences returns the user's default, favorite and per-avatar models
+func (cc *ChatController) GetModelPreferences(c *gin.Context) {
+	userID, ok := cc.getUserID(c)
+	if !ok {
+		return
+	}
+
+	prefs, err := cc.preferences.Get(context.Background(), userID)
+	if err != nil {
+		log.Printf("Error getting model preferences: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get model preferences"})
+		return
+	}
+
+	c.JSON(http.StatusOK, prefs)
+}
+
+// UpdateModelPreferences replaces the user's model preferences
+func (cc *ChatController) UpdateModelPreferences(c *gin.Context) {
+	var req models.ModelPreferencesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, ok := cc.getUserID(c)
+	if !ok {
+		return
+	}
+
+	prefs := &models.ModelPreferences{
+		DefaultModelID: req.DefaultModelID,
+		Favorites:      []string{},
+		AvatarModels:   make(map[string]string),
+	}
+
+	// Drop duplicate and empty favorites
+	seen := make(map[string]bool)
+	for _, modelID := range req.Favorites {
+		if modelID != "" && !seen[modelID] {
+			seen[modelID] = true
+			prefs.Favorites = append(prefs.Favorites, modelID)
+		}
+	}
+
+	for avatarID, modelID := range req.AvatarModels {
+		if modelID != "" {
+			prefs.AvatarModels[avatarID] = modelID
+		}
+	}
+
+	if err := cc.preferences.Save(context.Background(), userID, prefs); err != nil {
+		log.Printf("Error saving model preferences: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save model preferences"})
+		return
+	}
+
+	c.JSON(http.StatusOK, prefs)
+}
+
+// AddFavoriteModel adds a model to the user's favorites
+func (cc *ChatController) AddFavoriteModel(c *gin.Context) {
+	var req models.FavoriteModelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, ok := cc.getUserID(c)
+	if !ok {
+		return
+	}
+
+	prefs, err := cc.preferences.AddFavorite(context.Background(), userID, req.ModelID)
+	if err != nil {
+		log.Printf("Error adding favorite model: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save model preferences"})
+		return
+	}
+
+	c.JSON(http.StatusOK, prefs)
+}
+
+// RemoveFavoriteModel removes a model from the user's favorites.
+// The model ID is passed as a query parameter because OpenRouter IDs contain slashes.
+func (cc *ChatController) RemoveFavoriteModel(c *gin.Context) {
+	modelID := c.Query("modelId")
+	if modelID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "modelId is required"})
+		return
+	}
+
+	userID, ok := cc.getUserID(c)
+	if !ok {
+		return
+	}
+
+	prefs, err := cc.preferences.RemoveFavorite(context.Background(), userID, modelID)
+	if err != nil {
+		log.Printf("Error removing favorite model: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save model preferences"})
+		return
+	}
+
+	c.JSON(http.StatusOK, prefs)
+}
+
+// SetAvatarModel sets or clears the preferred model for an avatar
+func (cc *ChatController) SetAvatarModel(c *gin.Context) {
+	avatarID := c.Param("avatarID")
+	if avatarID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Avatar ID is required"})
+		return
+	}
+
+	var req models.AvatarModelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, ok := cc.getUserID(c)
+	if !ok {
+		return
+	}
+
+	// Only allow preferences for avatars the user can chat with
+	if _, ok := cc.getAvatar(c, avatarID, userID); !ok {
+		return
+	}
+
+	prefs, err := cc.preferences.SetAvatarModel(context.Background(), userID, avatarID, req.ModelID)
+	if err != nil {
+		log.Printf("Error setting avatar model: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save model preferences"})
+		return
+	}
+
+	c.JSON(http.StatusOK, prefs)
+}
+
+// UpdateChatModels changes the model of an existing chat and the fallback models
+// tried when it fails or is unavailable
+func (cc *ChatController) UpdateChatModels(c *gin.Context) {
+	chatID := c.Param("chatID")
+	if chatID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Chat ID is required"})
+		return
+	}
+
+	var req models.ChatModelsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(req.FallbackModelIDs) > models.MaxFallbackModels {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d fallback models are allowed", models.MaxFallbackModels)})
+		return
+	}
+
+	userID, ok := cc.getUserID(c)
+	if !ok {
+		return
+	}
+
+	// Get the chat and verify ownership
+	chat, ok := cc.getChat(c, chatID, userID, true)
+	if !ok {
+		return
+	}
+
+	chat.ModelID = req.ModelID
+	chat.FallbackModelIDs = req.FallbackModelIDs
+	// Drop empty entries and duplicates of the primary model
+	chat.FallbackModelIDs = chat.ModelChain()[1:]
+	chat.UpdatedAt = time.Now().Unix()
+
+	if err := cc.saveChat(chat); err != nil {
+		log.Printf("Error updating chat models: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update chat"})
+		return
+	}
+
+	c.JSON(http.StatusOK, chat)
+}
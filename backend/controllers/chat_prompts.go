@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"backend/models"
+	"backend/services"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetPromptTemplates returns the global system prompt templates
+func (cc *ChatController) GetPromptTemplates(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"templates": services.GetPromptTemplateService().GlobalTemplates()})
+}
+
+// GetChatPromptTemplates returns every version of the prompt templates overridden on a chat
+func (cc *ChatController) GetChatPromptTemplates(c *gin.Context) {
+	chatID := c.Param("chatID")
+	if chatID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Chat ID is required"})
+		return
+	}
+
+	userID, ok := cc.getUserID(c)
+	if !ok {
+		return
+	}
+
+	chat, ok := cc.getChat(c, chatID, userID, true)
+	if !ok {
+		return
+	}
+
+	templates := chat.PromptTemplates
+	if templates == nil {
+		templates = models.PromptTemplateOverrides{}
+	}
+	c.JSON(http.StatusOK, gin.H{"templates": templates})
+}
+
+// SetChatPromptTemplate saves a new version of a prompt template override for a chat
+func (cc *ChatController) SetChatPromptTemplate(c *gin.Context) {
+	chatID := c.Param("chatID")
+	name := c.Param("name")
+	if chatID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Chat ID is required"})
+		return
+	}
+	if !services.IsPromptTemplateName(name) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown prompt template"})
+		return
+	}
+
+	var req models.PromptTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := services.ValidatePromptTemplate(req.Text); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, ok := cc.getUserID(c)
+	if !ok {
+		return
+	}
+
+	chat, ok := cc.getChat(c, chatID, userID, true)
+	if !ok {
+		return
+	}
+
+	now := time.Now().Unix()
+	var template models.PromptTemplate
+	chat.PromptTemplates, template = chat.PromptTemplates.Add(name, req.Text, now)
+	chat.UpdatedAt = now
+
+	if err := cc.saveChat(chat); err != nil {
+		log.Printf("Error saving chat prompt template: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update chat"})
+		return
+	}
+
+	template.Source = models.PromptSourceChat
+	c.JSON(http.StatusOK, template)
+}
+
+// DeleteChatPromptTemplate removes a chat's override, so the avatar or global template applies again
+func (cc *ChatController) DeleteChatPromptTemplate(c *gin.Context) {
+	chatID := c.Param("chatID")
+	name := c.Param("name")
+	if chatID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Chat ID is required"})
+		return
+	}
+	if !services.IsPromptTemplateName(name) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown prompt template"})
+		return
+	}
+
+	userID, ok := cc.getUserID(c)
+	if !ok {
+		return
+	}
+
+	chat, ok := cc.getChat(c, chatID, userID, true)
+	if !ok {
+		return
+	}
+
+	chat.PromptTemplates = chat.PromptTemplates.Remove(name)
+	chat.UpdatedAt = time.Now().Unix()
+
+	if err := cc.saveChat(chat); err != nil {
+		log.Printf("Error deleting chat prompt template: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update chat"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Prompt template override removed"})
+}
+
+// PreviewChatPrompt renders the final system message each avatar of the chat would receive.
+// Pass avatarId to preview a single avatar.
+func (cc *ChatController) PreviewChatPrompt(c *gin.Context) {
+	chatID := c.Param("chatID")
+	if chatID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Chat ID is required"})
+		return
+	}
+
+	userID, ok := cc.getUserID(c)
+	if !ok {
+		return
+	}
+
+	chat, ok := cc.getChat(c, chatID, userID, true)
+	if !ok {
+		return
+	}
+
+	avatars, ok := cc.getChatAvatars(c, chat, userID)
+	if !ok {
+		return
+	}
+
+	avatarID := c.Query("avatarId")
+	prompts := []*models.PromptPreview{}
+	for _, avatar := range avatars {
+		if avatarID != "" && avatar.ID != avatarID {
+			continue
+		}
+
+		prompt, err := services.GetPromptTemplateService().Render(chat, avatar, avatars)
+		if err != nil {
+			log.Printf("Error rendering prompt preview: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render prompt"})
+			return
+		}
+		prompts = append(prompts, prompt)
+	}
+
+	if avatarID != "" && len(prompts) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Avatar is not part of this chat"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"prompts": prompts})
+}
@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"backend/models"
+	"backend/services"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getChatAvatars loads the avatars of a chat, handling chats created before multiple avatars were supported.
+// Avatars the chat is pinned to are returned as of their pinned version.
+func (cc *ChatController) getChatAvatars(c *gin.Context, chat *models.Chat, userID string) ([]*models.Avatar, bool) {
+	avatarIDs := chat.AvatarIDs
+	if len(avatarIDs) == 0 && chat.AvatarID != "" {
+		avatarIDs = []string{chat.AvatarID}
+	}
+	if len(avatarIDs) == 0 {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No avatars associated with this chat"})
+		return nil, false
+	}
+
+	avatars, ok := cc.getMultipleAvatars(c, avatarIDs, userID)
+	if !ok {
+		return nil, false
+	}
+	return cc.versions.ApplyPins(context.Background(), chat, avatars), true
+}
+
+// TakeTurn lets an avatar speak without a new user message, so the characters of a
+// group chat can talk to each other. The speaker is the requested avatar or, if none
+// is given, the one picked by the chat's turn strategy.
+func (cc *ChatController) TakeTurn(c *gin.Context) {
+	chatID := c.Param("chatID")
+	if chatID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Chat ID is required"})
+		return
+	}
+
+	var req models.ChatTurnRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, ok := cc.getUserID(c)
+	if !ok {
+		return
+	}
+
+	// Get the chat and verify ownership
+	chat, ok := cc.getChat(c, chatID, userID, true)
+	if !ok {
+		return
+	}
+
+	avatars, ok := cc.getChatAvatars(c, chat, userID)
+	if !ok {
+		return
+	}
+
+	apiKey, ok := cc.getAPIKey(c, userID)
+	if !ok {
+		return
+	}
+
+	openRouterService := services.NewOpenRouterService(apiKey).WithContext(c.Request.Context())
+
+	var speakers []*models.Avatar
+	if req.AvatarID != "" {
+		for _, avatar := range avatars {
+			if avatar.ID == req.AvatarID {
+				speakers = []*models.Avatar{avatar}
+				break
+			}
+		}
+		if speakers == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Avatar is not part of this chat"})
+			return
+		}
+	} else {
+		speakers = cc.planTurn(openRouterService, userID, chat, avatars, "")
+	}
+
+	replies, err := cc.generateReplies(openRouterService, chat, avatars, speakers)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	chat.UpdatedAt = time.Now().Unix()
+
+	if err := cc.saveChat(chat); err != nil {
+		log.Printf("Error updating chat: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update chat"})
+		return
+	}
+
+	cc.recordUsage(userID, replies...)
+	cc.startChatImages(chat)
+	cc.recordAvatarActivity(avatars, replies, false)
+
+	c.JSON(http.StatusOK, chat)
+}
+
+// UpdateTurnStrategy changes how the next speaker is picked in a group chat
+func (cc *ChatController) UpdateTurnStrategy(c *gin.Context) {
+	chatID := c.Param("chatID")
+	if chatID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Chat ID is required"})
+		return
+	}
+
+	var req models.TurnStrategyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !req.TurnStrategy.IsValid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid turn strategy"})
+		return
+	}
+
+	userID, ok := cc.getUserID(c)
+	if !ok {
+		return
+	}
+
+	// Get the chat and verify ownership
+	chat, ok := cc.getChat(c, chatID, userID, true)
+	if !ok {
+		return
+	}
+
+	chat.TurnStrategy = req.TurnStrategy
+	chat.UpdatedAt = time.Now().Unix()
+
+	if err := cc.saveChat(chat); err != nil {
+		log.Printf("Error updating turn strategy: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update chat"})
+		return
+	}
+
+	c.JSON(http.StatusOK, chat)
+}
@@ -14,18 +14,12 @@ import (
 	"cloud.google.com/go/firestore"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"google.golang.org/api/iterator"
 
+	"backend/interfaces"
 	"backend/models"
 	"backend/services"
 )
 
-// ImageService interface defines methods for image generation
-type ImageService interface {
-	GenerateImage(prompt string) (string, error)
-	InpaintImage(imageURL, prompt, maskURL string) (string, error)
-}
-
 // FirebaseService interface defines methods for Firebase storage
 type FirebaseService interface {
 	UploadFromURL(sourceURL, destinationPath string) (string, error)
@@ -33,37 +27,59 @@ type FirebaseService interface {
 }
 
 type ImageController struct {
-	imageService    ImageService
+	imageService    interfaces.ImageProvider
 	firebaseService FirebaseService
 	firestoreClient *firestore.Client
+	db              interfaces.DatabaseService // Gallery images
 	jobManager      *services.JobManager
+	usage           *services.UsageTracker
+	preferences     *services.ModelPreferencesService
+	storage         interfaces.StorageService // Avatar profile images
+	versions        *services.AvatarVersionService
 }
 
 func NewImageController(firestoreClient *firestore.Client) *ImageController {
-	// Initialize job manager
-	jobManager := services.NewJobManager()
-
-	// Start job cleanup routine (clean up jobs older than 24 hours every hour)
-	jobManager.StartCleanupRoutine(time.Hour, 24*time.Hour)
+	// Jobs are shared with the chat, which generates images too
+	jobManager := services.GetJobManager()
+	db := services.GetDatabaseService()
 
 	controller := &ImageController{
-		imageService:    services.NewReplicateService(), // Keep default service for now
+		imageService:    services.NewImageProvider(""), // Uses the server's API key
 		firebaseService: services.NewFirebaseService(),
 		firestoreClient: firestoreClient,
+		db:              db,
 		jobManager:      jobManager,
+		usage:           services.GetUsageTracker(),
+		preferences:     services.GetModelPreferencesService(),
+		storage:         services.GetStorageService(),
+		versions:        services.GetAvatarVersionService(),
 	}
 
 	return controller
 }
 
-// getReplicateService creates a ReplicateService with the user's API key if available
-func (c *ImageController) getReplicateService(ctx *gin.Context, userID string) (ImageService, bool) {
+// getImageProvider returns the configured image provider. Replicate runs with the
+// user's API key, so it fails if the user hasn't set one.
+func (c *ImageController) getImageProvider(ctx *gin.Context, userID string) (interfaces.ImageProvider, bool) {
+	if !services.ImageProviderUsesReplicate() {
+		return services.NewImageProvider(""), true
+	}
+
 	apiKey, ok := c.getReplicateAPIKey(ctx, userID)
 	if !ok {
 		return nil, false
 	}
 
-	return services.NewReplicateServiceWithKey(apiKey), true
+	return services.NewImageProvider(apiKey), true
+}
+
+// uploadImage copies a provider output to storage. Self-hosted and mock providers
+// return data URIs instead of URLs.
+func uploadImage(storage FirebaseService, source, storagePath string) (string, error) {
+	if strings.HasPrefix(source, "data:image/") {
+		return storage.UploadBase64Image(source, storagePath)
+	}
+	return storage.UploadFromURL(source, storagePath)
 }
 
 func (c *ImageController) GenerateImage(ctx *gin.Context) {
@@ -89,14 +105,46 @@ func (c *ImageController) GenerateImage(ctx *gin.Context) {
 	// Log the request for debugging
 	log.Printf("Generating image with prompt: %s for user: %s", req.Prompt, userID)
 
-	// Get a ReplicateService with the user's API key
-	imageService, ok := c.getReplicateService(ctx, userID)
+	if req.AspectRatio != "" && (req.Width != nil || req.Height != nil) {
+		ctx.JSON(http.StatusBadRequest, models.ImageResponse{
+			Success: false,
+			Error:   "Set either an aspect ratio or a width and height",
+		})
+		return
+	}
+
+	inputs := req.ImageGenerationParams.Inputs()
+	inputs["prompt"] = req.Prompt
+	model, input, err := buildImageInput(req.Model, models.ImageOperationGenerate, req.Input, inputs)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ImageResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	respond := func(status int, message string) {
+		ctx.JSON(status, models.ImageResponse{
+			Success: false,
+			Error:   message,
+		})
+	}
+	enhanceKey, ok := c.getEnhancementAPIKey(userID, req.EnhancePrompt, respond)
 	if !ok {
-		return // getReplicateService already set the error response
+		return
+	}
+
+	// Get the image provider, with the user's API key for Replicate
+	imageService, ok := c.getImageProvider(ctx, userID)
+	if !ok {
+		return // getImageProvider already set the error response
 	}
 
-	// Generate image using the image service with user's API key
-	imageURL, err := imageService.GenerateImage(req.Prompt)
+	enhancedPrompt := c.applyPromptEnhancement(enhanceKey, userID, req.Prompt, models.ImageOperationGenerate, model, input, req.PromptEnhancementOptions)
+
+	// Generate images using the image service with user's API key
+	imageURLs, err := services.RequireImageOutput(imageService.RunModel(model, input))
 	if err != nil {
 		log.Printf("Error generating image: %v", err)
 		ctx.JSON(http.StatusInternalServerError, models.ImageResponse{
@@ -107,23 +155,16 @@ func (c *ImageController) GenerateImage(ctx *gin.Context) {
 	}
 
 	// Log successful image generation
-	log.Printf("Successfully generated image URL: %s", imageURL)
+	log.Printf("Successfully generated %d image(s) with %s", len(imageURLs), model.ID)
 
-	// Create image record but don't save to gallery or Firebase yet
-	// Just return the direct URL from Replicate
-	image := models.Image{
-		ID:          uuid.New().String(),
-		UserID:      userID,
-		URL:         imageURL, // Use the direct URL from Replicate
-		Prompt:      req.Prompt,
-		CreatedAt:   time.Now().Unix(),
-		StoragePath: "", // No storage path yet
-		Type:        "generated",
-	}
+	// Create image records but don't save to gallery or Firebase yet
+	// Just return the direct URLs from Replicate
+	images := newImageRecords(userID, req.Prompt, enhancedPrompt, "generated", imageURLs)
 
 	ctx.JSON(http.StatusOK, models.ImageResponse{
 		Success: true,
-		Image:   &image,
+		Image:   &images[0],
+		Images:  images,
 	})
 }
 
@@ -172,13 +213,35 @@ func (c *ImageController) InpaintImage(ctx *gin.Context) {
 	log.Printf("Image URL length: %d", len(req.ImageURL))
 	log.Printf("Mask length: %d", len(req.Mask))
 
+	model, input, err := buildImageInput(req.Model, models.ImageOperationInpaint, req.Input, map[string]interface{}{
+		"prompt": req.Prompt,
+		"image":  req.ImageURL,
+		"mask":   req.Mask,
+	})
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ImageResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	enhanceKey, ok := c.getEnhancementAPIKey(userID, req.EnhancePrompt, func(status int, message string) {
+		ctx.JSON(status, models.ImageResponse{
+			Success: false,
+			Error:   message,
+		})
+	})
+	if !ok {
+		return
+	}
+
 	// Check if the image and mask are base64 encoded
 	isBase64Image := len(req.ImageURL) > 100 && strings.HasPrefix(req.ImageURL, "data:image/")
 	isBase64Mask := len(req.Mask) > 100 && strings.HasPrefix(req.Mask, "data:image/")
 
 	// If the image is base64 encoded, we need to upload it to a temporary location
 	var imageURL, maskURL string
-	var err error
 
 	if isBase64Image {
 		log.Printf("Uploading base64 image to temporary storage")
@@ -224,17 +287,21 @@ func (c *ImageController) InpaintImage(ctx *gin.Context) {
 
 	// Create a channel to handle timeouts
 	resultChan := make(chan struct {
-		url string
-		err error
+		urls []string
+		err  error
 	})
 
+	input[model.InputName("image")] = imageURL
+	input[model.InputName("mask")] = maskURL
+	enhancedPrompt := c.applyPromptEnhancement(enhanceKey, userID, req.Prompt, models.ImageOperationInpaint, model, input, req.PromptEnhancementOptions)
+
 	// Start a goroutine to generate the inpainted image
 	go func() {
-		inpaintedURL, err := c.imageService.InpaintImage(imageURL, req.Prompt, maskURL)
+		inpaintedURLs, err := services.RequireImageOutput(c.imageService.RunModel(model, input))
 		resultChan <- struct {
-			url string
-			err error
-		}{inpaintedURL, err}
+			urls []string
+			err  error
+		}{inpaintedURLs, err}
 	}()
 
 	// Wait for the result or timeout
@@ -250,27 +317,17 @@ func (c *ImageController) InpaintImage(ctx *gin.Context) {
 		}
 
 		// Log successful inpainting
-		log.Printf("Successfully inpainted image URL: %s", result.url)
+		log.Printf("Successfully inpainted image URL: %s", result.urls[0])
 
-		// Create image record but don't save to gallery or Firebase yet
-		// Just return the direct URL from Replicate
-		image := models.Image{
-			ID:          uuid.New().String(),
-			UserID:      userID,
-			URL:         result.url, // Use the direct URL from Replicate
-			Prompt:      req.Prompt,
-			CreatedAt:   time.Now().Unix(),
-			StoragePath: "", // No storage path yet
-			Type:        "inpainted",
-		}
-
-		// Add extra logging before sending the response
-		log.Printf("Sending successful response with image URL: %s", image.URL)
+		// Create image records but don't save to gallery or Firebase yet
+		// Just return the direct URLs from Replicate
+		images := newImageRecords(userID, req.Prompt, enhancedPrompt, "inpainted", result.urls)
 
 		// Simplify the response handling to avoid potential issues
 		ctx.JSON(http.StatusOK, models.ImageResponse{
 			Success: true,
-			Image:   &image,
+			Image:   &images[0],
+			Images:  images,
 		})
 	case <-time.After(4 * time.Minute): // 4 minute timeout
 		log.Printf("Inpainting timed out after 4 minutes")
@@ -283,6 +340,9 @@ func (c *ImageController) InpaintImage(ctx *gin.Context) {
 
 // SaveToGallery saves an image to the user's gallery
 func (c *ImageController) SaveToGallery(ctx *gin.Context) {
+	// Generated images can be sent back as data URIs
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, 50<<20) // 50MB limit
+
 	var req models.SaveToGalleryRequest
 	if err := ctx.ShouldBindJSON(&req); err != nil {
 		ctx.JSON(http.StatusBadRequest, models.ImageResponse{
@@ -318,7 +378,7 @@ func (c *ImageController) SaveToGallery(ctx *gin.Context) {
 	storagePath := fmt.Sprintf("gallery/%s/%s.png", userID, uuid.New().String())
 
 	// Upload the image to Firebase Storage
-	downloadURL, err := c.firebaseService.UploadFromURL(req.ImageURL, storagePath)
+	downloadURL, err := uploadImage(c.firebaseService, req.ImageURL, storagePath)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, models.ImageResponse{
 			Success: false,
@@ -330,17 +390,19 @@ func (c *ImageController) SaveToGallery(ctx *gin.Context) {
 	// Create an image object
 	now := time.Now().Unix()
 	image := models.Image{
-		ID:          imageID,
-		UserID:      userID,
-		URL:         downloadURL,
-		Prompt:      req.Prompt,
-		CreatedAt:   now,
-		StoragePath: storagePath,
-		Type:        req.Type,
-	}
-
-	// Save to Firestore
-	_, err = c.firestoreClient.Collection("gallery").Doc(imageID).Set(context.Background(), image)
+		ID:             imageID,
+		UserID:         userID,
+		URL:            downloadURL,
+		Prompt:         req.Prompt,
+		CreatedAt:      now,
+		StoragePath:    storagePath,
+		Type:           req.Type,
+		EnhancedPrompt: c.jobEnhancedPrompt(userID, req.JobID, req.ImageURL),
+	}
+	services.GetModerationService().ScreenImage(context.Background(), &image)
+
+	// Save to the database
+	err = c.db.SaveImage(context.Background(), &image)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, models.ImageResponse{
 			Success: false,
@@ -355,6 +417,25 @@ func (c *ImageController) SaveToGallery(ctx *gin.Context) {
 	})
 }
 
+// jobEnhancedPrompt returns the enhanced prompt the user's job used for an image, or ""
+// if the job is unknown or didn't produce the image
+func (c *ImageController) jobEnhancedPrompt(userID, jobID, imageURL string) string {
+	if jobID == "" {
+		return ""
+	}
+
+	job, exists := c.jobManager.GetJob(jobID)
+	if !exists || job.UserID != userID {
+		return ""
+	}
+	for _, result := range job.Results {
+		if result.URL == imageURL {
+			return result.EnhancedPrompt
+		}
+	}
+	return ""
+}
+
 // GetUserGallery returns all images in the user's gallery
 func (c *ImageController) GetUserGallery(ctx *gin.Context) {
 	userID := ctx.GetString("userId")
@@ -366,40 +447,28 @@ func (c *ImageController) GetUserGallery(ctx *gin.Context) {
 		return
 	}
 
-	// Query Firestore for user's images
-	query := c.firestoreClient.Collection("gallery").Where("UserID", "==", userID)
-	iter := query.Documents(context.Background())
-	defer iter.Stop()
+	// Query the database for user's images
+	userImages, err := c.db.GetUserImages(context.Background(), userID)
+	if err != nil {
+		log.Printf("Error getting gallery images: %v", err)
+		ctx.JSON(http.StatusInternalServerError, models.GalleryResponse{
+			Success: false,
+			Error:   "Failed to retrieve gallery",
+		})
+		return
+	}
 
+	// Images hidden by a moderator stay in their owner's gallery with their moderation state
 	var images []models.Image
-	for {
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			log.Printf("Error iterating gallery documents: %v", err)
-			ctx.JSON(http.StatusInternalServerError, models.GalleryResponse{
-				Success: false,
-				Error:   "Failed to retrieve gallery",
-			})
-			return
-		}
-
-		var image models.Image
-		if err := doc.DataTo(&image); err != nil {
-			log.Printf("Error converting document to image: %v", err)
-			continue
-		}
-
-		images = append(images, image)
+	existingURLs := make(map[string]bool)
+	for _, userImage := range userImages {
+		images = append(images, *userImage)
 	}
 
 	// If we have fewer than expected images, check Firebase Storage directly
 	// This is a recovery mechanism for images that might be in Storage but not in Firestore
 	if len(images) < 3 {
-		// Create a map of existing image URLs to avoid duplicates
-		existingURLs := make(map[string]bool)
+		// Add the remaining image URLs to avoid duplicates
 		for _, img := range images {
 			existingURLs[img.URL] = true
 		}
@@ -414,9 +483,9 @@ func (c *ImageController) GetUserGallery(ctx *gin.Context) {
 				if !existingURLs[storageImage.URL] {
 					images = append(images, storageImage)
 
-					// Also save this image to Firestore for future queries
+					// Also save this image to the database for future queries
 					go func(img models.Image) {
-						_, err := c.firestoreClient.Collection("gallery").Doc(img.ID).Set(context.Background(), img)
+						err := c.db.SaveImage(context.Background(), &img)
 						if err != nil {
 							log.Printf("Error saving Storage image to Firestore: %v", err)
 						}
@@ -523,11 +592,35 @@ func (c *ImageController) StartInpaintJob(ctx *gin.Context) {
 	log.Printf("Image URL length: %d", len(req.ImageURL))
 	log.Printf("Mask length: %d", len(req.Mask))
 
+	model, input, err := buildImageInput(req.Model, models.ImageOperationInpaint, req.Input, map[string]interface{}{
+		"prompt": req.Prompt,
+		"image":  req.ImageURL,
+		"mask":   req.Mask,
+	})
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.JobResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	enhanceKey, ok := c.getEnhancementAPIKey(userID, req.EnhancePrompt, func(status int, message string) {
+		ctx.JSON(status, models.JobResponse{
+			Success: false,
+			Error:   message,
+		})
+	})
+	if !ok {
+		return
+	}
+
 	// Create a job
 	jobData := map[string]interface{}{
 		"prompt":   req.Prompt,
 		"imageURL": req.ImageURL,
 		"mask":     req.Mask,
+		"model":    model.ID,
 	}
 	job := c.jobManager.CreateJob(userID, "inpaint", jobData)
 
@@ -581,29 +674,23 @@ func (c *ImageController) StartInpaintJob(ctx *gin.Context) {
 		}
 
 		// Generate inpainted image
-		inpaintedURL, err := c.imageService.InpaintImage(imageURL, req.Prompt, maskURL)
-		if err != nil {
-			log.Printf("Error inpainting image for job %s: %v", job.ID, err)
-			c.jobManager.FailJob(job.ID, fmt.Sprintf("Failed to inpaint image: %v", err))
-			return
-		}
-
-		// Log successful inpainting
-		log.Printf("Successfully inpainted image URL: %s for job %s", inpaintedURL, job.ID)
+		input[model.InputName("image")] = imageURL
+		input[model.InputName("mask")] = maskURL
+		enhancedPrompt := c.applyPromptEnhancement(enhanceKey, userID, req.Prompt, models.ImageOperationInpaint, model, input, req.PromptEnhancementOptions)
+		c.imageService.RunModelAsync(model, input, func(inpaintedURLs []string, err error) {
+			inpaintedURLs, err = services.RequireImageOutput(inpaintedURLs, err)
+			if err != nil {
+				log.Printf("Error inpainting image for job %s: %v", job.ID, err)
+				c.jobManager.FailJob(job.ID, fmt.Sprintf("Failed to inpaint image: %v", err))
+				return
+			}
 
-		// Create image record
-		image := models.Image{
-			ID:          uuid.New().String(),
-			UserID:      userID,
-			URL:         inpaintedURL,
-			Prompt:      req.Prompt,
-			CreatedAt:   time.Now().Unix(),
-			StoragePath: "",
-			Type:        "inpainted",
-		}
+			// Log successful inpainting
+			log.Printf("Successfully inpainted image URL: %s for job %s", inpaintedURLs[0], job.ID)
 
-		// Complete the job with the result
-		c.jobManager.CompleteJob(job.ID, &image)
+			// Complete the job with the results
+			c.jobManager.CompleteJob(job.ID, newImageRecords(userID, req.Prompt, enhancedPrompt, "inpainted", inpaintedURLs))
+		})
 	}()
 
 	// Return the job ID immediately
@@ -678,9 +765,8 @@ func (c *ImageController) DeleteImage(ctx *gin.Context) {
 		return
 	}
 
-	// Get the image from Firestore
-	docRef := c.firestoreClient.Collection("gallery").Doc(imageID)
-	doc, err := docRef.Get(context.Background())
+	// Get the image from the database
+	image, err := c.db.GetImage(context.Background(), imageID)
 	if err != nil {
 		ctx.JSON(http.StatusNotFound, gin.H{
 			"success": false,
@@ -689,16 +775,6 @@ func (c *ImageController) DeleteImage(ctx *gin.Context) {
 		return
 	}
 
-	// Convert to image model
-	var image models.Image
-	if err := doc.DataTo(&image); err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   "Failed to process image data",
-		})
-		return
-	}
-
 	// Verify ownership
 	if image.UserID != userID {
 		ctx.JSON(http.StatusForbidden, gin.H{
@@ -708,8 +784,8 @@ func (c *ImageController) DeleteImage(ctx *gin.Context) {
 		return
 	}
 
-	// Delete from Firestore
-	_, err = docRef.Delete(context.Background())
+	// Delete from the database
+	err = c.db.DeleteImage(context.Background(), imageID)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -785,9 +861,10 @@ func (c *ImageController) UploadImage(ctx *gin.Context) {
 		StoragePath: storagePath,
 		Type:        "uploaded",
 	}
+	services.GetModerationService().ScreenImage(context.Background(), &image)
 
-	// Save to Firestore
-	_, err = c.firestoreClient.Collection("gallery").Doc(imageID).Set(context.Background(), image)
+	// Save to the database
+	err = c.db.SaveImage(context.Background(), &image)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"backend/models"
+	"backend/services"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// StartUpscaleJob starts a job upscaling a gallery image by 2x or 4x
+func (c *ImageController) StartUpscaleJob(ctx *gin.Context) {
+	var req models.ImageEditRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, models.JobResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Invalid request body: %v", err),
+		})
+		return
+	}
+
+	if req.Scale == 0 {
+		req.Scale = 2
+	}
+	if req.Scale != 2 && req.Scale != 4 {
+		ctx.JSON(http.StatusBadRequest, models.JobResponse{
+			Success: false,
+			Error:   "scale must be 2 or 4",
+		})
+		return
+	}
+
+	c.startEditJob(ctx, req, models.ImageOperationUpscale, "upscaled", map[string]interface{}{
+		"scale": req.Scale,
+	})
+}
+
+// StartRemoveBackgroundJob starts a job removing the background of a gallery image
+func (c *ImageController) StartRemoveBackgroundJob(ctx *gin.Context) {
+	var req models.ImageEditRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, models.JobResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Invalid request body: %v", err),
+		})
+		return
+	}
+
+	c.startEditJob(ctx, req, models.ImageOperationRemoveBackground, "background_removed", map[string]interface{}{})
+}
+
+// startEditJob runs an image operation on a gallery image in the background. Each output
+// is stored as a new gallery image linked to its source.
+func (c *ImageController) startEditJob(ctx *gin.Context, req models.ImageEditRequest, operation, imageType string, inputs map[string]interface{}) {
+	userID := ctx.GetString("userId")
+	if userID == "" {
+		ctx.JSON(http.StatusUnauthorized, models.JobResponse{
+			Success: false,
+			Error:   "Unauthorized",
+		})
+		return
+	}
+
+	source, err := c.db.GetImage(context.Background(), req.ImageID)
+	if err != nil || source.UserID != userID || source.Moderation.IsHidden() {
+		ctx.JSON(http.StatusNotFound, models.JobResponse{
+			Success: false,
+			Error:   "Image not found",
+		})
+		return
+	}
+
+	inputs["image"] = source.URL
+	model, input, err := buildImageInput(req.Model, operation, nil, inputs)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.JobResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	// Get the image provider, with the user's API key for Replicate
+	imageService, ok := c.getImageProvider(ctx, userID)
+	if !ok {
+		return // getImageProvider already set the error response
+	}
+
+	job := c.jobManager.CreateJob(userID, operation, map[string]interface{}{
+		"imageId": source.ID,
+		"model":   model.ID,
+	})
+
+	c.jobManager.UpdateJobStatus(job.ID, models.JobStatusProcessing)
+	imageService.RunModelAsync(model, input, func(outputURLs []string, err error) {
+		outputURLs, err = services.RequireImageOutput(outputURLs, err)
+		if err != nil {
+			log.Printf("Error running %s for job %s: %v", operation, job.ID, err)
+			c.jobManager.FailJob(job.ID, fmt.Sprintf("Failed to %s image: %v", operation, err))
+			return
+		}
+
+		var images []models.Image
+		for _, outputURL := range outputURLs {
+			image, err := c.saveEditedImage(source, imageType, outputURL)
+			if err != nil {
+				log.Printf("Error saving %s result for job %s: %v", operation, job.ID, err)
+				c.jobManager.FailJob(job.ID, "Failed to save image to gallery")
+				return
+			}
+			images = append(images, *image)
+		}
+
+		c.jobManager.CompleteJob(job.ID, images)
+	})
+
+	// Return the job ID immediately
+	ctx.JSON(http.StatusAccepted, models.JobResponse{
+		Success: true,
+		Job:     job,
+	})
+}
+
+// saveEditedImage copies the output of an image operation to storage and adds it to the
+// owner's gallery
+func (c *ImageController) saveEditedImage(source *models.Image, imageType, outputURL string) (*models.Image, error) {
+	storagePath := fmt.Sprintf("gallery/%s/%s.png", source.UserID, uuid.New().String())
+	downloadURL, err := uploadImage(c.firebaseService, outputURL, storagePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload image to storage: %v", err)
+	}
+
+	image := &models.Image{
+		ID:            uuid.New().String(),
+		UserID:        source.UserID,
+		URL:           downloadURL,
+		Prompt:        source.Prompt,
+		CreatedAt:     time.Now().Unix(),
+		StoragePath:   storagePath,
+		Type:          imageType,
+		SourceImageID: source.ID,
+	}
+	services.GetModerationService().ScreenImage(context.Background(), image)
+
+	if err := c.db.SaveImage(context.Background(), image); err != nil {
+		return nil, err
+	}
+	return image, nil
+}
@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"backend/models"
+	"backend/services"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ListImageModels lists the image models users can choose from, optionally only those
+// supporting an operation
+func (c *ImageController) ListImageModels(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"provider": services.ImageProviderName(),
+		"models":   services.GetImageModelRegistry().Models(ctx.Query("operation")),
+	})
+}
+
+// buildImageInput resolves the requested model and validates the prediction input against
+// its schema. The operation inputs (prompt, image, mask) are renamed to the model's names
+// and take precedence over the same keys in the extra input.
+func buildImageInput(modelID, operation string, extra, inputs map[string]interface{}) (*models.ImageModel, map[string]interface{}, error) {
+	model, err := services.GetImageModelRegistry().Resolve(modelID, operation)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	input := make(map[string]interface{})
+	for name, value := range extra {
+		input[name] = value
+	}
+	for name, value := range inputs {
+		input[model.InputName(name)] = value
+	}
+
+	input, err = services.ValidateImageInput(model, input)
+	if err != nil {
+		return nil, nil, err
+	}
+	return model, input, nil
+}
+
+// newImageRecords creates unsaved image records for the outputs of a prediction. The enhanced
+// prompt is empty unless the user's prompt was rewritten before generation.
+func newImageRecords(userID, prompt, enhancedPrompt, imageType string, urls []string) []models.Image {
+	now := time.Now().Unix()
+	images := make([]models.Image, 0, len(urls))
+	for _, url := range urls {
+		images = append(images, models.Image{
+			ID:             uuid.New().String(),
+			UserID:         userID,
+			URL:            url, // Use the direct URL from the provider
+			Prompt:         prompt,
+			CreatedAt:      now,
+			Type:           imageType,
+			EnhancedPrompt: enhancedPrompt,
+		})
+	}
+	return images
+}
@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"backend/models"
+	"backend/services"
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EnhancePrompt previews the enhancement of an image prompt without generating an image
+func (c *ImageController) EnhancePrompt(ctx *gin.Context) {
+	respond := func(status int, message string) {
+		ctx.JSON(status, models.PromptEnhancementResponse{
+			Success: false,
+			Error:   message,
+		})
+	}
+
+	var req models.PromptEnhancementRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		respond(http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	userID := ctx.GetString("userId")
+	if userID == "" {
+		respond(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	if req.Operation == "" {
+		req.Operation = models.ImageOperationGenerate
+	}
+	if req.Operation != models.ImageOperationGenerate && req.Operation != models.ImageOperationInpaint {
+		respond(http.StatusBadRequest, "operation must be generate or inpaint")
+		return
+	}
+
+	var imageModel *models.ImageModel
+	if req.ImageModel != "" {
+		model, err := services.GetImageModelRegistry().Resolve(req.ImageModel, req.Operation)
+		if err != nil {
+			respond(http.StatusBadRequest, err.Error())
+			return
+		}
+		imageModel = model
+	}
+
+	apiKey, ok := c.getEnhancementAPIKey(userID, true, respond)
+	if !ok {
+		return
+	}
+
+	options := models.PromptEnhancementOptions{
+		EnhancePrompt: true,
+		Style:         req.Style,
+		ChatModel:     req.ChatModel,
+	}
+	result, err := c.enhancePrompt(apiKey, userID, req.Prompt, req.Operation, imageModel, options)
+	if err != nil {
+		log.Printf("Error enhancing prompt for user %s: %v", userID, err)
+		respond(http.StatusBadGateway, "Failed to enhance prompt")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, result)
+}
+
+// getEnhancementAPIKey returns the user's OpenRouter API key when the prompt should be
+// enhanced, or "" when it shouldn't. On failure the error response has been written.
+func (c *ImageController) getEnhancementAPIKey(userID string, enhance bool, respond func(int, string)) (string, bool) {
+	if !enhance {
+		return "", true
+	}
+
+	setting, err := c.db.GetUserSetting(context.Background(), userID, "openrouter")
+	if err == nil {
+		if key, ok := setting["key"].(string); ok && key != "" {
+			return key, true
+		}
+	}
+
+	respond(http.StatusBadRequest, "Prompt enhancement uses your chat model. Please set your OpenRouter API key first.")
+	return "", false
+}
+
+// enhancePrompt rewrites a prompt with the requested chat model, the user's default chat model
+// or the enhancer's default model, and records the token usage
+func (c *ImageController) enhancePrompt(apiKey, userID, prompt, operation string, imageModel *models.ImageModel, options models.PromptEnhancementOptions) (*models.PromptEnhancementResponse, error) {
+	chatModel := options.ChatModel
+	if chatModel == "" {
+		prefs, err := c.preferences.Get(context.Background(), userID)
+		if err != nil {
+			return nil, err
+		}
+		chatModel = prefs.DefaultModelID
+	}
+
+	enhancer := services.GetPromptEnhancer()
+	enhanced, completion, err := enhancer.Enhance(services.NewOpenRouterService(apiKey), enhancer.ModelChain(chatModel), prompt, options.Style, operation, imageModel)
+
+	result := &models.PromptEnhancementResponse{
+		Success:        err == nil,
+		OriginalPrompt: prompt,
+		EnhancedPrompt: enhanced,
+	}
+	if completion != nil {
+		call := models.Message{
+			Role:      "system",
+			Timestamp: time.Now().Unix(),
+			ModelID:   completion.Model,
+			Usage:     c.usage.PriceCompletion(completion.Model, completion.Usage),
+		}
+		if err := c.usage.RecordMessages(context.Background(), userID, call); err != nil {
+			log.Printf("Error recording prompt enhancement usage for user %s: %v", userID, err)
+		}
+		result.ModelID = completion.Model
+		result.Usage = call.Usage
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// applyPromptEnhancement replaces the prompt in the model input with its enhanced version and
+// returns it. Without an API key nothing is enhanced; if the enhancement fails the original
+// prompt is kept and "" is returned.
+func (c *ImageController) applyPromptEnhancement(apiKey, userID, prompt, operation string, model *models.ImageModel, input map[string]interface{}, options models.PromptEnhancementOptions) string {
+	if apiKey == "" {
+		return ""
+	}
+
+	result, err := c.enhancePrompt(apiKey, userID, prompt, operation, model, options)
+	if err != nil {
+		log.Printf("Error enhancing prompt for user %s, using the original prompt: %v", userID, err)
+		return ""
+	}
+
+	log.Printf("Enhanced prompt for user %s: %s", userID, result.EnhancedPrompt)
+	input[model.InputName("prompt")] = result.EnhancedPrompt
+	return result.EnhancedPrompt
+}
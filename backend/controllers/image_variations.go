@@ -0,0 +1,192 @@
+package controllers
+
+import (
+	"backend/models"
+	"backend/services"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// errSourceImage is returned when the source image of a variation can't be used
+var errSourceImage = errors.New("source image not found")
+
+// resolveSourceImage returns a URL the image provider can read for the source of a variation.
+// Gallery images must belong to the user and base64 uploads are stored temporarily.
+func (c *ImageController) resolveSourceImage(userID string, req *models.ImageVariationRequest) (string, error) {
+	if req.ImageID != "" {
+		image, err := c.db.GetImage(context.Background(), req.ImageID)
+		if err != nil || image.UserID != userID || image.Moderation.IsHidden() {
+			return "", errSourceImage
+		}
+		return image.URL, nil
+	}
+
+	source := strings.TrimSpace(req.ImageURL)
+	switch {
+	case source == "":
+		return "", fmt.Errorf("an image ID or image URL is required")
+	case strings.HasPrefix(source, "data:image/"):
+		tempPath := fmt.Sprintf("temp/temp_%s_%s.png", userID, uuid.New().String())
+		url, err := c.firebaseService.UploadBase64Image(source, tempPath)
+		if err != nil {
+			return "", fmt.Errorf("failed to upload image: %v", err)
+		}
+		return url, nil
+	case strings.HasPrefix(source, "https://") || strings.HasPrefix(source, "http://"):
+		return source, nil
+	}
+	return "", fmt.Errorf("imageUrl must be an http(s) URL or a data URI")
+}
+
+// prepareVariation validates a variation request and resolves its model, input and source image.
+// On failure the error response has been written.
+func (c *ImageController) prepareVariation(userID string, req *models.ImageVariationRequest, respond func(int, string)) (*models.ImageModel, map[string]interface{}, bool) {
+	if req.AspectRatio != "" && (req.Width != nil || req.Height != nil) {
+		respond(http.StatusBadRequest, "Set either an aspect ratio or a width and height")
+		return nil, nil, false
+	}
+
+	sourceURL, err := c.resolveSourceImage(userID, req)
+	if errors.Is(err, errSourceImage) {
+		respond(http.StatusNotFound, "Image not found")
+		return nil, nil, false
+	} else if err != nil {
+		respond(http.StatusBadRequest, err.Error())
+		return nil, nil, false
+	}
+
+	inputs := req.ImageGenerationParams.Inputs()
+	inputs["prompt"] = req.Prompt
+	inputs["image"] = sourceURL
+	if req.Strength != nil {
+		inputs["strength"] = *req.Strength
+	}
+
+	model, input, err := buildImageInput(req.Model, models.ImageOperationVariation, req.Input, inputs)
+	if err != nil {
+		respond(http.StatusBadRequest, err.Error())
+		return nil, nil, false
+	}
+	return model, input, true
+}
+
+// CreateVariation creates new images from a source image and a prompt
+func (c *ImageController) CreateVariation(ctx *gin.Context) {
+	// Base64 source images can be large
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, 50<<20) // 50MB limit
+
+	respond := func(status int, message string) {
+		ctx.JSON(status, models.ImageResponse{
+			Success: false,
+			Error:   message,
+		})
+	}
+
+	var req models.ImageVariationRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		respond(http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	userID := ctx.GetString("userId")
+	if userID == "" {
+		respond(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	log.Printf("Creating image variation with prompt: %s for user: %s", req.Prompt, userID)
+
+	model, input, ok := c.prepareVariation(userID, &req, respond)
+	if !ok {
+		return
+	}
+
+	// Get the image provider, with the user's API key for Replicate
+	imageService, ok := c.getImageProvider(ctx, userID)
+	if !ok {
+		return // getImageProvider already set the error response
+	}
+
+	imageURLs, err := services.RequireImageOutput(imageService.RunModel(model, input))
+	if err != nil {
+		log.Printf("Error creating image variation: %v", err)
+		respond(http.StatusInternalServerError, fmt.Sprintf("Failed to create variation: %v", err))
+		return
+	}
+
+	images := newImageRecords(userID, req.Prompt, "", "variation", imageURLs)
+	ctx.JSON(http.StatusOK, models.ImageResponse{
+		Success: true,
+		Image:   &images[0],
+		Images:  images,
+	})
+}
+
+// StartVariationJob starts an asynchronous image variation job
+func (c *ImageController) StartVariationJob(ctx *gin.Context) {
+	// Base64 source images can be large
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, 50<<20) // 50MB limit
+
+	respond := func(status int, message string) {
+		ctx.JSON(status, models.JobResponse{
+			Success: false,
+			Error:   message,
+		})
+	}
+
+	var req models.ImageVariationRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		respond(http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	userID := ctx.GetString("userId")
+	if userID == "" {
+		respond(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	log.Printf("Starting variation job with prompt: %s for user: %s", req.Prompt, userID)
+
+	model, input, ok := c.prepareVariation(userID, &req, respond)
+	if !ok {
+		return
+	}
+
+	// Get the image provider, with the user's API key for Replicate
+	imageService, ok := c.getImageProvider(ctx, userID)
+	if !ok {
+		return // getImageProvider already set the error response
+	}
+
+	job := c.jobManager.CreateJob(userID, "variation", map[string]interface{}{
+		"prompt":  req.Prompt,
+		"imageId": req.ImageID,
+		"model":   model.ID,
+	})
+
+	c.jobManager.UpdateJobStatus(job.ID, models.JobStatusProcessing)
+	imageService.RunModelAsync(model, input, func(imageURLs []string, err error) {
+		imageURLs, err = services.RequireImageOutput(imageURLs, err)
+		if err != nil {
+			log.Printf("Error creating image variation for job %s: %v", job.ID, err)
+			c.jobManager.FailJob(job.ID, fmt.Sprintf("Failed to create variation: %v", err))
+			return
+		}
+
+		c.jobManager.CompleteJob(job.ID, newImageRecords(userID, req.Prompt, "", "variation", imageURLs))
+	})
+
+	// Return the job ID immediately
+	ctx.JSON(http.StatusAccepted, models.JobResponse{
+		Success: true,
+		Job:     job,
+	})
+}
@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"backend/models"
+	"backend/services"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ModerationController struct {
+	moderation *services.ModerationService
+}
+
+func NewModerationController(moderation *services.ModerationService) *ModerationController {
+	return &ModerationController{moderation: moderation}
+}
+
+// getModeratorID returns the current user if they are a moderator
+func (mc *ModerationController) getModeratorID(c *gin.Context) (string, bool) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		log.Printf("User ID not found in context")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return "", false
+	}
+
+	if !mc.moderation.IsModerator(userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Moderator access required"})
+		return "", false
+	}
+
+	return userID, true
+}
+
+// ReportContent reports an avatar or gallery image for review
+func (mc *ModerationController) ReportContent(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		log.Printf("User ID not found in context")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req models.ReportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.TargetType != models.ModerationTargetAvatar && req.TargetType != models.ModerationTargetImage {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "targetType must be avatar or image"})
+		return
+	}
+
+	if _, err := mc.moderation.Report(context.Background(), userID, req); err != nil {
+		switch {
+		case errors.Is(err, services.ErrModerationTarget):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Content not found"})
+		case errors.Is(err, services.ErrAlreadyReported):
+			c.JSON(http.StatusConflict, gin.H{"error": "You already reported this content"})
+		case errors.Is(err, services.ErrUserBanned):
+			c.JSON(http.StatusForbidden, gin.H{"error": "You are not allowed to report content"})
+		default:
+			log.Printf("Error reporting content: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to report content"})
+		}
+		return
+	}
+
+	// Reporters don't get to see the case, which holds other users' reports
+	c.JSON(http.StatusCreated, gin.H{"message": "Report received"})
+}
+
+// GetModerationQueue lists moderation cases, open ones by default
+func (mc *ModerationController) GetModerationQueue(c *gin.Context) {
+	if _, ok := mc.getModeratorID(c); !ok {
+		return
+	}
+
+	status := c.DefaultQuery("status", models.ModerationCaseOpen)
+	if status == "all" {
+		status = ""
+	} else if status != models.ModerationCaseOpen && status != models.ModerationCaseResolved {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be open, resolved or all"})
+		return
+	}
+
+	cases, err := mc.moderation.Queue(context.Background(), status)
+	if err != nil {
+		log.Printf("Error getting moderation queue: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get moderation queue"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"cases": cases})
+}
+
+// GetModerationCase returns a single moderation case
+func (mc *ModerationController) GetModerationCase(c *gin.Context) {
+	if _, ok := mc.getModeratorID(c); !ok {
+		return
+	}
+
+	moderationCase, err := mc.moderation.Case(context.Background(), c.Param("caseID"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Moderation case not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, moderationCase)
+}
+
+// ActOnModerationCase applies a moderator decision and resolves the case
+func (mc *ModerationController) ActOnModerationCase(c *gin.Context) {
+	moderatorID, ok := mc.getModeratorID(c)
+	if !ok {
+		return
+	}
+
+	var req models.ModerationActionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	moderationCase, err := mc.moderation.Act(context.Background(), c.Param("caseID"), moderatorID, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrModerationCaseNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Moderation case not found"})
+		case errors.Is(err, services.ErrModerationTarget):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Content not found"})
+		case errors.Is(err, services.ErrModerationAction):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			log.Printf("Error applying moderation action: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply moderation action"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, moderationCase)
+}
@@ -0,0 +1,51 @@
+package controllers
+
+import (
+	"backend/services"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxWebhookSize limits the body of webhook requests
+const maxWebhookSize = 1 << 20
+
+// ReplicateWebhookHandler receives completed predictions from Replicate and finishes the
+// jobs waiting for them. Requests must carry a valid webhook signature.
+func ReplicateWebhookHandler(c *gin.Context) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxWebhookSize))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+
+	tracker := services.GetPredictionTracker()
+	err = tracker.VerifyWebhook(c.GetHeader("webhook-id"), c.GetHeader("webhook-timestamp"), c.GetHeader("webhook-signature"), body)
+	if err != nil {
+		log.Printf("Rejected Replicate webhook: %v", err)
+		status := http.StatusUnauthorized
+		if !errors.Is(err, services.ErrInvalidWebhookSignature) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{"error": "Invalid webhook signature"})
+		return
+	}
+
+	var prediction services.ReplicateResponse
+	if err := json.Unmarshal(body, &prediction); err != nil || prediction.ID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid prediction"})
+		return
+	}
+
+	// Unknown predictions are acknowledged too, e.g. ones already found by polling,
+	// so Replicate doesn't retry them
+	if !tracker.Complete(&prediction) {
+		log.Printf("Received webhook for untracked prediction %s", prediction.ID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"received": true})
+}
@@ -15,9 +15,16 @@ type DatabaseService interface {
 	GetAvatar(ctx context.Context, avatarID string) (*models.Avatar, error)
 	GetUserAvatars(ctx context.Context, userID string) ([]*models.Avatar, error)
 	GetPublicAvatars(ctx context.Context) ([]*models.Avatar, error)
+	SearchPublicAvatars(ctx context.Context, query models.AvatarQuery) (*models.AvatarPage, error)
 	SaveAvatar(ctx context.Context, avatar *models.Avatar) error
 	UpdateAvatar(ctx context.Context, avatar *models.Avatar) error
 	DeleteAvatar(ctx context.Context, avatarID string) error
+	UpdateAvatarStats(ctx context.Context, avatarID string, update func(stats *models.AvatarStats)) error
+	
+	// Avatar versions
+	GetAvatarVersions(ctx context.Context, avatarID string) ([]*models.AvatarVersion, error)
+	GetAvatarVersion(ctx context.Context, avatarID string, version int) (*models.AvatarVersion, error)
+	SaveAvatarVersion(ctx context.Context, version *models.AvatarVersion) error
 	
 	// Chats
 	GetChat(ctx context.Context, chatID string) (*models.Chat, error)
@@ -32,6 +39,11 @@ type DatabaseService interface {
 	SaveImage(ctx context.Context, image *models.Image) error
 	DeleteImage(ctx context.Context, imageID string) error
 	
+	// Moderation
+	GetModerationCase(ctx context.Context, caseID string) (*models.ModerationCase, error)
+	GetModerationCases(ctx context.Context, status string) ([]*models.ModerationCase, error)
+	SaveModerationCase(ctx context.Context, moderationCase *models.ModerationCase) error
+	
 	// Settings
 	GetUserSetting(ctx context.Context, userID, settingKey string) (map[string]interface{}, error)
 	SaveUserSetting(ctx context.Context, userID, settingKey string, data map[string]interface{}) error
@@ -0,0 +1,15 @@
+package interfaces
+
+import "backend/models"
+
+// ImageProvider defines the interface for image generation backends. Images are
+// returned as URLs, or as data URIs by providers that produce the image bytes themselves.
+type ImageProvider interface {
+	GenerateImage(prompt string) (string, error)
+	InpaintImage(imageURL, prompt, maskURL string) (string, error)
+
+	// RunModel runs a model with input validated against its schema and returns all outputs
+	RunModel(model *models.ImageModel, input map[string]interface{}) ([]string, error)
+	// RunModelAsync runs a model in the background and calls done with the outputs
+	RunModelAsync(model *models.ImageModel, input map[string]interface{}, done func([]string, error))
+}
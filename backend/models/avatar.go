@@ -13,6 +13,40 @@ type Avatar struct {
 	CreatorNickname string `json:"creatorNickname" firestore:"creatorNickname"`
 	CreatedAt       int64  `json:"createdAt" firestore:"createdAt"`
 	UpdatedAt       int64  `json:"updatedAt" firestore:"updatedAt"`
+	Version         int    `json:"version" firestore:"version"` // Latest entry of the version history, 0 for avatars without history
+
+	// Opening lines, one of which starts each new chat
+	Greetings []string `json:"greetings,omitempty" firestore:"greetings,omitempty"`
+	// Sample exchanges shown to the model as few-shot turns
+	ExampleDialogues []ExampleDialogue `json:"exampleDialogues,omitempty" firestore:"exampleDialogues,omitempty"`
+
+	// Versioned system prompt templates overriding the global ones for this avatar
+	PromptTemplates PromptTemplateOverrides `json:"promptTemplates,omitempty" firestore:"promptTemplates,omitempty"`
+
+	// Background knowledge injected into chats when its keywords come up
+	Lorebook Lorebook `json:"lorebook,omitempty" firestore:"lorebook,omitempty"`
+
+	// Lineage of avatars forked from another avatar
+	ForkedFrom              string   `json:"forkedFrom,omitempty" firestore:"forkedFrom,omitempty"`                           // Avatar this one was copied from
+	ForkedFromVersion       int      `json:"forkedFromVersion,omitempty" firestore:"forkedFromVersion,omitempty"`             // Version of the source at the time of the fork
+	ForkLineage             []string `json:"forkLineage,omitempty" firestore:"forkLineage,omitempty"`                         // All ancestors, the original avatar first
+	OriginalCreatorNickname string   `json:"originalCreatorNickname,omitempty" firestore:"originalCreatorNickname,omitempty"` // Creator of the original avatar
+	// Popularity counters, only changed through DatabaseService.UpdateAvatarStats
+	AvatarStats
+
+	// Discovery in the public listing
+	Tags     []string `json:"tags,omitempty" firestore:"tags,omitempty"`
+	Category string   `json:"category,omitempty" firestore:"category,omitempty"`
+	// Lowercase words of the name, description, tags and category, kept up to date on save for search queries
+	SearchTerms []string `json:"-" firestore:"searchTerms,omitempty"`
+
+	// Set by the moderation pipeline; flagged or hidden avatars are left out of public listings
+	Moderation Moderation `json:"moderation" firestore:"moderation"`
+}
+
+// VisibleTo reports whether a user may see and chat with the avatar
+func (a *Avatar) VisibleTo(userID string) bool {
+	return a.OwnerID == userID || (a.IsPublic && !a.Moderation.IsHidden())
 }
 
 // AvatarRequest is used for creating or updating an avatar
@@ -21,13 +55,34 @@ type AvatarRequest struct {
 	Description     string `json:"description" binding:"required"`
 	Story           string `json:"story" binding:"required"`
 	Persona         string `json:"persona" binding:"required"`
-	ProfileImageURL string `json:"profileImageUrl" binding:"required"`
+	ProfileImageURL string `json:"profileImageUrl"` // Can be generated later with /api/avatars/:id/portrait; kept on update when empty
 	IsPublic        bool   `json:"isPublic"`
 	CreatorNickname string `json:"creatorNickname"`
+
+	// Left unchanged on update when omitted
+	Greetings        *[]string          `json:"greetings,omitempty"`
+	ExampleDialogues *[]ExampleDialogue `json:"exampleDialogues,omitempty"`
+	Tags             *[]string          `json:"tags,omitempty"`
+	Category         *string            `json:"category,omitempty"`
+}
+
+// AvatarForkRequest customizes a fork; empty fields are copied from the source avatar
+type AvatarForkRequest struct {
+	Name            string `json:"name"`
+	CreatorNickname string `json:"creatorNickname"`
+}
+
+// ExampleDialogue is a sample exchange showing how an avatar talks
+type ExampleDialogue struct {
+	User   string `json:"user" firestore:"user"`
+	Avatar string `json:"avatar" firestore:"avatar"`
 }
 
 // AvatarResponse is used for returning avatar data with additional metadata
 type AvatarResponse struct {
 	Avatar
-	IsOwner bool `json:"isOwner"`
+	IsOwner       bool    `json:"isOwner"`
+	RatingAverage float64 `json:"ratingAverage"`      // 0 when the avatar has no ratings
+	Liked         bool    `json:"liked"`              // Whether the current user likes the avatar
+	MyRating      int     `json:"myRating,omitempty"` // The current user's rating
 } 
\ No newline at end of file
@@ -0,0 +1,23 @@
+package models
+
+// AvatarPortraitJobType is the job type of profile image generation
+const AvatarPortraitJobType = "portrait"
+
+// DefaultAvatarPortraitCandidates is the number of portraits generated to choose from
+const DefaultAvatarPortraitCandidates = 4
+
+// AvatarPortraitRequest generates profile image candidates from an avatar's name,
+// description and persona
+type AvatarPortraitRequest struct {
+	Details string                 `json:"details,omitempty"` // Extra details added to the prompt, e.g. clothing or setting
+	Model   string                 `json:"model,omitempty"`   // Image model ID, the default generation model if empty
+	Input   map[string]interface{} `json:"input,omitempty"`   // Extra model inputs, validated against the model's schema
+	ImageGenerationParams
+	PromptEnhancementOptions
+}
+
+// AvatarPortraitSelectRequest makes one of the candidates of a portrait job the profile image
+type AvatarPortraitSelectRequest struct {
+	JobID   string `json:"jobId" binding:"required"`
+	ImageID string `json:"imageId" binding:"required"` // ID of the candidate in the job results
+}
@@ -0,0 +1,53 @@
+package models
+
+// Orders of the public avatar listing; ties are broken by avatar ID
+const (
+	AvatarSortNewest     = "newest"      // Most recently created first
+	AvatarSortMostUsed   = "most_used"   // Most chats started first
+	AvatarSortMostForked = "most_forked" // Most forks first
+	AvatarSortMostLiked  = "most_liked"  // Most likes first
+	AvatarSortTopRated   = "top_rated"   // Highest average rating first
+)
+
+// AvatarCategories are the categories an avatar can be filed under
+var AvatarCategories = []string{
+	"original",
+	"anime",
+	"games",
+	"movies-tv",
+	"books",
+	"history",
+	"fantasy",
+	"sci-fi",
+	"romance",
+	"horror",
+	"helpers",
+	"education",
+	"other",
+}
+
+// IsAvatarCategory reports whether category is one of the known categories
+func IsAvatarCategory(category string) bool {
+	for _, known := range AvatarCategories {
+		if category == known {
+			return true
+		}
+	}
+	return false
+}
+
+// AvatarQuery filters, orders and pages the public avatar listing
+type AvatarQuery struct {
+	Search   string // Words that must all appear in the name, description, tags or category
+	Tag      string
+	Category string
+	Sort     string
+	Cursor   string // NextCursor of the previous page
+	Limit    int
+}
+
+// AvatarPage is one page of the public avatar listing
+type AvatarPage struct {
+	Avatars    []*Avatar
+	NextCursor string // Empty on the last page
+}
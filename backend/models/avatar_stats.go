@@ -0,0 +1,62 @@
+package models
+
+// AvatarStats are the popularity counters of an avatar
+type AvatarStats struct {
+	UsageCount   int `json:"usageCount" firestore:"usageCount"`     // Chats started with the avatar
+	MessageCount int `json:"messageCount" firestore:"messageCount"` // Messages exchanged with the avatar
+	ForkCount    int `json:"forkCount" firestore:"forkCount"`
+	LikeCount    int `json:"likeCount" firestore:"likeCount"`
+	RatingCount  int `json:"ratingCount" firestore:"ratingCount"`
+	RatingSum    int `json:"ratingSum" firestore:"ratingSum"`
+	// Average rating times 100, stored so listings can be sorted by it
+	RatingScore int `json:"ratingScore" firestore:"ratingScore"`
+}
+
+// Range of avatar ratings
+const (
+	MinAvatarRating = 1
+	MaxAvatarRating = 5
+)
+
+// RatingAverage returns the average rating, or 0 without ratings
+func (s AvatarStats) RatingAverage() float64 {
+	if s.RatingCount == 0 {
+		return 0
+	}
+	return float64(s.RatingSum) / float64(s.RatingCount)
+}
+
+// ChangeRating replaces a user's rating; 0 stands for no rating
+func (s *AvatarStats) ChangeRating(previous, rating int) {
+	if previous > 0 {
+		s.RatingCount--
+		s.RatingSum -= previous
+	}
+	if rating > 0 {
+		s.RatingCount++
+		s.RatingSum += rating
+	}
+
+	s.RatingScore = 0
+	if s.RatingCount > 0 {
+		s.RatingScore = s.RatingSum * 100 / s.RatingCount
+	}
+}
+
+// AvatarVote is a user's like and rating of an avatar
+type AvatarVote struct {
+	Liked     bool  `json:"liked"`
+	Rating    int   `json:"rating,omitempty"`
+	UpdatedAt int64 `json:"updatedAt"`
+}
+
+// AvatarVotes holds all of a user's votes by avatar ID. It is stored as a user setting,
+// which also guarantees that a user has at most one vote per avatar.
+type AvatarVotes struct {
+	Votes map[string]AvatarVote `json:"votes"`
+}
+
+// AvatarRatingRequest rates an avatar
+type AvatarRatingRequest struct {
+	Rating int `json:"rating" binding:"required"`
+}
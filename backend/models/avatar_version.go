@@ -0,0 +1,85 @@
+package models
+
+import "reflect"
+
+// What produced an avatar version
+const (
+	AvatarChangeCreated  = "created"  // The avatar was created or imported
+	AvatarChangeForked   = "forked"   // The avatar was copied from another avatar
+	AvatarChangeInitial  = "initial"  // State of an avatar created before version history existed
+	AvatarChangeUpdated  = "updated"  // The owner edited the avatar
+	AvatarChangeReverted = "reverted" // The owner restored an earlier version
+)
+
+// AvatarSnapshot holds the parts of an avatar that shape how it behaves in chats.
+// Visibility, lorebook and prompt templates are not versioned.
+type AvatarSnapshot struct {
+	Name             string            `json:"name" firestore:"name"`
+	Description      string            `json:"description" firestore:"description"`
+	Story            string            `json:"story" firestore:"story"`
+	Persona          string            `json:"persona" firestore:"persona"`
+	ProfileImageURL  string            `json:"profileImageUrl" firestore:"profileImageUrl"`
+	CreatorNickname  string            `json:"creatorNickname" firestore:"creatorNickname"`
+	Greetings        []string          `json:"greetings,omitempty" firestore:"greetings,omitempty"`
+	ExampleDialogues []ExampleDialogue `json:"exampleDialogues,omitempty" firestore:"exampleDialogues,omitempty"`
+}
+
+// Equal reports whether two snapshots describe the same persona
+func (s AvatarSnapshot) Equal(other AvatarSnapshot) bool {
+	return reflect.DeepEqual(s.normalized(), other.normalized())
+}
+
+// normalized treats empty and missing lists alike, as they are after a storage round trip
+func (s AvatarSnapshot) normalized() AvatarSnapshot {
+	if len(s.Greetings) == 0 {
+		s.Greetings = nil
+	}
+	if len(s.ExampleDialogues) == 0 {
+		s.ExampleDialogues = nil
+	}
+	return s
+}
+
+// AvatarVersion is an immutable snapshot of an avatar, saved whenever its persona changes
+type AvatarVersion struct {
+	AvatarID     string         `json:"avatarId" firestore:"avatarId"`
+	Version      int            `json:"version" firestore:"version"`
+	Snapshot     AvatarSnapshot `json:"snapshot" firestore:"snapshot"`
+	Change       string         `json:"change" firestore:"change"`
+	RevertedFrom int            `json:"revertedFrom,omitempty" firestore:"revertedFrom,omitempty"` // Version restored by a revert
+	CreatedBy    string         `json:"createdBy" firestore:"createdBy"`
+	CreatedAt    int64          `json:"createdAt" firestore:"createdAt"`
+}
+
+// Snapshot returns the versioned parts of the avatar
+func (a *Avatar) Snapshot() AvatarSnapshot {
+	return AvatarSnapshot{
+		Name:             a.Name,
+		Description:      a.Description,
+		Story:            a.Story,
+		Persona:          a.Persona,
+		ProfileImageURL:  a.ProfileImageURL,
+		CreatorNickname:  a.CreatorNickname,
+		Greetings:        a.Greetings,
+		ExampleDialogues: a.ExampleDialogues,
+	}
+}
+
+// ApplySnapshot overwrites the versioned parts of the avatar
+func (a *Avatar) ApplySnapshot(s AvatarSnapshot) {
+	a.Name = s.Name
+	a.Description = s.Description
+	a.Story = s.Story
+	a.Persona = s.Persona
+	a.ProfileImageURL = s.ProfileImageURL
+	a.CreatorNickname = s.CreatorNickname
+	a.Greetings = s.Greetings
+	a.ExampleDialogues = s.ExampleDialogues
+}
+
+// AvatarVersionPinRequest pins a chat to a version of one of its avatars.
+// Version 0 removes the pin so the chat follows the latest version again.
+type AvatarVersionPinRequest struct {
+	AvatarID string `json:"avatarId" binding:"required"`
+	Version  int    `json:"version"`
+}
@@ -0,0 +1,48 @@
+package models
+
+// CharacterCard is the character card format shared by other roleplay tools (spec "chara_card_v2").
+// Version 1 cards have the data fields at the top level instead of under "data".
+type CharacterCard struct {
+	Spec        string            `json:"spec"`
+	SpecVersion string            `json:"spec_version"`
+	Data        CharacterCardData `json:"data"`
+}
+
+// CharacterCardData holds the character definition of a card
+type CharacterCardData struct {
+	Name                    string                 `json:"name"`
+	Description             string                 `json:"description"`
+	Personality             string                 `json:"personality"`
+	Scenario                string                 `json:"scenario"`
+	FirstMes                string                 `json:"first_mes"`
+	MesExample              string                 `json:"mes_example"`
+	CreatorNotes            string                 `json:"creator_notes"`
+	SystemPrompt            string                 `json:"system_prompt"`
+	PostHistoryInstructions string                 `json:"post_history_instructions"`
+	AlternateGreetings      []string               `json:"alternate_greetings"`
+	Tags                    []string               `json:"tags"`
+	Creator                 string                 `json:"creator"`
+	CharacterVersion        string                 `json:"character_version"`
+	CharacterBook           *CharacterBook         `json:"character_book,omitempty"`
+	Extensions              map[string]interface{} `json:"extensions"`
+}
+
+// CharacterBook is the lorebook embedded in a character card
+type CharacterBook struct {
+	Name        string                 `json:"name,omitempty"`
+	TokenBudget int                    `json:"token_budget,omitempty"`
+	Entries     []CharacterBookEntry   `json:"entries"`
+	Extensions  map[string]interface{} `json:"extensions"`
+}
+
+// CharacterBookEntry is a single lorebook entry of a character card
+type CharacterBookEntry struct {
+	ID             int                    `json:"id,omitempty"`
+	Keys           []string               `json:"keys"`
+	Content        string                 `json:"content"`
+	Enabled        bool                   `json:"enabled"`
+	InsertionOrder int                    `json:"insertion_order"`
+	Priority       int                    `json:"priority,omitempty"`
+	Comment        string                 `json:"comment,omitempty"`
+	Extensions     map[string]interface{} `json:"extensions"`
+}
@@ -1,28 +1,158 @@
 package models
 
+import "strings"
+
+// MaxFallbackModels caps the fallback models of a chat. Every model is retried before
+// moving on, so a long list could keep a single reply waiting for minutes.
+const MaxFallbackModels = 3
+
 type Chat struct {
-	ID        string    `json:"id" firestore:"id"`
-	UserID    string    `json:"userId" firestore:"userId"`
-	Title     string    `json:"title" firestore:"title"`
-	CreatedAt int64     `json:"createdAt" firestore:"createdAt"`
-	UpdatedAt int64     `json:"updatedAt" firestore:"updatedAt"`
-	Messages  []Message `json:"messages" firestore:"messages"`
-	ModelID   string    `json:"modelId" firestore:"modelId"`
-	AvatarID  string    `json:"avatarId" firestore:"avatarId"`             // Keep for backward compatibility
-	AvatarIDs []string  `json:"avatarIds" firestore:"avatarIds,omitempty"` // New field for multiple avatars
+	ID        string      `json:"id" firestore:"id"`
+	UserID    string      `json:"userId" firestore:"userId"`
+	Title     string      `json:"title" firestore:"title"`
+	CreatedAt int64       `json:"createdAt" firestore:"createdAt"`
+	UpdatedAt int64       `json:"updatedAt" firestore:"updatedAt"`
+	Messages  []Message   `json:"messages" firestore:"messages"`
+	ModelID   string      `json:"modelId" firestore:"modelId"`
+	AvatarID  string      `json:"avatarId" firestore:"avatarId"`             // Keep for backward compatibility
+	AvatarIDs []string    `json:"avatarIds" firestore:"avatarIds,omitempty"` // New field for multiple avatars
+	Usage     UsageTotals `json:"usage" firestore:"usage"`                   // Aggregated usage of all assistant messages
+
+	// Models tried in order when ModelID fails or is unavailable
+	FallbackModelIDs []string `json:"fallbackModelIds,omitempty" firestore:"fallbackModelIds,omitempty"`
+
+	// How the next speaker is picked in group chats (defaults to round-robin)
+	TurnStrategy TurnStrategy `json:"turnStrategy,omitempty" firestore:"turnStrategy,omitempty"`
+
+	// Versioned system prompt templates overriding the avatar and global ones for this chat
+	PromptTemplates PromptTemplateOverrides `json:"promptTemplates,omitempty" firestore:"promptTemplates,omitempty"`
+
+	// Background knowledge for this chat, used together with the avatars' lorebooks
+	Lorebook Lorebook `json:"lorebook,omitempty" firestore:"lorebook,omitempty"`
+
+	// Avatar versions this chat is pinned to by avatar ID; other avatars follow their latest version
+	AvatarVersions map[string]int `json:"avatarVersions,omitempty" firestore:"avatarVersions,omitempty"`
+
+	// Whether avatars may send generated pictures, paid with the user's image provider key
+	AvatarImages bool `json:"avatarImages,omitempty" firestore:"avatarImages,omitempty"`
+}
+
+// TurnStrategy decides which avatar speaks next in a group chat
+type TurnStrategy string
+
+const (
+	TurnStrategyRoundRobin TurnStrategy = "round_robin" // Avatars take turns in order
+	TurnStrategyMention    TurnStrategy = "mention"     // Avatars named in the user's message reply, otherwise round-robin
+	TurnStrategyLLM        TurnStrategy = "llm"         // The model picks the most fitting speaker
+)
+
+// IsValid reports whether the strategy is known; an empty strategy means round-robin
+func (s TurnStrategy) IsValid() bool {
+	switch s {
+	case "", TurnStrategyRoundRobin, TurnStrategyMention, TurnStrategyLLM:
+		return true
+	}
+	return false
+}
+
+// ModelChain returns the primary model followed by at most MaxFallbackModels fallback models,
+// without duplicates
+func (c *Chat) ModelChain() []string {
+	chain := []string{}
+	seen := make(map[string]bool)
+	for _, modelID := range append([]string{c.ModelID}, c.FallbackModelIDs...) {
+		if len(chain) > MaxFallbackModels {
+			break
+		}
+		if modelID != "" && !seen[modelID] {
+			seen[modelID] = true
+			chain = append(chain, modelID)
+		}
+	}
+	return chain
 }
 
 type Message struct {
-	Role      string `json:"role" firestore:"role"`
-	Content   string `json:"content" firestore:"content"`
-	Timestamp int64  `json:"timestamp" firestore:"timestamp"`
+	Role      string      `json:"role" firestore:"role"`
+	Content   string      `json:"content" firestore:"content"`
+	Timestamp int64       `json:"timestamp" firestore:"timestamp"`
+	ModelID   string      `json:"modelId,omitempty" firestore:"modelId,omitempty"`   // Model that produced an assistant message
+	Usage     *TokenUsage `json:"usage,omitempty" firestore:"usage,omitempty"`       // Only set on assistant messages
+	AvatarID  string      `json:"avatarId,omitempty" firestore:"avatarId,omitempty"` // Avatar that spoke an assistant message
+
+	// Images shown with the message, such as pictures the user sent or generated in the chat
+	Attachments []MessageAttachment `json:"attachments,omitempty" firestore:"attachments,omitempty"`
+}
+
+// Attachment types
+const (
+	AttachmentTypeImage = "image"
+)
+
+// Attachment statuses; generated images are pending until their image job finishes
+const (
+	AttachmentStatusPending = "pending"
+	AttachmentStatusReady   = "ready"
+	AttachmentStatusFailed  = "failed"
+)
+
+// MessageAttachment is an image attached to a chat message
+type MessageAttachment struct {
+	Type    string `json:"type" firestore:"type"`
+	Status  string `json:"status" firestore:"status"`
+	URL     string `json:"url,omitempty" firestore:"url,omitempty"`         // Set once the image is ready
+	ImageID string `json:"imageId,omitempty" firestore:"imageId,omitempty"` // Gallery image
+	JobID   string `json:"jobId,omitempty" firestore:"jobId,omitempty"`     // Image job generating the image
+	Prompt  string `json:"prompt,omitempty" firestore:"prompt,omitempty"`   // Prompt of a generated image
+	Error   string `json:"error,omitempty" firestore:"error,omitempty"`
+
+	// Storage path of an image the user sent
+	StoragePath string `json:"storagePath,omitempty" firestore:"storagePath,omitempty"`
+}
+
+// MaxMessageImages is the number of images a user can send with one message
+const MaxMessageImages = 4
+
+// ChatMessageRequest sends a message to a chat. Images are base64 data URIs and
+// need a model that accepts images; either the message or an image is required.
+type ChatMessageRequest struct {
+	Message string   `json:"message"`
+	Images  []string `json:"images,omitempty"`
 }
 
 type ChatRequest struct {
 	Message   string   `json:"message"`
-	ModelID   string   `json:"modelId" binding:"required"`
+	ModelID   string   `json:"modelId"`             // Falls back to the user's model preferences when empty
 	AvatarID  string   `json:"avatarId"`            // Keep for backward compatibility
 	AvatarIDs []string `json:"avatarIds,omitempty"` // New field for multiple avatars
+
+	FallbackModelIDs  []string     `json:"fallbackModelIds,omitempty"`
+	TurnStrategy      TurnStrategy `json:"turnStrategy,omitempty"`
+	GreetingIndex     *int         `json:"greetingIndex,omitempty"`     // Greeting of the first avatar to open with, random when omitted
+	PinAvatarVersions bool         `json:"pinAvatarVersions,omitempty"` // Keep the avatars' current versions even when they are edited later
+	AvatarImages      bool         `json:"avatarImages,omitempty"`      // Let avatars send generated pictures
+}
+
+// ChatModelsRequest changes the model and fallback models of an existing chat
+type ChatModelsRequest struct {
+	ModelID          string   `json:"modelId" binding:"required"`
+	FallbackModelIDs []string `json:"fallbackModelIds"`
+}
+
+// TurnStrategyRequest changes how the next speaker is picked in a group chat
+type TurnStrategyRequest struct {
+	TurnStrategy TurnStrategy `json:"turnStrategy" binding:"required"`
+}
+
+// AvatarImagesRequest allows or forbids avatars to send generated pictures in a chat
+type AvatarImagesRequest struct {
+	Enabled *bool `json:"enabled" binding:"required"`
+}
+
+// ChatTurnRequest asks an avatar to speak without a new user message.
+// Without an avatar ID the chat's turn strategy picks the speaker.
+type ChatTurnRequest struct {
+	AvatarID string `json:"avatarId"`
 }
 
 type OpenRouterAPIKey struct {
@@ -30,16 +160,32 @@ type OpenRouterAPIKey struct {
 }
 
 type OpenRouterModel struct {
-	ID            string `json:"id"`
-	Name          string `json:"name"`
-	Description   string `json:"description"`
-	Context       int    `json:"context"`
-	PricePerToken struct {
+	ID                  string `json:"id"`
+	Name                string `json:"name"`
+	Description         string `json:"description"`
+	Context             int    `json:"context"`
+	Created             int64  `json:"created"`
+	Modality            string `json:"modality"` // e.g. "text->text" or "text+image->text"
+	Tokenizer           string `json:"tokenizer"`
+	MaxCompletionTokens int    `json:"maxCompletionTokens,omitempty"`
+	IsModerated         bool   `json:"isModerated"`
+	PricePerToken       struct {
 		Prompt     float64 `json:"prompt"`
 		Completion float64 `json:"completion"`
 	} `json:"pricePerToken"`
 }
 
+// IsFree reports whether the model charges nothing for prompt and completion tokens
+func (m *OpenRouterModel) IsFree() bool {
+	return m.PricePerToken.Prompt == 0 && m.PricePerToken.Completion == 0
+}
+
+// AcceptsImages reports whether the model takes images as input, e.g. "text+image->text"
+func (m *OpenRouterModel) AcceptsImages() bool {
+	input, _, _ := strings.Cut(strings.ToLower(m.Modality), "->")
+	return strings.Contains(input, "image")
+}
+
 type APIKeyRequest struct {
 	Key string `json:"key" binding:"required"`
 }
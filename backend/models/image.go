@@ -4,37 +4,148 @@ type Image struct {
 	ID          string `json:"id"`
 	UserID      string `json:"userId"`
 	URL         string `json:"url"`
-	Prompt      string `json:"prompt"`
+	Prompt      string `json:"prompt"` // The prompt as the user wrote it
 	CreatedAt   int64  `json:"createdAt"`
 	StoragePath string `json:"storagePath"`
-	Type        string `json:"type"` // "generated", "inpainted", "variation"
+	Type        string `json:"type"` // "generated", "inpainted", "variation", "upscaled", "background_removed", "portrait", "chat"
+
+	// Prompt sent to the image model when the user's prompt was enhanced
+	EnhancedPrompt string `json:"enhancedPrompt,omitempty"`
+
+	// Gallery image this one was made from by an edit such as upscaling
+	SourceImageID string `json:"sourceImageId,omitempty"`
+
+	// Set by the moderation pipeline; hidden images are only shown to their owner
+	Moderation Moderation `json:"moderation"`
+}
+
+// VisibleTo reports whether a user may see the image. Gallery images are private.
+func (i *Image) VisibleTo(userID string) bool {
+	return i.UserID == userID
 }
 
 type ImageGenerationRequest struct {
-	Prompt string `json:"prompt"`
+	Prompt string                 `json:"prompt"`
+	Model  string                 `json:"model,omitempty"` // Image model ID, the default generation model if empty
+	Input  map[string]interface{} `json:"input,omitempty"` // Extra model inputs, validated against the model's schema
+	ImageGenerationParams
+	PromptEnhancementOptions
+}
+
+// PromptEnhancementOptions ask for the prompt to be rewritten by a chat model before
+// the image is generated
+type PromptEnhancementOptions struct {
+	EnhancePrompt bool   `json:"enhancePrompt,omitempty"`
+	Style         string `json:"style,omitempty"`     // e.g. "photorealistic" or "anime", or any style description
+	ChatModel     string `json:"chatModel,omitempty"` // OpenRouter model ID, the user's default chat model if empty
+}
+
+// PromptEnhancementRequest previews the enhancement of a prompt without generating an image
+type PromptEnhancementRequest struct {
+	Prompt     string `json:"prompt" binding:"required"`
+	Operation  string `json:"operation,omitempty"`  // "generate" (default) or "inpaint"
+	ImageModel string `json:"imageModel,omitempty"` // Image model ID the prompt is meant for
+	Style      string `json:"style,omitempty"`
+	ChatModel  string `json:"chatModel,omitempty"`
+}
+
+// PromptEnhancementResponse returns an enhanced prompt
+type PromptEnhancementResponse struct {
+	Success        bool        `json:"success"`
+	OriginalPrompt string      `json:"originalPrompt,omitempty"`
+	EnhancedPrompt string      `json:"enhancedPrompt,omitempty"`
+	ModelID        string      `json:"modelId,omitempty"` // Chat model that rewrote the prompt
+	Usage          *TokenUsage `json:"usage,omitempty"`
+	Error          string      `json:"error,omitempty"`
+}
+
+// ImageGenerationParams are the common generation settings. Each is mapped to the
+// model's own input and checked against its limits; unset fields use the model's defaults.
+type ImageGenerationParams struct {
+	NegativePrompt string   `json:"negativePrompt,omitempty"`
+	Width          *int     `json:"width,omitempty"`
+	Height         *int     `json:"height,omitempty"`
+	AspectRatio    string   `json:"aspectRatio,omitempty"` // e.g. "16:9", instead of width and height
+	NumOutputs     *int     `json:"numOutputs,omitempty"`
+	Seed           *int     `json:"seed,omitempty"`
+	Guidance       *float64 `json:"guidance,omitempty"`
+	Steps          *int     `json:"steps,omitempty"`
+	OutputFormat   string   `json:"outputFormat,omitempty"` // e.g. "png", "jpg" or "webp"
+}
+
+// Inputs returns the settings that are set, keyed by their standard input names
+func (p ImageGenerationParams) Inputs() map[string]interface{} {
+	inputs := make(map[string]interface{})
+	if p.NegativePrompt != "" {
+		inputs["negative_prompt"] = p.NegativePrompt
+	}
+	if p.Width != nil {
+		inputs["width"] = *p.Width
+	}
+	if p.Height != nil {
+		inputs["height"] = *p.Height
+	}
+	if p.AspectRatio != "" {
+		inputs["aspect_ratio"] = p.AspectRatio
+	}
+	if p.NumOutputs != nil {
+		inputs["num_outputs"] = *p.NumOutputs
+	}
+	if p.Seed != nil {
+		inputs["seed"] = *p.Seed
+	}
+	if p.Guidance != nil {
+		inputs["guidance"] = *p.Guidance
+	}
+	if p.Steps != nil {
+		inputs["steps"] = *p.Steps
+	}
+	if p.OutputFormat != "" {
+		inputs["output_format"] = p.OutputFormat
+	}
+	return inputs
 }
 
 type ImageInpaintRequest struct {
-	ImageURL string `json:"imageUrl"`
-	Prompt   string `json:"prompt"`
-	Mask     string `json:"mask"`
+	ImageURL string                 `json:"imageUrl"`
+	Prompt   string                 `json:"prompt"`
+	Mask     string                 `json:"mask"`
+	Model    string                 `json:"model,omitempty"` // Image model ID, the default inpainting model if empty
+	Input    map[string]interface{} `json:"input,omitempty"` // Extra model inputs, validated against the model's schema
+	PromptEnhancementOptions
 }
 
+// ImageVariationRequest creates new images from a source image and a prompt. The source
+// is a gallery image, an image URL or a base64 data URI.
 type ImageVariationRequest struct {
-	ImageURL string `json:"imageUrl"`
-	Prompt   string `json:"prompt"`
+	ImageID  string                 `json:"imageId,omitempty"` // Gallery image of the current user
+	ImageURL string                 `json:"imageUrl"`          // URL or data URI, used without an image ID
+	Prompt   string                 `json:"prompt"`
+	Strength *float64               `json:"strength,omitempty"` // 0 keeps the source image, 1 ignores it
+	Model    string                 `json:"model,omitempty"`    // Image model ID, the default variation model if empty
+	Input    map[string]interface{} `json:"input,omitempty"`    // Extra model inputs, validated against the model's schema
+	ImageGenerationParams
+}
+
+// ImageEditRequest runs a post-processing operation on a gallery image
+type ImageEditRequest struct {
+	ImageID string `json:"imageId" binding:"required"`
+	Scale   int    `json:"scale,omitempty"` // Upscale factor, 2 or 4 (default 2)
+	Model   string `json:"model,omitempty"` // Image model ID, the operation's default model if empty
 }
 
 type SaveToGalleryRequest struct {
 	ImageURL string `json:"imageUrl"`
 	Prompt   string `json:"prompt"`
-	Type     string `json:"type"` // "generated", "inpainted", "variation"
+	Type     string `json:"type"`            // "generated", "inpainted", "variation"
+	JobID    string `json:"jobId,omitempty"` // Job that produced the image; its enhanced prompt is kept
 }
 
 type ImageResponse struct {
-	Success bool   `json:"success"`
-	Image   *Image `json:"image,omitempty"`
-	Error   string `json:"error,omitempty"`
+	Success bool    `json:"success"`
+	Image   *Image  `json:"image,omitempty"`  // The first image
+	Images  []Image `json:"images,omitempty"` // All images when several were generated
+	Error   string  `json:"error,omitempty"`
 }
 
 // UploadImageRequest represents a request to upload an image directly to the gallery
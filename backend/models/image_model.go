@@ -0,0 +1,83 @@
+package models
+
+// Image operations a model can support
+const (
+	ImageOperationGenerate         = "generate"
+	ImageOperationInpaint          = "inpaint"
+	ImageOperationVariation        = "variation" // Image-to-image from a source image and a prompt
+	ImageOperationUpscale          = "upscale"
+	ImageOperationRemoveBackground = "remove_background"
+)
+
+// How predictions are created for a model on Replicate
+const (
+	ImageEndpointModel   = "model"   // POST /v1/models/{model}/predictions, runs the latest version
+	ImageEndpointVersion = "version" // POST /v1/predictions with a pinned version
+)
+
+// Types of image model parameters
+const (
+	ImageParamString  = "string"
+	ImageParamInteger = "integer"
+	ImageParamNumber  = "number"
+	ImageParamBoolean = "boolean"
+	ImageParamImage   = "image" // An image URL or data URI
+)
+
+// ImageModelParam describes one input of an image model
+type ImageModelParam struct {
+	Name        string      `json:"name"`
+	Type        string      `json:"type"`
+	Description string      `json:"description,omitempty"`
+	Required    bool        `json:"required,omitempty"`
+	Default     interface{} `json:"default,omitempty"` // Sent when the request doesn't set the parameter
+	Min         *float64    `json:"min,omitempty"`     // Bounds of integer and number parameters
+	Max         *float64    `json:"max,omitempty"`
+	Options     []string    `json:"options,omitempty"` // Allowed values of string parameters
+}
+
+// ImageModel describes an image model that users can choose, its input schema and
+// the operations it supports
+type ImageModel struct {
+	ID          string            `json:"id"` // Name users select the model by
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Provider    string            `json:"provider,omitempty"` // Image provider running the model, Replicate if empty
+	Model       string            `json:"model"`              // Replicate model ("owner/name") or Stable Diffusion checkpoint
+	Version     string            `json:"version,omitempty"`  // Pinned version for the version endpoint
+	Endpoint    string            `json:"endpoint,omitempty"` // Replicate endpoint style
+	Operations  []string          `json:"operations"`
+	Params      []ImageModelParam `json:"params"`
+
+	// Names of the standard inputs (prompt, image, mask and the generation settings
+	// such as steps or guidance) in the model's schema, for models that use other names
+	Inputs map[string]string `json:"inputs,omitempty"`
+}
+
+// Supports reports whether the model can run the operation
+func (m *ImageModel) Supports(operation string) bool {
+	for _, op := range m.Operations {
+		if op == operation {
+			return true
+		}
+	}
+	return false
+}
+
+// InputName returns the schema name of a standard operation input
+func (m *ImageModel) InputName(input string) string {
+	if name, ok := m.Inputs[input]; ok && name != "" {
+		return name
+	}
+	return input
+}
+
+// Param returns the schema of a parameter, or nil if the model has no such input
+func (m *ImageModel) Param(name string) *ImageModelParam {
+	for i := range m.Params {
+		if m.Params[i].Name == name {
+			return &m.Params[i]
+		}
+	}
+	return nil
+}
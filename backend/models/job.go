@@ -17,6 +17,7 @@ type Job struct {
 	Type      string    `json:"type"` // "inpaint", "generate", etc.
 	Status    JobStatus `json:"status"`
 	Result    *Image    `json:"result,omitempty"`
+	Results   []Image   `json:"results,omitempty"` // All images of the job; Result is the first
 	Error     string    `json:"error,omitempty"`
 	CreatedAt int64     `json:"createdAt"`
 	UpdatedAt int64     `json:"updatedAt"`
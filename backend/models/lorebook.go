@@ -0,0 +1,60 @@
+package models
+
+import "strings"
+
+// LorebookEntry is a piece of background knowledge that is added to the prompt
+// only when one of its keywords appears in the recent messages
+type LorebookEntry struct {
+	ID          string   `json:"id" firestore:"id"`
+	Keywords    []string `json:"keywords" firestore:"keywords"`
+	Content     string   `json:"content" firestore:"content"`
+	Priority    int      `json:"priority" firestore:"priority"`       // Higher priority entries are injected first
+	TokenBudget int      `json:"tokenBudget" firestore:"tokenBudget"` // Maximum tokens of the entry's content, 0 for no limit
+	Enabled     bool     `json:"enabled" firestore:"enabled"`
+	CreatedAt   int64    `json:"createdAt" firestore:"createdAt"`
+	UpdatedAt   int64    `json:"updatedAt" firestore:"updatedAt"`
+}
+
+// Lorebook is the list of lorebook entries of an avatar or chat
+type Lorebook []LorebookEntry
+
+// Index returns the position of the entry with the given ID, or -1 if there is none
+func (l Lorebook) Index(entryID string) int {
+	for i, entry := range l {
+		if entry.ID == entryID {
+			return i
+		}
+	}
+	return -1
+}
+
+// LorebookEntryRequest creates or replaces a lorebook entry
+type LorebookEntryRequest struct {
+	Keywords    []string `json:"keywords" binding:"required,min=1"`
+	Content     string   `json:"content" binding:"required"`
+	Priority    int      `json:"priority"`
+	TokenBudget int      `json:"tokenBudget" binding:"min=0"`
+	Enabled     *bool    `json:"enabled"` // Defaults to true
+}
+
+// HasValidKeywords reports whether every keyword of the request has text
+func (r *LorebookEntryRequest) HasValidKeywords() bool {
+	for _, keyword := range r.Keywords {
+		if strings.TrimSpace(keyword) == "" {
+			return false
+		}
+	}
+	return len(r.Keywords) > 0
+}
+
+// Apply copies the request's fields onto an entry
+func (r *LorebookEntryRequest) Apply(entry *LorebookEntry) {
+	entry.Keywords = []string{}
+	for _, keyword := range r.Keywords {
+		entry.Keywords = append(entry.Keywords, strings.TrimSpace(keyword))
+	}
+	entry.Content = r.Content
+	entry.Priority = r.Priority
+	entry.TokenBudget = r.TokenBudget
+	entry.Enabled = r.Enabled == nil || *r.Enabled
+}
@@ -0,0 +1,36 @@
+package models
+
+// Where an avatar memory came from
+const (
+	MemorySourceExtracted = "extracted" // Extracted from a conversation by the model
+	MemorySourceUser      = "user"      // Saved with a "remember" command or through the API
+)
+
+// AvatarMemory is a fact an avatar remembers about a user across chats
+type AvatarMemory struct {
+	ID        string `json:"id"`
+	Content   string `json:"content"`
+	Source    string `json:"source"`
+	ChatID    string `json:"chatId,omitempty"` // Chat the memory was taken from
+	CreatedAt int64  `json:"createdAt"`
+	UpdatedAt int64  `json:"updatedAt"`
+}
+
+// AvatarMemoryStore holds everything an avatar remembers about one user.
+// It is stored as a user setting per avatar.
+type AvatarMemoryStore struct {
+	Memories []AvatarMemory `json:"memories"`
+	// Number of messages of each chat that have already been scanned for memories
+	ExtractedChats map[string]int `json:"extractedChats"`
+}
+
+// MemoryRequest creates or edits a memory
+type MemoryRequest struct {
+	Content string `json:"content" binding:"required"`
+}
+
+// MemoryExtractionResult reports the memories extracted from a chat
+type MemoryExtractionResult struct {
+	Memories []AvatarMemory `json:"memories"`
+	Usage    *TokenUsage    `json:"usage,omitempty"`
+}
@@ -0,0 +1,115 @@
+package models
+
+// ModerationState tells whether moderated content may be shown to others
+type ModerationState string
+
+const (
+	ModerationStateNone     ModerationState = ""         // Not reviewed, shown normally
+	ModerationStateApproved ModerationState = "approved" // Reviewed by a moderator and allowed
+	ModerationStatePending  ModerationState = "pending"  // Flagged and waiting for review, left out of public listings
+	ModerationStateHidden   ModerationState = "hidden"   // Hidden by a moderator, only visible to its owner
+)
+
+// Moderation is the moderation status of an avatar or image
+type Moderation struct {
+	State      ModerationState `json:"state,omitempty" firestore:"state,omitempty"`
+	Flags      []string        `json:"flags,omitempty" firestore:"flags,omitempty"` // Categories the content was flagged for
+	Reason     string          `json:"reason,omitempty" firestore:"reason,omitempty"`
+	ReviewedBy string          `json:"reviewedBy,omitempty" firestore:"reviewedBy,omitempty"`
+	UpdatedAt  int64           `json:"updatedAt,omitempty" firestore:"updatedAt,omitempty"`
+}
+
+// IsListed reports whether the content may appear in public listings
+func (m Moderation) IsListed() bool {
+	return m.State != ModerationStatePending && m.State != ModerationStateHidden
+}
+
+// IsHidden reports whether a moderator hid the content from everyone but its owner
+func (m Moderation) IsHidden() bool {
+	return m.State == ModerationStateHidden
+}
+
+// Kinds of content that can be moderated
+const (
+	ModerationTargetAvatar = "avatar"
+	ModerationTargetImage  = "image"
+)
+
+// Moderation case statuses
+const (
+	ModerationCaseOpen     = "open"
+	ModerationCaseResolved = "resolved"
+)
+
+// Where a moderation case came from
+const (
+	ModerationSourceClassifier = "classifier"
+	ModerationSourceReport     = "report"
+)
+
+// Moderator actions
+const (
+	ModerationActionApprove   = "approve"   // The content is fine; it is listed again
+	ModerationActionHide      = "hide"      // Only the owner can still see the content
+	ModerationActionUnpublish = "unpublish" // The avatar is made private
+	ModerationActionBan       = "ban"       // The content is hidden and its owner can no longer publish
+)
+
+// ModerationContent is the content handed to a classifier
+type ModerationContent struct {
+	Text      string
+	ImageURLs []string
+}
+
+// ModerationVerdict is a classifier's judgement of content
+type ModerationVerdict struct {
+	Flagged    bool     `json:"flagged"`
+	Categories []string `json:"categories,omitempty"`
+	Reason     string   `json:"reason,omitempty"`
+}
+
+// ModerationCase collects everything about one avatar or image that needs review.
+// There is one case per target; it is reopened when the target is flagged again.
+type ModerationCase struct {
+	ID         string             `json:"id" firestore:"id"`
+	TargetType string             `json:"targetType" firestore:"targetType"`
+	TargetID   string             `json:"targetId" firestore:"targetId"`
+	OwnerID    string             `json:"ownerId" firestore:"ownerId"`
+	Status     string             `json:"status" firestore:"status"`
+	Flags      []string           `json:"flags,omitempty" firestore:"flags,omitempty"` // Classifier categories
+	Reports    []ModerationReport `json:"reports,omitempty" firestore:"reports,omitempty"`
+	Actions    []ModerationAction `json:"actions,omitempty" firestore:"actions,omitempty"`
+	CreatedAt  int64              `json:"createdAt" firestore:"createdAt"`
+	UpdatedAt  int64              `json:"updatedAt" firestore:"updatedAt"`
+}
+
+// ModerationReport is a flag raised by a user or by the classifier
+type ModerationReport struct {
+	Source     string `json:"source" firestore:"source"`
+	ReporterID string `json:"reporterId,omitempty" firestore:"reporterId,omitempty"`
+	Reason     string `json:"reason" firestore:"reason"`
+	Details    string `json:"details,omitempty" firestore:"details,omitempty"`
+	CreatedAt  int64  `json:"createdAt" firestore:"createdAt"`
+}
+
+// ModerationAction is a decision taken by a moderator
+type ModerationAction struct {
+	Action      string `json:"action" firestore:"action"`
+	ModeratorID string `json:"moderatorId" firestore:"moderatorId"`
+	Reason      string `json:"reason,omitempty" firestore:"reason,omitempty"`
+	CreatedAt   int64  `json:"createdAt" firestore:"createdAt"`
+}
+
+// ReportRequest reports an avatar or image for review
+type ReportRequest struct {
+	TargetType string `json:"targetType" binding:"required"`
+	TargetID   string `json:"targetId" binding:"required"`
+	Reason     string `json:"reason" binding:"required"`
+	Details    string `json:"details"`
+}
+
+// ModerationActionRequest applies a moderator action to a case
+type ModerationActionRequest struct {
+	Action string `json:"action" binding:"required"`
+	Reason string `json:"reason"`
+}
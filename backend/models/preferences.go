@@ -0,0 +1,25 @@
+package models
+
+// ModelPreferences holds a user's chat model choices
+type ModelPreferences struct {
+	DefaultModelID string            `json:"defaultModelId"`
+	Favorites      []string          `json:"favorites"`
+	AvatarModels   map[string]string `json:"avatarModels"` // Avatar ID -> preferred model ID
+}
+
+// ModelPreferencesRequest replaces a user's model preferences
+type ModelPreferencesRequest struct {
+	DefaultModelID string            `json:"defaultModelId"`
+	Favorites      []string          `json:"favorites"`
+	AvatarModels   map[string]string `json:"avatarModels"`
+}
+
+// FavoriteModelRequest adds a model to the user's favorites
+type FavoriteModelRequest struct {
+	ModelID string `json:"modelId" binding:"required"`
+}
+
+// AvatarModelRequest sets the preferred model for an avatar; an empty model ID clears it
+type AvatarModelRequest struct {
+	ModelID string `json:"modelId"`
+}
@@ -0,0 +1,86 @@
+package models
+
+// Where the template used for a system prompt came from
+const (
+	PromptSourceDefault = "default" // Built into the server
+	PromptSourceGlobal  = "global"  // Loaded from PROMPT_TEMPLATES_DIR
+	PromptSourceAvatar  = "avatar"
+	PromptSourceChat    = "chat"
+)
+
+// PromptTemplate is one version of a named system prompt template (text/template syntax)
+type PromptTemplate struct {
+	Name      string `json:"name" firestore:"name"`
+	Text      string `json:"text" firestore:"text"`
+	Version   int    `json:"version" firestore:"version"`
+	UpdatedAt int64  `json:"updatedAt,omitempty" firestore:"updatedAt,omitempty"`
+	Source    string `json:"source,omitempty" firestore:"-"`
+}
+
+// PromptTemplateOverrides holds every version of the templates overridden on an avatar or chat.
+// The highest version of a name is the active one.
+type PromptTemplateOverrides []PromptTemplate
+
+// Latest returns the active override for the given template name, or nil if there is none
+func (o PromptTemplateOverrides) Latest(name string) *PromptTemplate {
+	var latest *PromptTemplate
+	for i := range o {
+		if o[i].Name == name && (latest == nil || o[i].Version > latest.Version) {
+			latest = &o[i]
+		}
+	}
+	return latest
+}
+
+// Versions returns all versions of the given template name, oldest first
+func (o PromptTemplateOverrides) Versions(name string) []PromptTemplate {
+	versions := []PromptTemplate{}
+	for _, template := range o {
+		if template.Name == name {
+			versions = append(versions, template)
+		}
+	}
+	return versions
+}
+
+// Add appends a new version of the named template and returns the updated overrides and the new version
+func (o PromptTemplateOverrides) Add(name, text string, now int64) (PromptTemplateOverrides, PromptTemplate) {
+	version := 1
+	if latest := o.Latest(name); latest != nil {
+		version = latest.Version + 1
+	}
+
+	template := PromptTemplate{
+		Name:      name,
+		Text:      text,
+		Version:   version,
+		UpdatedAt: now,
+	}
+	return append(o, template), template
+}
+
+// Remove drops every version of the named template
+func (o PromptTemplateOverrides) Remove(name string) PromptTemplateOverrides {
+	result := PromptTemplateOverrides{}
+	for _, template := range o {
+		if template.Name != name {
+			result = append(result, template)
+		}
+	}
+	return result
+}
+
+// PromptTemplateRequest sets a new version of a template override
+type PromptTemplateRequest struct {
+	Text string `json:"text" binding:"required"`
+}
+
+// PromptPreview is the rendered system message one avatar of a chat would receive
+type PromptPreview struct {
+	AvatarID   string `json:"avatarId"`
+	AvatarName string `json:"avatarName"`
+	Template   string `json:"template"`
+	Source     string `json:"source"`
+	Version    int    `json:"version"`
+	Content    string `json:"content"`
+}
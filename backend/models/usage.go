@@ -0,0 +1,54 @@
+package models
+
+// TokenUsage holds token counts and the computed cost of one or more completions
+type TokenUsage struct {
+	PromptTokens     int     `json:"promptTokens" firestore:"promptTokens"`
+	CompletionTokens int     `json:"completionTokens" firestore:"completionTokens"`
+	TotalTokens      int     `json:"totalTokens" firestore:"totalTokens"`
+	Cost             float64 `json:"cost" firestore:"cost"` // USD, based on the model's per-token price
+}
+
+// UsageTotals aggregates usage across several assistant messages
+type UsageTotals struct {
+	TokenUsage
+	Messages int `json:"messages" firestore:"messages"`
+}
+
+// Add rolls a single message's usage into the totals
+func (t *UsageTotals) Add(usage *TokenUsage) {
+	if usage == nil {
+		return
+	}
+	t.PromptTokens += usage.PromptTokens
+	t.CompletionTokens += usage.CompletionTokens
+	t.TotalTokens += usage.TotalTokens
+	t.Cost += usage.Cost
+	t.Messages++
+}
+
+// UsageLedger is the per-user usage record stored in the "usage" user setting.
+// Daily is keyed by date (YYYY-MM-DD, UTC) and then by model ID and only covers the
+// last year; Totals covers all time.
+type UsageLedger struct {
+	Totals UsageTotals                        `json:"totals"`
+	Daily  map[string]map[string]*UsageTotals `json:"daily"`
+}
+
+// ModelUsage is the usage of a single model on a given day
+type ModelUsage struct {
+	ModelID string `json:"modelId"`
+	UsageTotals
+}
+
+// DailyUsage is the usage for a single day broken down by model
+type DailyUsage struct {
+	Date   string       `json:"date"`
+	Totals UsageTotals  `json:"totals"`
+	Models []ModelUsage `json:"models"`
+}
+
+// UsageReport is returned by the usage endpoint
+type UsageReport struct {
+	Totals UsageTotals  `json:"totals"`
+	Daily  []DailyUsage `json:"daily"`
+}
@@ -8,6 +8,10 @@ type User struct {
 	PhotoURL    string `json:"photoURL" firestore:"photoURL"`
 	CreatedAt   int64  `json:"createdAt" firestore:"createdAt"`
 	UpdatedAt   int64  `json:"updatedAt" firestore:"updatedAt"`
+
+	// Set by moderators; banned users can no longer publish avatars or report content
+	Banned       bool   `json:"banned,omitempty" firestore:"banned,omitempty"`
+	BannedReason string `json:"bannedReason,omitempty" firestore:"bannedReason,omitempty"`
 }
 
 // UserRequest is used for creating or updating user data
@@ -22,11 +22,45 @@ func SetupChatRoutes(router *gin.Engine, db interfaces.DatabaseService) {
 		chatGroup.GET("/list", chatController.GetChats)
 		chatGroup.GET("/:chatID", chatController.GetChat)
 		chatGroup.DELETE("/:chatID", chatController.DeleteChat)
+		chatGroup.PUT("/:chatID/models", chatController.UpdateChatModels)
+		chatGroup.PUT("/:chatID/turn-strategy", chatController.UpdateTurnStrategy)
+		chatGroup.POST("/:chatID/turn", chatController.TakeTurn)
+		chatGroup.PUT("/:chatID/avatar-versions", chatController.PinAvatarVersion)
+		chatGroup.PUT("/:chatID/avatar-images", chatController.UpdateAvatarImages)
 
 		// OpenRouter configuration
 		chatGroup.GET("/models", chatController.GetModels)
 		chatGroup.POST("/apikey", chatController.SetAPIKey)
 		chatGroup.GET("/apikey/status", chatController.GetAPIKeyStatus)
 		chatGroup.GET("/credits", chatController.GetCredits) // Add new endpoint for credits
+		chatGroup.GET("/usage", chatController.GetUsage)
+
+		// System prompt templates
+		chatGroup.GET("/prompt-templates", chatController.GetPromptTemplates)
+		chatGroup.GET("/:chatID/prompt-templates", chatController.GetChatPromptTemplates)
+		chatGroup.PUT("/:chatID/prompt-templates/:name", chatController.SetChatPromptTemplate)
+		chatGroup.DELETE("/:chatID/prompt-templates/:name", chatController.DeleteChatPromptTemplate)
+		chatGroup.GET("/:chatID/prompt-preview", chatController.PreviewChatPrompt)
+
+		// Lorebook entries
+		chatGroup.GET("/:chatID/lorebook", chatController.GetChatLorebook)
+		chatGroup.POST("/:chatID/lorebook", chatController.CreateChatLorebookEntry)
+		chatGroup.PUT("/:chatID/lorebook/:entryID", chatController.UpdateChatLorebookEntry)
+		chatGroup.DELETE("/:chatID/lorebook/:entryID", chatController.DeleteChatLorebookEntry)
+
+		// Avatar memories
+		chatGroup.GET("/memories/:avatarID", chatController.GetMemories)
+		chatGroup.POST("/memories/:avatarID", chatController.AddMemory)
+		chatGroup.DELETE("/memories/:avatarID", chatController.ClearMemories)
+		chatGroup.PUT("/memories/:avatarID/:memoryID", chatController.UpdateMemory)
+		chatGroup.DELETE("/memories/:avatarID/:memoryID", chatController.DeleteMemory)
+		chatGroup.POST("/:chatID/memories/extract", chatController.ExtractChatMemories)
+
+		// Model preferences
+		chatGroup.GET("/preferences", chatController.GetModelPreferences)
+		chatGroup.PUT("/preferences", chatController.UpdateModelPreferences)
+		chatGroup.POST("/preferences/favorites", chatController.AddFavoriteModel)
+		chatGroup.DELETE("/preferences/favorites", chatController.RemoveFavoriteModel)
+		chatGroup.PUT("/preferences/avatars/:avatarID", chatController.SetAvatarModel)
 	}
 }
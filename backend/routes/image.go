@@ -18,6 +18,9 @@ func SetupImageRoutes(router *gin.Engine) {
 		// Image generation endpoints
 		imageRoutes.POST("/generate", imageController.GenerateImage)
 		imageRoutes.POST("/inpaint", imageController.InpaintImage)
+		imageRoutes.POST("/variations", imageController.CreateVariation)
+		imageRoutes.GET("/models", imageController.ListImageModels)
+		imageRoutes.POST("/prompts/enhance", imageController.EnhancePrompt)
 
 		// Gallery endpoints
 		imageRoutes.POST("/gallery", imageController.SaveToGallery)
@@ -27,10 +30,24 @@ func SetupImageRoutes(router *gin.Engine) {
 
 		// Job-based endpoints
 		imageRoutes.POST("/jobs/inpaint", imageController.StartInpaintJob)
+		imageRoutes.POST("/jobs/variations", imageController.StartVariationJob)
+		imageRoutes.POST("/jobs/upscale", imageController.StartUpscaleJob)
+		imageRoutes.POST("/jobs/remove-background", imageController.StartRemoveBackgroundJob)
 		imageRoutes.GET("/jobs/:jobId", imageController.GetJobStatus)
 
 		// API key management endpoints
 		imageRoutes.POST("/apikey", imageController.SetReplicateAPIKey)
 		imageRoutes.GET("/apikey/status", imageController.GetReplicateAPIKeyStatus)
 	}
+
+	// Profile images generated from an avatar's description
+	avatarImageRoutes := router.Group("/api/avatars")
+	avatarImageRoutes.Use(middleware.AuthMiddleware())
+	{
+		avatarImageRoutes.POST("/:id/portrait", imageController.GenerateAvatarPortrait)
+		avatarImageRoutes.PUT("/:id/portrait", imageController.SelectAvatarPortrait)
+	}
+
+	// Replicate calls this when a prediction completes; requests are authenticated by their signature
+	router.POST("/api/webhooks/replicate", controllers.ReplicateWebhookHandler)
 }
@@ -35,13 +35,14 @@ func SetupRouter(router *gin.Engine) {
 		protected.POST("/inpaint", controllers.InpaintHandler)
 
 		// Setup avatar routes
-		avatarController := controllers.NewAvatarController(services.GetDatabaseService())
+		avatarController := controllers.NewAvatarController(services.GetDatabaseService(), services.GetStorageService())
 
 		// Public avatar endpoints (optional auth)
 		publicAvatars := router.Group("/api/avatars")
 		publicAvatars.Use(middleware.OptionalAuthMiddleware())
 		{
 			publicAvatars.GET("/public", avatarController.GetPublicAvatars)
+			publicAvatars.GET("/categories", avatarController.GetAvatarCategories)
 		}
 
 		// Protected avatar endpoints (require authentication)
@@ -50,10 +51,42 @@ func SetupRouter(router *gin.Engine) {
 		{
 			protectedAvatars.GET("/user", avatarController.GetUserAvatars)
 			protectedAvatars.GET("/:id", avatarController.GetAvatar)
+			protectedAvatars.POST("/import", avatarController.ImportAvatar)
+			protectedAvatars.GET("/:id/export", avatarController.ExportAvatar)
+			protectedAvatars.POST("/:id/fork", avatarController.ForkAvatar)
 			protectedAvatars.POST("", avatarController.CreateAvatar)
 			protectedAvatars.PUT("/:id", avatarController.UpdateAvatar)
 			protectedAvatars.DELETE("/:id", avatarController.DeleteAvatar)
+
+			// System prompt template overrides
+			protectedAvatars.GET("/:id/prompt-templates", avatarController.GetAvatarPromptTemplates)
+			protectedAvatars.PUT("/:id/prompt-templates/:name", avatarController.SetAvatarPromptTemplate)
+			protectedAvatars.DELETE("/:id/prompt-templates/:name", avatarController.DeleteAvatarPromptTemplate)
+
+			// Lorebook entries
+			protectedAvatars.GET("/:id/lorebook", avatarController.GetAvatarLorebook)
+			protectedAvatars.POST("/:id/lorebook", avatarController.CreateAvatarLorebookEntry)
+			protectedAvatars.PUT("/:id/lorebook/:entryID", avatarController.UpdateAvatarLorebookEntry)
+			protectedAvatars.DELETE("/:id/lorebook/:entryID", avatarController.DeleteAvatarLorebookEntry)
+
+			// Version history
+			protectedAvatars.GET("/:id/versions", avatarController.GetAvatarVersions)
+			protectedAvatars.GET("/:id/versions/:version", avatarController.GetAvatarVersion)
+			protectedAvatars.POST("/:id/versions/:version/revert", avatarController.RevertAvatar)
+
+			// Likes and ratings
+			protectedAvatars.PUT("/:id/like", avatarController.LikeAvatar)
+			protectedAvatars.DELETE("/:id/like", avatarController.UnlikeAvatar)
+			protectedAvatars.PUT("/:id/rating", avatarController.RateAvatar)
+			protectedAvatars.DELETE("/:id/rating", avatarController.DeleteAvatarRating)
 		}
+
+		// Content reports and the moderator review queue
+		moderationController := controllers.NewModerationController(services.GetModerationService())
+		protected.POST("/moderation/reports", moderationController.ReportContent)
+		protected.GET("/moderation/queue", moderationController.GetModerationQueue)
+		protected.GET("/moderation/cases/:caseID", moderationController.GetModerationCase)
+		protected.POST("/moderation/cases/:caseID/actions", moderationController.ActOnModerationCase)
 	}
 
 	// Setup image routes (using the dedicated function)
@@ -0,0 +1,461 @@
+package services
+
+import (
+	"backend/interfaces"
+	"backend/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// avatarMemorySettingPrefix is followed by the avatar ID to form the user setting key of a memory store
+	avatarMemorySettingPrefix = "avatar_memory_"
+
+	// maxAvatarMemories caps the memories kept per user and avatar; the oldest extracted ones are dropped first
+	maxAvatarMemories = 200
+
+	// memoryExtractionInterval is the number of new chat messages after which memories are extracted automatically
+	memoryExtractionInterval = 20
+)
+
+var (
+	// ErrMemoryNotFound is returned when a memory does not exist for the user and avatar
+	ErrMemoryNotFound = errors.New("memory not found")
+
+	// ErrExtractionInProgress is returned while memories are already being extracted for the user and one of the avatars
+	ErrExtractionInProgress = errors.New("memory extraction already in progress")
+)
+
+// rememberCommandPattern matches the /remember command at the start of a user message
+var rememberCommandPattern = regexp.MustCompile(`(?is)^\s*/remember\s+(.+)$`)
+
+// memoryWordPattern splits text into words for relevance scoring
+var memoryWordPattern = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// memoryStopWords are ignored when scoring relevance
+var memoryStopWords = map[string]bool{
+	"the": true, "and": true, "for": true, "are": true, "but": true, "not": true, "you": true,
+	"your": true, "with": true, "that": true, "this": true, "have": true, "has": true, "was": true,
+	"were": true, "they": true, "them": true, "their": true, "from": true, "what": true, "when": true,
+	"who": true, "how": true, "about": true, "user": true, "likes": true, "like": true,
+}
+
+// AvatarMemoryService stores the facts each avatar remembers about each user
+type AvatarMemoryService struct {
+	db         interfaces.DatabaseService
+	mu         sync.Mutex
+	extracting map[string]bool // User and avatar pairs with an extraction in flight
+}
+
+var (
+	avatarMemoryService     *AvatarMemoryService
+	avatarMemoryServiceOnce sync.Once
+)
+
+// GetAvatarMemoryService returns the shared avatar memory service. Stores are changed and
+// extractions claimed under its lock, so every controller has to use this instance.
+func GetAvatarMemoryService() *AvatarMemoryService {
+	avatarMemoryServiceOnce.Do(func() {
+		avatarMemoryService = NewAvatarMemoryService(GetDatabaseService())
+	})
+	return avatarMemoryService
+}
+
+// NewAvatarMemoryService creates a new avatar memory service
+func NewAvatarMemoryService(db interfaces.DatabaseService) *AvatarMemoryService {
+	return &AvatarMemoryService{
+		db:         db,
+		extracting: make(map[string]bool),
+	}
+}
+
+// ParseRememberCommand extracts the fact from a command such as "/remember I'm allergic to nuts".
+// Only the explicit command counts, so "remember that…" in a normal message is left to the chat.
+func ParseRememberCommand(message string) (string, bool) {
+	match := rememberCommandPattern.FindStringSubmatch(message)
+	if match == nil {
+		return "", false
+	}
+
+	content := strings.TrimSpace(match[1])
+	return content, content != ""
+}
+
+// List returns the user's memories for an avatar, newest first
+func (s *AvatarMemoryService) List(ctx context.Context, userID, avatarID string) ([]models.AvatarMemory, error) {
+	s.mu.Lock()
+	store, err := s.load(ctx, userID, avatarID)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	memories := store.Memories
+	sort.SliceStable(memories, func(i, j int) bool {
+		return memories[i].UpdatedAt > memories[j].UpdatedAt
+	})
+	return memories, nil
+}
+
+// Add saves a new memory, unless the avatar already remembers the same thing
+func (s *AvatarMemoryService) Add(ctx context.Context, userID, avatarID, content, source, chatID string) (*models.AvatarMemory, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	store, err := s.load(ctx, userID, avatarID)
+	if err != nil {
+		return nil, err
+	}
+	memory := addMemory(store, content, source, chatID, time.Now().Unix())
+	if err := s.save(ctx, userID, avatarID, store); err != nil {
+		return nil, err
+	}
+	return memory, nil
+}
+
+// Update changes the content of a memory
+func (s *AvatarMemoryService) Update(ctx context.Context, userID, avatarID, memoryID, content string) (*models.AvatarMemory, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	store, err := s.load(ctx, userID, avatarID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range store.Memories {
+		if store.Memories[i].ID == memoryID {
+			store.Memories[i].Content = strings.TrimSpace(content)
+			store.Memories[i].UpdatedAt = time.Now().Unix()
+			memory := store.Memories[i]
+			return &memory, s.save(ctx, userID, avatarID, store)
+		}
+	}
+	return nil, ErrMemoryNotFound
+}
+
+// Delete removes a memory
+func (s *AvatarMemoryService) Delete(ctx context.Context, userID, avatarID, memoryID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	store, err := s.load(ctx, userID, avatarID)
+	if err != nil {
+		return err
+	}
+	for i := range store.Memories {
+		if store.Memories[i].ID == memoryID {
+			store.Memories = append(store.Memories[:i], store.Memories[i+1:]...)
+			return s.save(ctx, userID, avatarID, store)
+		}
+	}
+	return ErrMemoryNotFound
+}
+
+// Clear removes everything the avatar remembers about the user
+func (s *AvatarMemoryService) Clear(ctx context.Context, userID, avatarID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.db.DeleteUserSetting(ctx, userID, avatarMemorySettingPrefix+avatarID)
+	if err != nil && !isSettingNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// Relevant returns up to limit memories ranked by how many words they share with the
+// given conversation context. Newer memories win ties.
+func (s *AvatarMemoryService) Relevant(ctx context.Context, userID, avatarID, conversation string, limit int) ([]models.AvatarMemory, error) {
+	memories, err := s.List(ctx, userID, avatarID)
+	if err != nil || len(memories) <= limit {
+		return memories, err
+	}
+
+	contextWords := memoryWords(conversation)
+	scores := make(map[string]int, len(memories))
+	for _, memory := range memories {
+		for word := range memoryWords(memory.Content) {
+			if contextWords[word] {
+				scores[memory.ID]++
+			}
+		}
+	}
+
+	// The list is already sorted by recency, so a stable sort keeps newer memories first on ties
+	sort.SliceStable(memories, func(i, j int) bool {
+		return scores[memories[i].ID] > scores[memories[j].ID]
+	})
+	return memories[:limit], nil
+}
+
+// NeedsExtraction reports whether enough new messages have accumulated in the chat to extract memories
+func (s *AvatarMemoryService) NeedsExtraction(ctx context.Context, chat *models.Chat, avatars []*models.Avatar) (bool, error) {
+	extracted, err := s.extractedCount(ctx, chat, avatars)
+	if err != nil {
+		return false, err
+	}
+	return len(chat.Messages)-extracted >= memoryExtractionInterval, nil
+}
+
+// Extract asks the model for lasting facts about the user in the chat messages that have not been
+// scanned yet and saves them for every avatar of the chat. It returns the new memories and the
+// completion used, so the caller can account for its cost. Only one extraction runs per user and
+// avatar at a time; ErrExtractionInProgress is returned while another one is running.
+func (s *AvatarMemoryService) Extract(ctx context.Context, openRouter *OpenRouterService, chat *models.Chat, avatars []*models.Avatar) ([]models.AvatarMemory, *OpenRouterCompletion, error) {
+	return s.extract(ctx, openRouter, chat, avatars, 1)
+}
+
+// ExtractIfNeeded is like Extract, but only extracts once NeedsExtraction holds. The check is
+// repeated after claiming the extraction, so messages scanned in the meantime aren't sent again.
+func (s *AvatarMemoryService) ExtractIfNeeded(ctx context.Context, openRouter *OpenRouterService, chat *models.Chat, avatars []*models.Avatar) ([]models.AvatarMemory, *OpenRouterCompletion, error) {
+	return s.extract(ctx, openRouter, chat, avatars, memoryExtractionInterval)
+}
+
+// extract runs an extraction if at least minMessages messages have not been scanned yet
+func (s *AvatarMemoryService) extract(ctx context.Context, openRouter *OpenRouterService, chat *models.Chat, avatars []*models.Avatar, minMessages int) ([]models.AvatarMemory, *OpenRouterCompletion, error) {
+	if len(avatars) == 0 {
+		return []models.AvatarMemory{}, nil, nil
+	}
+	if !s.claimExtraction(chat.UserID, avatars) {
+		return nil, nil, ErrExtractionInProgress
+	}
+	defer s.releaseExtraction(chat.UserID, avatars)
+
+	start, err := s.extractedCount(ctx, chat, avatars)
+	if err != nil {
+		return nil, nil, err
+	}
+	end := len(chat.Messages)
+	if end-start < minMessages {
+		return []models.AvatarMemory{}, nil, nil
+	}
+
+	var transcript strings.Builder
+	for _, msg := range chat.Messages[start:end] {
+		transcript.WriteString(fmt.Sprintf("%s: %s\n", SpeakerName(msg, avatars), msg.Content))
+	}
+
+	knownMemories, err := s.List(ctx, chat.UserID, avatars[0].ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	var known strings.Builder
+	for _, memory := range knownMemories {
+		known.WriteString("- " + memory.Content + "\n")
+	}
+	if known.Len() == 0 {
+		known.WriteString("(none)\n")
+	}
+
+	messages := []OpenRouterMessage{
+		{
+			Role: "system",
+			Content: `You extract long-term memories from roleplay conversations.
+List the lasting facts about the user that the characters should remember in future conversations:
+personal details, preferences, important events and how the relationship with the characters developed.
+Ignore small talk, facts about the characters themselves and anything already known.
+Write each fact as one short sentence about "the user".
+Answer with a JSON array of strings only, or [] if there is nothing worth remembering.`,
+		},
+		{
+			Role:    "user",
+			Content: fmt.Sprintf("Already known:\n%s\nConversation:\n%s", known.String(), transcript.String()),
+		},
+	}
+
+	completion, err := openRouter.SendCompletionWithFallback(chat.ModelChain(), messages)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// An unreadable answer still marks the messages as scanned, otherwise they would be
+	// sent again after every new message
+	facts, parseErr := parseMemoryFacts(completion.Content)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().Unix()
+	added := []models.AvatarMemory{}
+	for i, avatar := range avatars {
+		store, err := s.load(ctx, chat.UserID, avatar.ID)
+		if err != nil {
+			return nil, completion, err
+		}
+		for _, fact := range facts {
+			before := len(store.Memories)
+			memory := addMemory(store, fact, models.MemorySourceExtracted, chat.ID, now)
+			if i == 0 && len(store.Memories) > before {
+				added = append(added, *memory)
+			}
+		}
+		store.ExtractedChats[chat.ID] = end
+
+		if err := s.save(ctx, chat.UserID, avatar.ID, store); err != nil {
+			return nil, completion, err
+		}
+	}
+
+	if parseErr != nil {
+		return nil, completion, parseErr
+	}
+	return added, completion, nil
+}
+
+// claimExtraction marks an extraction as running for the user and every avatar, unless one
+// of them already has an extraction running
+func (s *AvatarMemoryService) claimExtraction(userID string, avatars []*models.Avatar) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, avatar := range avatars {
+		if s.extracting[userID+"/"+avatar.ID] {
+			return false
+		}
+	}
+	for _, avatar := range avatars {
+		s.extracting[userID+"/"+avatar.ID] = true
+	}
+	return true
+}
+
+// releaseExtraction marks the extraction claimed by claimExtraction as finished
+func (s *AvatarMemoryService) releaseExtraction(userID string, avatars []*models.Avatar) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, avatar := range avatars {
+		delete(s.extracting, userID+"/"+avatar.ID)
+	}
+}
+
+// extractedCount returns how many messages of the chat have been scanned for every avatar
+func (s *AvatarMemoryService) extractedCount(ctx context.Context, chat *models.Chat, avatars []*models.Avatar) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := -1
+	for _, avatar := range avatars {
+		store, err := s.load(ctx, chat.UserID, avatar.ID)
+		if err != nil {
+			return 0, err
+		}
+		if scanned := store.ExtractedChats[chat.ID]; count < 0 || scanned < count {
+			count = scanned
+		}
+	}
+	if count < 0 || count > len(chat.Messages) {
+		return 0, nil
+	}
+	return count, nil
+}
+
+// load reads a memory store, returning an empty one if none exists yet. A store that can't be
+// read or decoded is an error, so saving doesn't replace the memories it holds.
+func (s *AvatarMemoryService) load(ctx context.Context, userID, avatarID string) (*models.AvatarMemoryStore, error) {
+	store := &models.AvatarMemoryStore{}
+
+	data, err := s.db.GetUserSetting(ctx, userID, avatarMemorySettingPrefix+avatarID)
+	if err != nil && !isSettingNotFound(err) {
+		return nil, fmt.Errorf("failed to read memories of avatar %s for user %s: %v", avatarID, userID, err)
+	}
+	if err == nil {
+		if err := decodeSetting(data, store); err != nil {
+			return nil, fmt.Errorf("failed to decode memories of avatar %s for user %s: %v", avatarID, userID, err)
+		}
+	}
+
+	if store.Memories == nil {
+		store.Memories = []models.AvatarMemory{}
+	}
+	if store.ExtractedChats == nil {
+		store.ExtractedChats = make(map[string]int)
+	}
+	return store, nil
+}
+
+// save writes a memory store
+func (s *AvatarMemoryService) save(ctx context.Context, userID, avatarID string, store *models.AvatarMemoryStore) error {
+	data, err := encodeSetting(store)
+	if err != nil {
+		return err
+	}
+	return s.db.SaveUserSetting(ctx, userID, avatarMemorySettingPrefix+avatarID, data)
+}
+
+// addMemory adds a memory to the store unless an identical one exists, in which case
+// the existing memory is returned. The store is trimmed to maxAvatarMemories.
+func addMemory(store *models.AvatarMemoryStore, content, source, chatID string, now int64) *models.AvatarMemory {
+	content = strings.TrimSpace(content)
+	for i := range store.Memories {
+		if strings.EqualFold(store.Memories[i].Content, content) {
+			return &store.Memories[i]
+		}
+	}
+
+	store.Memories = append(store.Memories, models.AvatarMemory{
+		ID:        uuid.New().String(),
+		Content:   content,
+		Source:    source,
+		ChatID:    chatID,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	memory := store.Memories[len(store.Memories)-1]
+
+	// Drop the oldest extracted memories first; memories the user asked for are kept longest
+	for len(store.Memories) > maxAvatarMemories {
+		drop := 0
+		for i, existing := range store.Memories {
+			if existing.Source == models.MemorySourceExtracted {
+				drop = i
+				break
+			}
+		}
+		store.Memories = append(store.Memories[:drop], store.Memories[drop+1:]...)
+	}
+
+	return &memory
+}
+
+// parseMemoryFacts reads the JSON array of facts from the model's answer, tolerating code fences around it
+func parseMemoryFacts(content string) ([]string, error) {
+	start := strings.Index(content, "[")
+	end := strings.LastIndex(content, "]")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("no JSON array in memory extraction response: %q", content)
+	}
+
+	var facts []string
+	if err := json.Unmarshal([]byte(content[start:end+1]), &facts); err != nil {
+		return nil, fmt.Errorf("failed to decode memory extraction response: %v", err)
+	}
+
+	result := []string{}
+	for _, fact := range facts {
+		if fact = strings.TrimSpace(fact); fact != "" {
+			result = append(result, fact)
+		}
+	}
+	return result, nil
+}
+
+// memoryWords returns the distinct significant words of a text
+func memoryWords(text string) map[string]bool {
+	words := make(map[string]bool)
+	for _, word := range memoryWordPattern.FindAllString(strings.ToLower(text), -1) {
+		if len([]rune(word)) >= 3 && !memoryStopWords[word] {
+			words[word] = true
+		}
+	}
+	return words
+}
@@ -0,0 +1,46 @@
+package services
+
+import (
+	"backend/models"
+	"strings"
+)
+
+// maxPortraitTraitLength caps each avatar field in a portrait prompt; image models
+// ignore most of a long prompt
+const maxPortraitTraitLength = 300
+
+// BuildAvatarPortraitPrompt builds an image prompt for an avatar's profile image from its
+// name, description and persona. Details from the user are added after the avatar's traits.
+func BuildAvatarPortraitPrompt(avatar *models.Avatar, details string) string {
+	parts := []string{"Character portrait of " + strings.TrimSpace(avatar.Name)}
+	if description := portraitTrait(avatar.Description); description != "" {
+		parts = append(parts, description)
+	}
+	if persona := portraitTrait(avatar.Persona); persona != "" {
+		parts = append(parts, "personality: "+persona)
+	}
+	if details = strings.TrimSpace(details); details != "" {
+		parts = append(parts, details)
+	}
+	parts = append(parts, "head and shoulders, facing the viewer, expressive face, detailed, high quality profile picture")
+
+	return strings.Join(parts, ", ")
+}
+
+// portraitTrait flattens an avatar field to one line and shortens it to whole words,
+// dropping the {{char}} and {{user}} macros of imported character cards
+func portraitTrait(text string) string {
+	text = charMacroPattern.ReplaceAllString(text, "")
+	text = userMacroPattern.ReplaceAllString(text, "")
+	text = strings.Join(strings.Fields(text), " ")
+	text = strings.TrimRight(text, ".,;: ")
+
+	if len(text) <= maxPortraitTraitLength {
+		return text
+	}
+	cut := text[:maxPortraitTraitLength]
+	if i := strings.LastIndex(cut, " "); i > 0 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, ".,;: ")
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"backend/interfaces"
+	"backend/models"
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// avatarVotesSettingKey is the user setting holding a user's likes and ratings
+const avatarVotesSettingKey = "avatar_votes"
+
+// AvatarRatingService records likes and ratings of avatars. Each user has at most one
+// vote per avatar; changing a vote only moves the avatar's counters by the difference.
+type AvatarRatingService struct {
+	db interfaces.DatabaseService
+	mu sync.Mutex
+}
+
+// NewAvatarRatingService creates a new avatar rating service
+func NewAvatarRatingService(db interfaces.DatabaseService) *AvatarRatingService {
+	return &AvatarRatingService{
+		db: db,
+	}
+}
+
+// Votes returns the user's votes by avatar ID
+func (s *AvatarRatingService) Votes(ctx context.Context, userID string) (map[string]models.AvatarVote, error) {
+	if userID == "" {
+		return map[string]models.AvatarVote{}, nil
+	}
+	votes, err := s.load(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return votes.Votes, nil
+}
+
+// Like likes or unlikes an avatar
+func (s *AvatarRatingService) Like(ctx context.Context, userID, avatarID string, liked bool) (models.AvatarVote, error) {
+	return s.vote(ctx, userID, avatarID, func(vote models.AvatarVote) models.AvatarVote {
+		vote.Liked = liked
+		return vote
+	})
+}
+
+// Rate sets the user's rating of an avatar; rating 0 removes it
+func (s *AvatarRatingService) Rate(ctx context.Context, userID, avatarID string, rating int) (models.AvatarVote, error) {
+	return s.vote(ctx, userID, avatarID, func(vote models.AvatarVote) models.AvatarVote {
+		vote.Rating = rating
+		return vote
+	})
+}
+
+// vote replaces the user's vote on an avatar with change(previous vote) and moves the avatar's
+// counters by the difference. Repeating a vote changes nothing.
+func (s *AvatarRatingService) vote(ctx context.Context, userID, avatarID string, change func(vote models.AvatarVote) models.AvatarVote) (models.AvatarVote, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	votes, err := s.load(ctx, userID)
+	if err != nil {
+		return models.AvatarVote{}, err
+	}
+	previous := votes.Votes[avatarID]
+	vote := change(previous)
+	if vote.Liked == previous.Liked && vote.Rating == previous.Rating {
+		return previous, nil
+	}
+
+	if err := s.db.UpdateAvatarStats(ctx, avatarID, func(stats *models.AvatarStats) {
+		applyVote(stats, previous, vote)
+	}); err != nil {
+		return previous, err
+	}
+
+	vote.UpdatedAt = time.Now().Unix()
+	if vote.Liked || vote.Rating > 0 {
+		votes.Votes[avatarID] = vote
+	} else {
+		delete(votes.Votes, avatarID)
+	}
+
+	if err := s.save(ctx, userID, votes); err != nil {
+		// Undo the counter change so the stored vote and the counters stay in sync
+		if undoErr := s.db.UpdateAvatarStats(ctx, avatarID, func(stats *models.AvatarStats) {
+			applyVote(stats, vote, previous)
+		}); undoErr != nil {
+			log.Printf("Error undoing vote of user %s on avatar %s: %v", userID, avatarID, undoErr)
+		}
+		return previous, err
+	}
+	return vote, nil
+}
+
+// applyVote moves an avatar's counters from one vote of a user to another
+func applyVote(stats *models.AvatarStats, from, to models.AvatarVote) {
+	if from.Liked != to.Liked {
+		if to.Liked {
+			stats.LikeCount++
+		} else {
+			stats.LikeCount--
+		}
+	}
+	if from.Rating != to.Rating {
+		stats.ChangeRating(from.Rating, to.Rating)
+	}
+}
+
+// load reads the user's votes, returning none if the user hasn't voted yet. Votes that can't be
+// read are an error; voting without them would count the user's earlier votes again.
+func (s *AvatarRatingService) load(ctx context.Context, userID string) (*models.AvatarVotes, error) {
+	votes := &models.AvatarVotes{}
+
+	data, err := s.db.GetUserSetting(ctx, userID, avatarVotesSettingKey)
+	if err != nil && !isSettingNotFound(err) {
+		return nil, fmt.Errorf("failed to read avatar votes of user %s: %v", userID, err)
+	}
+	if err == nil {
+		if err := decodeSetting(data, votes); err != nil {
+			return nil, fmt.Errorf("failed to decode avatar votes of user %s: %v", userID, err)
+		}
+	}
+
+	if votes.Votes == nil {
+		votes.Votes = make(map[string]models.AvatarVote)
+	}
+	return votes, nil
+}
+
+func (s *AvatarRatingService) save(ctx context.Context, userID string, votes *models.AvatarVotes) error {
+	data, err := encodeSetting(votes)
+	if err != nil {
+		return err
+	}
+	return s.db.SaveUserSetting(ctx, userID, avatarVotesSettingKey, data)
+}
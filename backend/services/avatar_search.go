@@ -0,0 +1,218 @@
+package services
+
+import (
+	"backend/models"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Page sizes of the public avatar listing
+const (
+	DefaultAvatarPageSize = 24
+	MaxAvatarPageSize     = 100
+)
+
+// Limits on the tags of an avatar
+const (
+	MaxAvatarTags      = 10
+	maxAvatarTagLength = 32
+)
+
+// searchWordPattern splits text into the words that can be searched for
+var searchWordPattern = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// avatarCursor is the position after which the next page of avatars starts
+type avatarCursor struct {
+	Value int64  `json:"v"`
+	ID    string `json:"id"`
+}
+
+// NormalizeAvatarQuery fills in defaults and validates a public avatar query
+func NormalizeAvatarQuery(query *models.AvatarQuery) error {
+	query.Tag = normalizeAvatarTag(query.Tag)
+	query.Category = strings.ToLower(strings.TrimSpace(query.Category))
+
+	if query.Sort == "" {
+		query.Sort = models.AvatarSortNewest
+	}
+	if avatarSortField(query.Sort) == "" {
+		return fmt.Errorf("unknown sort %q", query.Sort)
+	}
+
+	if query.Limit <= 0 {
+		query.Limit = DefaultAvatarPageSize
+	}
+	if query.Limit > MaxAvatarPageSize {
+		query.Limit = MaxAvatarPageSize
+	}
+
+	if _, err := decodeAvatarCursor(query.Cursor); err != nil {
+		return err
+	}
+	return nil
+}
+
+// NormalizeAvatarTags lowercases and trims tags, dropping empty, overlong and duplicate ones
+func NormalizeAvatarTags(tags []string) []string {
+	normalized := []string{}
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = normalizeAvatarTag(tag)
+		if tag == "" || len(tag) > maxAvatarTagLength || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
+func normalizeAvatarTag(tag string) string {
+	return strings.Join(strings.Fields(strings.ToLower(tag)), " ")
+}
+
+// AvatarSearchTerms returns the words an avatar can be found by
+func AvatarSearchTerms(avatar *models.Avatar) []string {
+	text := strings.Join(append([]string{avatar.Name, avatar.Description, avatar.Category}, avatar.Tags...), " ")
+
+	terms := []string{}
+	seen := make(map[string]bool)
+	for _, term := range searchWords(text) {
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// searchWords splits text into lowercase words
+func searchWords(text string) []string {
+	return searchWordPattern.FindAllString(strings.ToLower(text), -1)
+}
+
+// MatchesAvatarQuery reports whether a public avatar passes the query's filters
+func MatchesAvatarQuery(avatar *models.Avatar, query models.AvatarQuery) bool {
+	if !avatar.IsPublic || !avatar.Moderation.IsListed() {
+		return false
+	}
+	if query.Category != "" && avatar.Category != query.Category {
+		return false
+	}
+
+	if query.Tag != "" {
+		found := false
+		for _, tag := range avatar.Tags {
+			found = found || tag == query.Tag
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if words := searchWords(query.Search); len(words) > 0 {
+		terms := make(map[string]bool)
+		for _, term := range AvatarSearchTerms(avatar) {
+			terms[term] = true
+		}
+		for _, word := range words {
+			if !terms[word] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// SortAvatars orders avatars for the listing, highest sort value first
+func SortAvatars(avatars []*models.Avatar, sortBy string) {
+	sort.Slice(avatars, func(i, j int) bool {
+		return avatarAfter(avatars[j], avatarCursorOf(avatars[i], sortBy), sortBy)
+	})
+}
+
+// avatarPage builds a page from up to limit+1 sorted matches; the extra match only tells that another page follows
+func avatarPage(avatars []*models.Avatar, query models.AvatarQuery) *models.AvatarPage {
+	page := &models.AvatarPage{Avatars: avatars}
+	if len(avatars) > query.Limit {
+		page.Avatars = avatars[:query.Limit]
+		page.NextCursor = encodeAvatarCursor(page.Avatars[query.Limit-1], query.Sort)
+	}
+	if page.Avatars == nil {
+		page.Avatars = []*models.Avatar{}
+	}
+	return page
+}
+
+// avatarSortField returns the stored field an order sorts by, or "" for unknown orders
+func avatarSortField(sortBy string) string {
+	switch sortBy {
+	case models.AvatarSortNewest:
+		return "createdAt"
+	case models.AvatarSortMostUsed:
+		return "usageCount"
+	case models.AvatarSortMostForked:
+		return "forkCount"
+	case models.AvatarSortMostLiked:
+		return "likeCount"
+	case models.AvatarSortTopRated:
+		return "ratingScore"
+	}
+	return ""
+}
+
+// avatarSortValue returns the value an avatar is ordered by
+func avatarSortValue(avatar *models.Avatar, sortBy string) int64 {
+	switch sortBy {
+	case models.AvatarSortMostUsed:
+		return int64(avatar.UsageCount)
+	case models.AvatarSortMostForked:
+		return int64(avatar.ForkCount)
+	case models.AvatarSortMostLiked:
+		return int64(avatar.LikeCount)
+	case models.AvatarSortTopRated:
+		return int64(avatar.RatingScore)
+	}
+	return avatar.CreatedAt
+}
+
+func avatarCursorOf(avatar *models.Avatar, sortBy string) avatarCursor {
+	return avatarCursor{Value: avatarSortValue(avatar, sortBy), ID: avatar.ID}
+}
+
+// avatarAfter reports whether an avatar comes after the cursor position in the listing
+func avatarAfter(avatar *models.Avatar, cursor avatarCursor, sortBy string) bool {
+	value := avatarSortValue(avatar, sortBy)
+	if value != cursor.Value {
+		return value < cursor.Value
+	}
+	return avatar.ID < cursor.ID
+}
+
+// encodeAvatarCursor returns the opaque cursor of the page following the avatar
+func encodeAvatarCursor(avatar *models.Avatar, sortBy string) string {
+	data, _ := json.Marshal(avatarCursorOf(avatar, sortBy))
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeAvatarCursor parses a cursor; an empty cursor means the first page
+func decodeAvatarCursor(cursor string) (*avatarCursor, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	var decoded avatarCursor
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded.ID == "" {
+		return nil, errors.New("invalid cursor")
+	}
+	return &decoded, nil
+}
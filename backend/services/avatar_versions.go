@@ -0,0 +1,210 @@
+package services
+
+import (
+	"backend/interfaces"
+	"backend/models"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrAvatarVersionExists is returned when saving a version number that is already taken
+var ErrAvatarVersionExists = errors.New("avatar version already exists")
+
+// AvatarVersionService keeps the immutable version history of avatars.
+// Every change to an avatar's persona is recorded as a new version, and chats
+// can be pinned to the version they were started with.
+type AvatarVersionService struct {
+	db interfaces.DatabaseService
+	mu sync.Mutex
+}
+
+var (
+	avatarVersionService     *AvatarVersionService
+	avatarVersionServiceOnce sync.Once
+)
+
+// GetAvatarVersionService returns the shared avatar version service. Versions are numbered
+// under its lock, so every controller that changes avatars has to use this instance.
+func GetAvatarVersionService() *AvatarVersionService {
+	avatarVersionServiceOnce.Do(func() {
+		avatarVersionService = NewAvatarVersionService(GetDatabaseService())
+	})
+	return avatarVersionService
+}
+
+// NewAvatarVersionService creates a new avatar version service
+func NewAvatarVersionService(db interfaces.DatabaseService) *AvatarVersionService {
+	return &AvatarVersionService{
+		db: db,
+	}
+}
+
+// Create saves a new avatar together with its first version. Change tells how the
+// avatar came to be, e.g. models.AvatarChangeCreated or models.AvatarChangeForked.
+func (s *AvatarVersionService) Create(ctx context.Context, avatar *models.Avatar, change, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	avatar.Version = 0
+	if err := s.record(ctx, avatar, avatar.Snapshot(), change, 0, userID); err != nil {
+		return err
+	}
+	return s.db.SaveAvatar(ctx, avatar)
+}
+
+// Update saves an edited avatar. If its persona differs from previous, the new state is
+// recorded as the next version. Avatars created before version history existed first
+// get their previous state recorded as version 1.
+func (s *AvatarVersionService) Update(ctx context.Context, avatar *models.Avatar, previous models.AvatarSnapshot, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.syncVersion(ctx, avatar); err != nil {
+		return err
+	}
+	if current := avatar.Snapshot(); !current.Equal(previous) {
+		if avatar.Version == 0 {
+			if err := s.record(ctx, avatar, previous, models.AvatarChangeInitial, 0, avatar.OwnerID); err != nil {
+				return err
+			}
+		}
+		if err := s.record(ctx, avatar, current, models.AvatarChangeUpdated, 0, userID); err != nil {
+			return err
+		}
+	}
+	return s.db.UpdateAvatar(ctx, avatar)
+}
+
+// Revert restores the persona of an earlier version. The history is not rewritten;
+// the restored state becomes the newest version.
+func (s *AvatarVersionService) Revert(ctx context.Context, avatar *models.Avatar, version int, userID string) (*models.AvatarVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.syncVersion(ctx, avatar); err != nil {
+		return nil, err
+	}
+	target, err := s.db.GetAvatarVersion(ctx, avatar.ID, version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get avatar version %d: %v", version, err)
+	}
+
+	if !avatar.Snapshot().Equal(target.Snapshot) {
+		avatar.ApplySnapshot(target.Snapshot)
+		if err := s.record(ctx, avatar, target.Snapshot, models.AvatarChangeReverted, version, userID); err != nil {
+			return nil, err
+		}
+		avatar.UpdatedAt = time.Now().Unix()
+		if err := s.db.UpdateAvatar(ctx, avatar); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.db.GetAvatarVersion(ctx, avatar.ID, avatar.Version)
+}
+
+// List returns the versions of an avatar, oldest first
+func (s *AvatarVersionService) List(ctx context.Context, avatarID string) ([]*models.AvatarVersion, error) {
+	versions, err := s.db.GetAvatarVersions(ctx, avatarID)
+	if err != nil {
+		return nil, err
+	}
+	if versions == nil {
+		versions = []*models.AvatarVersion{}
+	}
+	return versions, nil
+}
+
+// Get returns a single version of an avatar
+func (s *AvatarVersionService) Get(ctx context.Context, avatarID string, version int) (*models.AvatarVersion, error) {
+	return s.db.GetAvatarVersion(ctx, avatarID, version)
+}
+
+// CurrentVersion returns the avatar's latest version number so a chat can be pinned to it,
+// recording the stored state first for avatars without history
+func (s *AvatarVersionService) CurrentVersion(ctx context.Context, avatar *models.Avatar) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.syncVersion(ctx, avatar); err != nil {
+		return 0, err
+	}
+	if avatar.Version > 0 {
+		return avatar.Version, nil
+	}
+	if err := s.record(ctx, avatar, avatar.Snapshot(), models.AvatarChangeInitial, 0, avatar.OwnerID); err != nil {
+		return 0, err
+	}
+	if err := s.db.UpdateAvatar(ctx, avatar); err != nil {
+		return 0, err
+	}
+	return avatar.Version, nil
+}
+
+// ApplyPins returns the chat's avatars as of the versions the chat is pinned to.
+// Avatars that are not pinned, or whose pinned version can't be loaded, are returned as they are.
+func (s *AvatarVersionService) ApplyPins(ctx context.Context, chat *models.Chat, avatars []*models.Avatar) []*models.Avatar {
+	if len(chat.AvatarVersions) == 0 {
+		return avatars
+	}
+
+	pinned := make([]*models.Avatar, 0, len(avatars))
+	for _, avatar := range avatars {
+		version, ok := chat.AvatarVersions[avatar.ID]
+		if !ok || version == avatar.Version {
+			pinned = append(pinned, avatar)
+			continue
+		}
+
+		avatarVersion, err := s.db.GetAvatarVersion(ctx, avatar.ID, version)
+		if err != nil {
+			log.Printf("Error loading pinned version %d of avatar %s, using the latest: %v", version, avatar.ID, err)
+			pinned = append(pinned, avatar)
+			continue
+		}
+
+		versioned := *avatar
+		versioned.ApplySnapshot(avatarVersion.Snapshot)
+		pinned = append(pinned, &versioned)
+	}
+	return pinned
+}
+
+// syncVersion sets avatar.Version to the highest saved version. The caller's copy of the
+// avatar may have been loaded before another change was recorded; callers must hold s.mu.
+func (s *AvatarVersionService) syncVersion(ctx context.Context, avatar *models.Avatar) error {
+	versions, err := s.db.GetAvatarVersions(ctx, avatar.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get avatar versions: %v", err)
+	}
+
+	avatar.Version = 0
+	for _, version := range versions {
+		if version.Version > avatar.Version {
+			avatar.Version = version.Version
+		}
+	}
+	return nil
+}
+
+// record saves snapshot as the avatar's next version and advances avatar.Version
+func (s *AvatarVersionService) record(ctx context.Context, avatar *models.Avatar, snapshot models.AvatarSnapshot, change string, revertedFrom int, userID string) error {
+	version := &models.AvatarVersion{
+		AvatarID:     avatar.ID,
+		Version:      avatar.Version + 1,
+		Snapshot:     snapshot,
+		Change:       change,
+		RevertedFrom: revertedFrom,
+		CreatedBy:    userID,
+		CreatedAt:    time.Now().Unix(),
+	}
+	if err := s.db.SaveAvatarVersion(ctx, version); err != nil {
+		return fmt.Errorf("failed to save avatar version %d: %w", version.Version, err)
+	}
+	avatar.Version = version.Version
+	return nil
+}
@@ -0,0 +1,360 @@
+package services
+
+import (
+	"backend/models"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"  // Register GIF decoding for profile images
+	_ "image/jpeg" // Register JPEG decoding for profile images
+	"image/png"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Character card spec identifiers and the PNG tEXt keywords cards are stored under
+const (
+	CharacterCardSpecV2    = "chara_card_v2"
+	CharacterCardSpecV3    = "chara_card_v3"
+	characterCardKeyword   = "chara"
+	characterCardV3Keyword = "ccv3"
+)
+
+var pngSignature = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n'}
+
+// Macros used by other tools for the character's and the user's names
+var (
+	charMacroPattern = regexp.MustCompile(`(?i){{char}}|<bot>`)
+	userMacroPattern = regexp.MustCompile(`(?i){{user}}|<user>`)
+)
+
+// IsPNG reports whether data starts with the PNG signature
+func IsPNG(data []byte) bool {
+	return bytes.HasPrefix(data, pngSignature)
+}
+
+// ParseCharacterCard decodes a version 1, 2 or 3 character card from JSON
+func ParseCharacterCard(data []byte) (*models.CharacterCard, error) {
+	var envelope struct {
+		Spec string          `json:"spec"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, fmt.Errorf("invalid character card JSON: %v", err)
+	}
+
+	card := &models.CharacterCard{Spec: CharacterCardSpecV2, SpecVersion: "2.0"}
+	switch {
+	case envelope.Spec != "" && len(envelope.Data) > 0:
+		if err := json.Unmarshal(envelope.Data, &card.Data); err != nil {
+			return nil, fmt.Errorf("invalid character card data: %v", err)
+		}
+	case envelope.Spec == "":
+		// Version 1 cards keep the fields at the top level
+		if err := json.Unmarshal(data, &card.Data); err != nil {
+			return nil, fmt.Errorf("invalid character card data: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported character card spec %q", envelope.Spec)
+	}
+
+	if strings.TrimSpace(card.Data.Name) == "" {
+		return nil, errors.New("character card has no name")
+	}
+	return card, nil
+}
+
+// ReadCharacterCardFromPNG returns the card JSON embedded in a PNG's tEXt chunk.
+// The version 3 chunk is preferred when both are present.
+func ReadCharacterCardFromPNG(data []byte) ([]byte, error) {
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var encoded string
+	for _, chunk := range chunks {
+		if chunk.kind != "tEXt" {
+			continue
+		}
+		keyword, text, found := bytes.Cut(chunk.data, []byte{0})
+		if !found {
+			continue
+		}
+		switch string(keyword) {
+		case characterCardV3Keyword:
+			encoded = string(text)
+		case characterCardKeyword:
+			if encoded == "" {
+				encoded = string(text)
+			}
+		}
+	}
+
+	if encoded == "" {
+		return nil, errors.New("PNG does not contain character card data")
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid character card encoding: %v", err)
+	}
+	return decoded, nil
+}
+
+// WriteCharacterCardToPNG embeds the card JSON into a PNG as a base64 tEXt chunk,
+// replacing any card data the image already carries
+func WriteCharacterCardToPNG(data []byte, cardJSON []byte) ([]byte, error) {
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		return nil, err
+	}
+
+	text := append([]byte(characterCardKeyword+"\x00"), base64.StdEncoding.EncodeToString(cardJSON)...)
+
+	var buf bytes.Buffer
+	buf.Write(pngSignature)
+	for _, chunk := range chunks {
+		if chunk.kind == "tEXt" {
+			keyword, _, _ := bytes.Cut(chunk.data, []byte{0})
+			if string(keyword) == characterCardKeyword || string(keyword) == characterCardV3Keyword {
+				continue
+			}
+		}
+		if chunk.kind == "IEND" {
+			writePNGChunk(&buf, "tEXt", text)
+		}
+		writePNGChunk(&buf, chunk.kind, chunk.data)
+	}
+	return buf.Bytes(), nil
+}
+
+// ToPNG returns image data as PNG, converting other formats. Without usable data,
+// a plain placeholder image in the usual card size is returned.
+func ToPNG(data []byte) []byte {
+	if IsPNG(data) {
+		return data
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		placeholder := image.NewRGBA(image.Rect(0, 0, 400, 600))
+		draw.Draw(placeholder, placeholder.Bounds(), &image.Uniform{C: color.RGBA{R: 64, G: 64, B: 80, A: 255}}, image.Point{}, draw.Src)
+		img = placeholder
+	}
+
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+// CharacterCardToAvatar maps a card onto a new avatar. Name macros are replaced,
+// the first message and alternate greetings become greetings, the example messages
+// become example dialogues and the character book becomes the lorebook.
+func CharacterCardToAvatar(card *models.CharacterCard, now int64) *models.Avatar {
+	data := card.Data
+	name := strings.TrimSpace(data.Name)
+	expand := func(text string) string {
+		text = charMacroPattern.ReplaceAllString(text, name)
+		return strings.TrimSpace(userMacroPattern.ReplaceAllString(text, "User"))
+	}
+
+	avatar := &models.Avatar{
+		Name:            name,
+		Description:     expand(data.Description),
+		Story:           expand(data.Scenario),
+		Persona:         expand(data.Personality),
+		CreatorNickname: data.Creator,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+
+	avatar.Tags = NormalizeAvatarTags(data.Tags)
+	if len(avatar.Tags) > MaxAvatarTags {
+		avatar.Tags = avatar.Tags[:MaxAvatarTags]
+	}
+
+	for _, greeting := range append([]string{data.FirstMes}, data.AlternateGreetings...) {
+		if greeting = expand(greeting); greeting != "" {
+			avatar.Greetings = append(avatar.Greetings, greeting)
+		}
+	}
+
+	avatar.ExampleDialogues = parseExampleMessages(data.MesExample, name, expand)
+
+	if data.CharacterBook != nil {
+		for _, entry := range data.CharacterBook.Entries {
+			keywords := []string{}
+			for _, key := range entry.Keys {
+				if key = strings.TrimSpace(key); key != "" {
+					keywords = append(keywords, key)
+				}
+			}
+			if len(keywords) == 0 {
+				continue
+			}
+
+			priority := entry.Priority
+			if priority == 0 {
+				priority = entry.InsertionOrder
+			}
+			avatar.Lorebook = append(avatar.Lorebook, models.LorebookEntry{
+				ID:        uuid.New().String(),
+				Keywords:  keywords,
+				Content:   expand(entry.Content),
+				Priority:  priority,
+				Enabled:   entry.Enabled,
+				CreatedAt: now,
+				UpdatedAt: now,
+			})
+		}
+	}
+
+	return avatar
+}
+
+// AvatarToCharacterCard maps an avatar onto a version 2 character card
+func AvatarToCharacterCard(avatar *models.Avatar) *models.CharacterCard {
+	data := models.CharacterCardData{
+		Name:               avatar.Name,
+		Description:        avatar.Description,
+		Personality:        avatar.Persona,
+		Scenario:           avatar.Story,
+		AlternateGreetings: []string{},
+		Tags:               append([]string{}, avatar.Tags...),
+		Creator:            avatar.CreatorNickname,
+		Extensions:         map[string]interface{}{},
+	}
+
+	if len(avatar.Greetings) > 0 {
+		data.FirstMes = avatar.Greetings[0]
+		data.AlternateGreetings = append(data.AlternateGreetings, avatar.Greetings[1:]...)
+	}
+
+	var examples strings.Builder
+	for _, dialogue := range avatar.ExampleDialogues {
+		examples.WriteString(fmt.Sprintf("<START>\n{{user}}: %s\n{{char}}: %s\n", dialogue.User, dialogue.Avatar))
+	}
+	data.MesExample = examples.String()
+
+	if len(avatar.Lorebook) > 0 {
+		book := &models.CharacterBook{
+			Entries:    []models.CharacterBookEntry{},
+			Extensions: map[string]interface{}{},
+		}
+		for i, entry := range avatar.Lorebook {
+			book.Entries = append(book.Entries, models.CharacterBookEntry{
+				ID:             i + 1,
+				Keys:           entry.Keywords,
+				Content:        entry.Content,
+				Enabled:        entry.Enabled,
+				InsertionOrder: entry.Priority,
+				Priority:       entry.Priority,
+				Extensions:     map[string]interface{}{},
+			})
+		}
+		data.CharacterBook = book
+	}
+
+	return &models.CharacterCard{
+		Spec:        CharacterCardSpecV2,
+		SpecVersion: "2.0",
+		Data:        data,
+	}
+}
+
+// parseExampleMessages reads "<START>"-separated example chats made of "{{user}}:" and
+// "{{char}}:" lines into user/avatar pairs. Lines without a speaker continue the previous turn.
+func parseExampleMessages(text, name string, expand func(string) string) []models.ExampleDialogue {
+	var dialogues []models.ExampleDialogue
+
+	for _, block := range regexp.MustCompile(`(?i)<start>`).Split(text, -1) {
+		var pending *models.ExampleDialogue
+		var current *string
+
+		for _, line := range strings.Split(block, "\n") {
+			trimmed := strings.TrimSpace(line)
+			lower := strings.ToLower(trimmed)
+
+			switch {
+			case strings.HasPrefix(lower, "{{user}}:") || strings.HasPrefix(lower, "<user>:"):
+				if pending != nil && pending.Avatar != "" {
+					dialogues = append(dialogues, *pending)
+				}
+				pending = &models.ExampleDialogue{User: trimmed[strings.Index(trimmed, ":")+1:]}
+				current = &pending.User
+			case strings.HasPrefix(lower, "{{char}}:") || strings.HasPrefix(lower, "<bot>:") ||
+				(name != "" && strings.HasPrefix(lower, strings.ToLower(name)+":")):
+				if pending == nil {
+					continue
+				}
+				pending.Avatar += "\n" + trimmed[strings.Index(trimmed, ":")+1:]
+				current = &pending.Avatar
+			case current != nil:
+				*current += "\n" + line
+			}
+		}
+
+		if pending != nil && pending.Avatar != "" {
+			dialogues = append(dialogues, *pending)
+		}
+	}
+
+	for i := range dialogues {
+		dialogues[i].User = expand(dialogues[i].User)
+		dialogues[i].Avatar = expand(dialogues[i].Avatar)
+	}
+	return dialogues
+}
+
+type pngChunk struct {
+	kind string
+	data []byte
+}
+
+// readPNGChunks splits PNG data into its chunks, checking lengths but not CRCs
+func readPNGChunks(data []byte) ([]pngChunk, error) {
+	if !IsPNG(data) {
+		return nil, errors.New("not a PNG image")
+	}
+
+	var chunks []pngChunk
+	offset := len(pngSignature)
+	for offset+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[offset : offset+4]))
+		kind := string(data[offset+4 : offset+8])
+		end := offset + 8 + length + 4
+		if length < 0 || end > len(data) {
+			return nil, errors.New("truncated PNG chunk")
+		}
+
+		chunks = append(chunks, pngChunk{kind: kind, data: data[offset+8 : offset+8+length]})
+		offset = end
+
+		if kind == "IEND" {
+			return chunks, nil
+		}
+	}
+	return nil, errors.New("PNG has no IEND chunk")
+}
+
+// writePNGChunk writes a chunk with its length and CRC
+func writePNGChunk(buf *bytes.Buffer, kind string, data []byte) {
+	binary.Write(buf, binary.BigEndian, uint32(len(data)))
+	buf.WriteString(kind)
+	buf.Write(data)
+
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(kind))
+	crc.Write(data)
+	binary.Write(buf, binary.BigEndian, crc.Sum32())
+}
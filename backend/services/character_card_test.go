@@ -0,0 +1,194 @@
+package services
+
+import (
+	"backend/models"
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"reflect"
+	"testing"
+)
+
+func testCardAvatar() *models.Avatar {
+	return &models.Avatar{
+		Name:            "Mira",
+		Description:     "A lighthouse keeper",
+		Story:           "A storm is coming",
+		Persona:         "Calm and curious",
+		CreatorNickname: "tester",
+		Tags:            []string{"fantasy", "slice of life"},
+		Greetings:       []string{"Welcome to the lighthouse.", "Back again?"},
+		ExampleDialogues: []models.ExampleDialogue{
+			{User: "Who are you?", Avatar: "I keep the light burning."},
+			{User: "Is it safe?", Avatar: "Safer than the sea."},
+		},
+		Lorebook: []models.LorebookEntry{
+			{Keywords: []string{"lighthouse", "lamp"}, Content: "The lamp has never gone out.", Priority: 5, Enabled: true},
+			{Keywords: []string{"storm"}, Content: "Storms come from the west.", Priority: 1, Enabled: false},
+		},
+	}
+}
+
+// assertSameCharacter compares the fields a character card carries
+func assertSameCharacter(t *testing.T, got, want *models.Avatar) {
+	t.Helper()
+
+	if got.Name != want.Name || got.Description != want.Description || got.Story != want.Story ||
+		got.Persona != want.Persona || got.CreatorNickname != want.CreatorNickname {
+		t.Errorf("character = %+v, want %+v", got, want)
+	}
+	if !reflect.DeepEqual(got.Tags, want.Tags) {
+		t.Errorf("tags = %v, want %v", got.Tags, want.Tags)
+	}
+	if !reflect.DeepEqual(got.Greetings, want.Greetings) {
+		t.Errorf("greetings = %q, want %q", got.Greetings, want.Greetings)
+	}
+	if !reflect.DeepEqual(got.ExampleDialogues, want.ExampleDialogues) {
+		t.Errorf("example dialogues = %q, want %q", got.ExampleDialogues, want.ExampleDialogues)
+	}
+
+	if len(got.Lorebook) != len(want.Lorebook) {
+		t.Fatalf("lorebook has %d entries, want %d", len(got.Lorebook), len(want.Lorebook))
+	}
+	for i, entry := range got.Lorebook {
+		expected := want.Lorebook[i]
+		if !reflect.DeepEqual(entry.Keywords, expected.Keywords) || entry.Content != expected.Content ||
+			entry.Priority != expected.Priority || entry.Enabled != expected.Enabled {
+			t.Errorf("lorebook entry %d = %+v, want %+v", i, entry, expected)
+		}
+		if entry.ID == "" {
+			t.Errorf("lorebook entry %d has no ID", i)
+		}
+	}
+}
+
+func TestCharacterCardJSONRoundTrip(t *testing.T) {
+	avatar := testCardAvatar()
+
+	cardJSON, err := json.Marshal(AvatarToCharacterCard(avatar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	card, err := ParseCharacterCard(cardJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if card.Spec != CharacterCardSpecV2 {
+		t.Errorf("spec = %q", card.Spec)
+	}
+
+	assertSameCharacter(t, CharacterCardToAvatar(card, 100), avatar)
+}
+
+func TestCharacterCardPNGRoundTrip(t *testing.T) {
+	avatar := testCardAvatar()
+
+	img := image.NewRGBA(image.Rect(0, 0, 4, 6))
+	img.Set(1, 2, color.RGBA{R: 200, A: 255})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	cardJSON, err := json.Marshal(AvatarToCharacterCard(avatar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pngData, err := WriteCharacterCardToPNG(buf.Bytes(), cardJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The image itself must survive embedding the card
+	decoded, err := png.Decode(bytes.NewReader(pngData))
+	if err != nil {
+		t.Fatalf("card PNG is not a valid image: %v", err)
+	}
+	r, _, _, _ := decoded.At(1, 2).RGBA()
+	if decoded.Bounds() != img.Bounds() || r>>8 != 200 {
+		t.Error("card PNG does not contain the original image")
+	}
+
+	// Exporting again replaces the card instead of adding a second one
+	avatar.Name = "Mira the Second"
+	updatedJSON, err := json.Marshal(AvatarToCharacterCard(avatar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pngData, err = WriteCharacterCardToPNG(pngData, updatedJSON); err != nil {
+		t.Fatal(err)
+	}
+
+	embedded, err := ReadCharacterCardFromPNG(pngData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	card, err := ParseCharacterCard(embedded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSameCharacter(t, CharacterCardToAvatar(card, 100), avatar)
+}
+
+func TestReadCharacterCardFromPNGPrefersV3(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	pngData, err := WriteCharacterCardToPNG(buf.Bytes(), []byte(`{"name":"V2"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Add a version 3 chunk before IEND, as newer tools write both
+	chunks, err := readPNGChunks(pngData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	out.Write(pngSignature)
+	for _, chunk := range chunks {
+		if chunk.kind == "IEND" {
+			writePNGChunk(&out, "tEXt", []byte(characterCardV3Keyword+"\x00eyJuYW1lIjoiVjMifQ=="))
+		}
+		writePNGChunk(&out, chunk.kind, chunk.data)
+	}
+
+	embedded, err := ReadCharacterCardFromPNG(out.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(embedded) != `{"name":"V3"}` {
+		t.Errorf("embedded card = %s", embedded)
+	}
+}
+
+func TestParseCharacterCardV1(t *testing.T) {
+	card, err := ParseCharacterCard([]byte(`{"name":"Old","first_mes":"Hi {{user}}, I am {{char}}."}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	avatar := CharacterCardToAvatar(card, 100)
+	if len(avatar.Greetings) != 1 || avatar.Greetings[0] != "Hi User, I am Old." {
+		t.Errorf("greetings = %q", avatar.Greetings)
+	}
+}
+
+func TestParseCharacterCardErrors(t *testing.T) {
+	for name, data := range map[string]string{
+		"invalid JSON":     `{`,
+		"missing name":     `{"spec":"chara_card_v2","data":{"description":"x"}}`,
+		"spec but no data": `{"spec":"chara_card_v2"}`,
+		"v1 missing name":  `{"description":"x"}`,
+	} {
+		if _, err := ParseCharacterCard([]byte(data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	if _, err := ReadCharacterCardFromPNG([]byte("not a png")); err == nil {
+		t.Error("expected an error for data that isn't a PNG")
+	}
+}
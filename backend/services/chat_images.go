@@ -0,0 +1,150 @@
+package services
+
+import (
+	"backend/models"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ChatImageJobType is the job type of images generated in chats
+const ChatImageJobType = "chat_image"
+
+// AvatarImageInstructions tell avatars how to send a picture in chats that allow it
+const AvatarImageInstructions = `You can send the user a picture when it fits the conversation, for example when they ask to see something or when showing it adds to the scene.
+To send one, write <generate_image>a detailed visual description of the picture</generate_image> anywhere in your reply.
+Describe what is visible (subject, setting, lighting, style), not what happens off-screen. Send at most one picture per reply and only when it adds something.`
+
+// imageCommandPattern matches the image commands a user can send in a chat,
+// e.g. "/imagine a lighthouse at dusk"
+var imageCommandPattern = regexp.MustCompile(`(?is)^\s*/(?:imagine|image)\s+(.+)$`)
+
+// imageRequestPattern matches the image requests avatars embed in their replies
+var imageRequestPattern = regexp.MustCompile(`(?is)<generate_image>(.*?)</generate_image>`)
+
+// ParseImageCommand extracts the prompt of an image command such as "/imagine a red fox"
+func ParseImageCommand(message string) (string, bool) {
+	match := imageCommandPattern.FindStringSubmatch(message)
+	if match == nil {
+		return "", false
+	}
+
+	prompt := strings.TrimSpace(match[1])
+	return prompt, prompt != ""
+}
+
+// ExtractImageRequest removes the image requests from an avatar's reply and returns the
+// cleaned reply with the prompt of the first request, or "" if the reply has none
+func ExtractImageRequest(content string) (string, string) {
+	matches := imageRequestPattern.FindAllStringSubmatch(content, -1)
+	if matches == nil {
+		return content, ""
+	}
+
+	prompt := ""
+	for _, match := range matches {
+		if prompt = strings.Join(strings.Fields(match[1]), " "); prompt != "" {
+			break
+		}
+	}
+
+	cleaned := imageRequestPattern.ReplaceAllString(content, "")
+	return strings.TrimSpace(cleaned), prompt
+}
+
+// Tags of the image requests avatars embed in their replies
+const (
+	imageRequestOpen  = "<generate_image>"
+	imageRequestClose = "</generate_image>"
+)
+
+// ImageRequestFilter removes the image requests from a reply while it is streamed. Text that
+// may start a request is held back until it is known; an unclosed request is shown as written,
+// like ExtractImageRequest leaves it in the saved reply.
+type ImageRequestFilter struct {
+	held  string // Text not shown yet
+	inTag bool   // held starts with an opening tag whose closing tag hasn't arrived
+}
+
+// Write adds a chunk of the reply and returns the part of the reply that can be shown
+func (f *ImageRequestFilter) Write(chunk string) string {
+	f.held += chunk
+
+	var shown strings.Builder
+	for {
+		if f.inTag {
+			end := indexFold(f.held[len(imageRequestOpen):], imageRequestClose)
+			if end < 0 {
+				return shown.String()
+			}
+			f.held = f.held[len(imageRequestOpen)+end+len(imageRequestClose):]
+			f.inTag = false
+			continue
+		}
+
+		if start := indexFold(f.held, imageRequestOpen); start >= 0 {
+			shown.WriteString(f.held[:start])
+			f.held = f.held[start:]
+			f.inTag = true
+			continue
+		}
+
+		// Hold back an ending that may be the start of an opening tag
+		keep := 0
+		for n := len(imageRequestOpen) - 1; n > 0; n-- {
+			if n <= len(f.held) && strings.EqualFold(f.held[len(f.held)-n:], imageRequestOpen[:n]) {
+				keep = n
+				break
+			}
+		}
+		shown.WriteString(f.held[:len(f.held)-keep])
+		f.held = f.held[len(f.held)-keep:]
+		return shown.String()
+	}
+}
+
+// Flush returns the text still held back once the reply is complete
+func (f *ImageRequestFilter) Flush() string {
+	held := f.held
+	f.held = ""
+	f.inTag = false
+	return held
+}
+
+// indexFold is strings.Index ignoring ASCII case
+func indexFold(s, substr string) int {
+	for i := 0; i+len(substr) <= len(s); i++ {
+		if strings.EqualFold(s[i:i+len(substr)], substr) {
+			return i
+		}
+	}
+	return -1
+}
+
+// MessageImageURLs returns the URLs of the message's ready images
+func MessageImageURLs(msg models.Message) []string {
+	var urls []string
+	for _, attachment := range msg.Attachments {
+		if attachment.Type == models.AttachmentTypeImage && attachment.Status == models.AttachmentStatusReady && attachment.URL != "" {
+			urls = append(urls, attachment.URL)
+		}
+	}
+	return urls
+}
+
+// DescribeAttachments describes the images of a message for chat models that can't see them,
+// so avatars know what was shown
+func DescribeAttachments(msg models.Message) string {
+	var descriptions []string
+	for _, attachment := range msg.Attachments {
+		if attachment.Type != models.AttachmentTypeImage || attachment.Status != models.AttachmentStatusReady {
+			continue
+		}
+		if attachment.Prompt != "" {
+			descriptions = append(descriptions, fmt.Sprintf("[Picture: %s]", attachment.Prompt))
+		} else {
+			descriptions = append(descriptions, "[Picture]")
+		}
+	}
+	return strings.Join(descriptions, "\n")
+}
@@ -5,9 +5,12 @@ import (
 	"backend/models"
 	"context"
 	"fmt"
+	"strconv"
 
 	"cloud.google.com/go/firestore"
 	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type FirebaseDatabase struct {
@@ -100,17 +103,164 @@ func (db *FirebaseDatabase) GetPublicAvatars(ctx context.Context) ([]*models.Ava
 	return avatars, nil
 }
 
+// SearchPublicAvatars returns a page of public avatars. Firestore allows a single array-contains
+// filter per query, so only the first search word (or the tag) is filtered by the query and the
+// rest in memory. Sorted queries need composite indexes on isPublic, the optional category and
+// array filters, and the sort field; avatars saved before the sort fields existed are left out
+// until they are saved again.
+func (db *FirebaseDatabase) SearchPublicAvatars(ctx context.Context, query models.AvatarQuery) (*models.AvatarPage, error) {
+	cursor, err := decodeAvatarCursor(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	
+	q := db.client.Collection("avatars").Where("isPublic", "==", true)
+	if query.Category != "" {
+		q = q.Where("category", "==", query.Category)
+	}
+	if words := searchWords(query.Search); len(words) > 0 {
+		q = q.Where("searchTerms", "array-contains", words[0])
+	} else if query.Tag != "" {
+		q = q.Where("tags", "array-contains", query.Tag)
+	}
+	q = q.OrderBy(avatarSortField(query.Sort), firestore.Desc).OrderBy(firestore.DocumentID, firestore.Desc)
+	if cursor != nil {
+		q = q.StartAfter(cursor.Value, cursor.ID)
+	}
+	
+	// Read in batches until the page is full, fetching one extra match to know whether another page follows
+	batchSize := query.Limit + 1
+	var avatars []*models.Avatar
+	for {
+		docs, err := q.Limit(batchSize).Documents(ctx).GetAll()
+		if err != nil {
+			return nil, err
+		}
+		
+		for _, doc := range docs {
+			var avatar models.Avatar
+			if err := doc.DataTo(&avatar); err != nil {
+				continue
+			}
+			if MatchesAvatarQuery(&avatar, query) {
+				avatars = append(avatars, &avatar)
+			}
+		}
+		
+		if len(avatars) > query.Limit || len(docs) < batchSize {
+			break
+		}
+		
+		var last models.Avatar
+		if err := docs[len(docs)-1].DataTo(&last); err != nil {
+			return nil, err
+		}
+		q = q.StartAfter(avatarSortValue(&last, query.Sort), docs[len(docs)-1].Ref.ID)
+	}
+	
+	return avatarPage(avatars, query), nil
+}
+
 func (db *FirebaseDatabase) SaveAvatar(ctx context.Context, avatar *models.Avatar) error {
+	avatar.SearchTerms = AvatarSearchTerms(avatar)
 	_, err := db.client.Collection("avatars").Doc(avatar.ID).Set(ctx, avatar)
 	return err
 }
 
 func (db *FirebaseDatabase) UpdateAvatar(ctx context.Context, avatar *models.Avatar) error {
-	return db.SaveAvatar(ctx, avatar)
+	avatar.SearchTerms = AvatarSearchTerms(avatar)
+	ref := db.client.Collection("avatars").Doc(avatar.ID)
+	
+	// Counters are only changed through UpdateAvatarStats, so the stored ones are kept
+	return db.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		var stored models.Avatar
+		if err := doc.DataTo(&stored); err != nil {
+			return err
+		}
+		avatar.AvatarStats = stored.AvatarStats
+		return tx.Set(ref, avatar)
+	})
 }
 
 func (db *FirebaseDatabase) DeleteAvatar(ctx context.Context, avatarID string) error {
-	_, err := db.client.Collection("avatars").Doc(avatarID).Delete(ctx)
+	// Subcollections are not deleted with their parent document
+	versions, err := db.client.Collection("avatars").Doc(avatarID).Collection("versions").DocumentRefs(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		if _, err := version.Delete(ctx); err != nil {
+			return err
+		}
+	}
+
+	_, err = db.client.Collection("avatars").Doc(avatarID).Delete(ctx)
+	return err
+}
+
+// UpdateAvatarStats changes an avatar's counters in a transaction, so concurrent updates aren't lost
+func (db *FirebaseDatabase) UpdateAvatarStats(ctx context.Context, avatarID string, update func(stats *models.AvatarStats)) error {
+	ref := db.client.Collection("avatars").Doc(avatarID)
+	return db.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		var avatar models.Avatar
+		if err := doc.DataTo(&avatar); err != nil {
+			return err
+		}
+		update(&avatar.AvatarStats)
+		return tx.Set(ref, &avatar)
+	})
+}
+
+// Avatar version operations
+func (db *FirebaseDatabase) GetAvatarVersions(ctx context.Context, avatarID string) ([]*models.AvatarVersion, error) {
+	iter := db.client.Collection("avatars").Doc(avatarID).Collection("versions").OrderBy("version", firestore.Asc).Documents(ctx)
+	defer iter.Stop()
+	
+	var versions []*models.AvatarVersion
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		
+		var version models.AvatarVersion
+		if err := doc.DataTo(&version); err != nil {
+			continue
+		}
+		versions = append(versions, &version)
+	}
+	return versions, nil
+}
+
+func (db *FirebaseDatabase) GetAvatarVersion(ctx context.Context, avatarID string, version int) (*models.AvatarVersion, error) {
+	doc, err := db.client.Collection("avatars").Doc(avatarID).Collection("versions").Doc(strconv.Itoa(version)).Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	
+	var avatarVersion models.AvatarVersion
+	if err := doc.DataTo(&avatarVersion); err != nil {
+		return nil, err
+	}
+	return &avatarVersion, nil
+}
+
+func (db *FirebaseDatabase) SaveAvatarVersion(ctx context.Context, version *models.AvatarVersion) error {
+	_, err := db.client.Collection("avatars").Doc(version.AvatarID).Collection("versions").Doc(strconv.Itoa(version.Version)).Create(ctx, version)
+	if status.Code(err) == codes.AlreadyExists {
+		return ErrAvatarVersionExists
+	}
 	return err
 }
 
@@ -212,6 +362,53 @@ func (db *FirebaseDatabase) DeleteImage(ctx context.Context, imageID string) err
 	return err
 }
 
+// Moderation operations
+func (db *FirebaseDatabase) GetModerationCase(ctx context.Context, caseID string) (*models.ModerationCase, error) {
+	doc, err := db.client.Collection("moderation_cases").Doc(caseID).Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	
+	var moderationCase models.ModerationCase
+	if err := doc.DataTo(&moderationCase); err != nil {
+		return nil, err
+	}
+	return &moderationCase, nil
+}
+
+// GetModerationCases returns the cases with the given status, or all cases for an empty status, oldest first
+func (db *FirebaseDatabase) GetModerationCases(ctx context.Context, status string) ([]*models.ModerationCase, error) {
+	query := db.client.Collection("moderation_cases").Query
+	if status != "" {
+		query = query.Where("status", "==", status)
+	}
+	iter := query.OrderBy("createdAt", firestore.Asc).Documents(ctx)
+	defer iter.Stop()
+	
+	var cases []*models.ModerationCase
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		
+		var moderationCase models.ModerationCase
+		if err := doc.DataTo(&moderationCase); err != nil {
+			continue
+		}
+		cases = append(cases, &moderationCase)
+	}
+	return cases, nil
+}
+
+func (db *FirebaseDatabase) SaveModerationCase(ctx context.Context, moderationCase *models.ModerationCase) error {
+	_, err := db.client.Collection("moderation_cases").Doc(moderationCase.ID).Set(ctx, moderationCase)
+	return err
+}
+
 // Settings operations
 func (db *FirebaseDatabase) GetUserSetting(ctx context.Context, userID, settingKey string) (map[string]interface{}, error) {
 	doc, err := db.client.Collection("users").Doc(userID).Collection("settings").Doc(settingKey).Get(ctx)
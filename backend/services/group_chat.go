@@ -0,0 +1,163 @@
+package services
+
+import (
+	"backend/models"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// speakerSelectionHistory is the number of recent messages shown to the model when it picks the next speaker
+const speakerSelectionHistory = 12
+
+// GroupChatOrchestrator decides which avatars reply in a multi-avatar chat
+type GroupChatOrchestrator struct {
+	openRouter *OpenRouterService
+}
+
+// TurnPlan lists the avatars that reply next, in order
+type TurnPlan struct {
+	Speakers []*models.Avatar
+	// The completion used to pick the speaker, set only when the model chose it
+	Selection *OpenRouterCompletion
+}
+
+// NewGroupChatOrchestrator creates a new group chat orchestrator
+func NewGroupChatOrchestrator(openRouter *OpenRouterService) *GroupChatOrchestrator {
+	return &GroupChatOrchestrator{
+		openRouter: openRouter,
+	}
+}
+
+// PlanTurn picks the avatars that reply to the user's message according to the chat's turn strategy.
+// Single-avatar chats always get their only avatar.
+func (o *GroupChatOrchestrator) PlanTurn(chat *models.Chat, avatars []*models.Avatar, userMessage string) *TurnPlan {
+	if len(avatars) <= 1 {
+		return &TurnPlan{Speakers: avatars}
+	}
+
+	switch chat.TurnStrategy {
+	case models.TurnStrategyMention:
+		if mentioned := MentionedAvatars(userMessage, avatars); len(mentioned) > 0 {
+			return &TurnPlan{Speakers: mentioned}
+		}
+	case models.TurnStrategyLLM:
+		speaker, selection, err := o.chooseSpeaker(chat, avatars)
+		if err != nil {
+			log.Printf("Error choosing next speaker for chat %s, using round-robin: %v", chat.ID, err)
+		} else {
+			return &TurnPlan{Speakers: []*models.Avatar{speaker}, Selection: selection}
+		}
+	}
+
+	return &TurnPlan{Speakers: []*models.Avatar{NextRoundRobinSpeaker(chat, avatars)}}
+}
+
+// NextRoundRobinSpeaker returns the avatar after the one that spoke last,
+// or the first avatar if none has spoken yet
+func NextRoundRobinSpeaker(chat *models.Chat, avatars []*models.Avatar) *models.Avatar {
+	for i := len(chat.Messages) - 1; i >= 0; i-- {
+		avatarID := chat.Messages[i].AvatarID
+		if avatarID == "" {
+			continue
+		}
+		for j, avatar := range avatars {
+			if avatar.ID == avatarID {
+				return avatars[(j+1)%len(avatars)]
+			}
+		}
+	}
+	return avatars[0]
+}
+
+// MentionedAvatars returns the avatars whose names appear in the text, in order of first mention.
+// Names are matched case-insensitively as whole words, with or without a leading "@".
+func MentionedAvatars(text string, avatars []*models.Avatar) []*models.Avatar {
+	type mention struct {
+		avatar   *models.Avatar
+		position int
+	}
+
+	var mentions []mention
+	for _, avatar := range avatars {
+		if strings.TrimSpace(avatar.Name) == "" {
+			continue
+		}
+		if loc := wordPattern(avatar.Name).FindStringIndex(text); loc != nil {
+			mentions = append(mentions, mention{avatar: avatar, position: loc[0]})
+		}
+	}
+
+	sort.SliceStable(mentions, func(i, j int) bool {
+		return mentions[i].position < mentions[j].position
+	})
+
+	result := make([]*models.Avatar, 0, len(mentions))
+	for _, m := range mentions {
+		result = append(result, m.avatar)
+	}
+	return result
+}
+
+// chooseSpeaker asks the chat's model which avatar should speak next
+func (o *GroupChatOrchestrator) chooseSpeaker(chat *models.Chat, avatars []*models.Avatar) (*models.Avatar, *OpenRouterCompletion, error) {
+	var names []string
+	for _, avatar := range avatars {
+		names = append(names, avatar.Name)
+	}
+
+	var transcript strings.Builder
+	start := len(chat.Messages) - speakerSelectionHistory
+	if start < 0 {
+		start = 0
+	}
+	for _, msg := range chat.Messages[start:] {
+		transcript.WriteString(fmt.Sprintf("%s: %s\n", SpeakerName(msg, avatars), msg.Content))
+	}
+
+	messages := []OpenRouterMessage{
+		{
+			Role: "system",
+			Content: fmt.Sprintf(`You are directing a group roleplay conversation between the user and these characters: %s.
+Based on the conversation so far, decide which character should speak next.
+Answer with the character's name only.`, strings.Join(names, ", ")),
+		},
+		{
+			Role:    "user",
+			Content: transcript.String(),
+		},
+	}
+
+	completion, err := o.openRouter.SendCompletionWithFallback(chat.ModelChain(), messages)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	answer := strings.Trim(strings.TrimSpace(completion.Content), "[]*.\"'")
+	for _, avatar := range avatars {
+		if strings.EqualFold(answer, avatar.Name) {
+			return avatar, completion, nil
+		}
+	}
+	if mentioned := MentionedAvatars(answer, avatars); len(mentioned) > 0 {
+		return mentioned[0], completion, nil
+	}
+
+	// The selection still cost tokens, so report it along with the fallback speaker
+	log.Printf("Model answered %q when choosing the next speaker, using round-robin", completion.Content)
+	return NextRoundRobinSpeaker(chat, avatars), completion, nil
+}
+
+// SpeakerName returns the display name of a message's author
+func SpeakerName(msg models.Message, avatars []*models.Avatar) string {
+	if msg.Role == "user" {
+		return "User"
+	}
+	for _, avatar := range avatars {
+		if avatar.ID == msg.AvatarID {
+			return avatar.Name
+		}
+	}
+	return "Character"
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxStoredImageSize caps the images downloaded from storage for self-hosted models
+const maxStoredImageSize = 20 << 20 // 20MB
+
+// imageExtensions maps the image types accepted in data URIs to file extensions
+var imageExtensions = map[string]string{
+	"image/png":  "png",
+	"image/jpeg": "jpg",
+	"image/gif":  "gif",
+	"image/webp": "webp",
+}
+
+// ImageDataURIExtension returns the file extension for the media type of a base64 image
+// data URI, or false if it isn't one of the supported image types
+func ImageDataURIExtension(dataURI string) (string, bool) {
+	if !strings.HasPrefix(dataURI, "data:") {
+		return "", false
+	}
+	header, _, found := strings.Cut(strings.TrimPrefix(dataURI, "data:"), ",")
+	if !found {
+		return "", false
+	}
+	mediaType, params, _ := strings.Cut(header, ";")
+	if params != "base64" {
+		return "", false
+	}
+
+	extension, ok := imageExtensions[strings.ToLower(mediaType)]
+	return extension, ok
+}
+
+// ModelImageURL returns a URL under which model providers can load a stored image. Local
+// storage serves images from this server, which providers can't reach, so those are
+// inlined as data URIs.
+func ModelImageURL(url string) string {
+	local, ok := GetStorageService().(*LocalStorage)
+	if !ok {
+		return url
+	}
+
+	dataURI, err := local.DataURI(url)
+	if err != nil {
+		log.Printf("Error inlining local image %s: %v", url, err)
+		return url
+	}
+	return dataURI
+}
+
+// LoadStoredImage returns a data URI or an image in this app's storage as plain base64.
+// Other URLs are refused, so requests can't make the server fetch arbitrary addresses.
+func LoadStoredImage(source string) (string, error) {
+	if strings.HasPrefix(source, "data:") {
+		_, data, found := strings.Cut(source, ",")
+		if !found {
+			return "", fmt.Errorf("invalid data URI")
+		}
+		return data, nil
+	}
+
+	switch storage := GetStorageService().(type) {
+	case *LocalStorage:
+		if strings.HasPrefix(source, storage.baseURL+"/") {
+			dataURI, err := storage.DataURI(source)
+			if err != nil {
+				return "", err
+			}
+			_, data, _ := strings.Cut(dataURI, ",")
+			return data, nil
+		}
+	case *FirebaseService:
+		for _, prefix := range []string{
+			"https://storage.googleapis.com/download/storage/v1/b/" + storage.bucket + "/o/",
+			"https://firebasestorage.googleapis.com/v0/b/" + storage.bucket + "/o/",
+		} {
+			if storage.bucket != "" && strings.HasPrefix(source, prefix) {
+				return downloadStoredImage(source)
+			}
+		}
+	}
+	return "", fmt.Errorf("only gallery images and data URIs can be used as source images")
+}
+
+// downloadStoredImage downloads an image from storage as base64, up to maxStoredImageSize
+func downloadStoredImage(url string) (string, error) {
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("download failed with status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxStoredImageSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > maxStoredImageSize {
+		return "", fmt.Errorf("image is larger than %d MB", maxStoredImageSize>>20)
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
@@ -0,0 +1,416 @@
+package services
+
+import (
+	"backend/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrImageModelNotFound is returned for unknown image models
+var ErrImageModelNotFound = errors.New("image model not found")
+
+var (
+	imageModelRegistry     *ImageModelRegistry
+	imageModelRegistryOnce sync.Once
+)
+
+// ImageModelRegistry holds the image models users can choose from. The first model
+// supporting an operation is used when a request doesn't name one.
+type ImageModelRegistry struct {
+	models []models.ImageModel
+	byID   map[string]*models.ImageModel
+}
+
+// GetImageModelRegistry returns the shared registry. Models are read from the JSON file
+// in IMAGE_MODELS_FILE, or the built-in models are used if it isn't set.
+func GetImageModelRegistry() *ImageModelRegistry {
+	imageModelRegistryOnce.Do(func() {
+		imageModels := DefaultImageModels()
+		if path := os.Getenv("IMAGE_MODELS_FILE"); path != "" {
+			loaded, err := LoadImageModels(path)
+			if err != nil {
+				log.Printf("Warning: could not load image models from %s, using the built-in models: %v", path, err)
+			} else {
+				imageModels = loaded
+			}
+		}
+
+		registry, err := NewImageModelRegistry(imageModels)
+		if err != nil {
+			log.Printf("Warning: invalid image models, using the built-in models: %v", err)
+			registry, _ = NewImageModelRegistry(DefaultImageModels())
+		}
+		imageModelRegistry = registry
+	})
+	return imageModelRegistry
+}
+
+// LoadImageModels reads image model definitions from a JSON file
+func LoadImageModels(path string) ([]models.ImageModel, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var imageModels []models.ImageModel
+	if err := json.Unmarshal(data, &imageModels); err != nil {
+		return nil, fmt.Errorf("invalid image models: %v", err)
+	}
+	return imageModels, nil
+}
+
+// NewImageModelRegistry creates a registry after checking the model definitions
+func NewImageModelRegistry(imageModels []models.ImageModel) (*ImageModelRegistry, error) {
+	registry := &ImageModelRegistry{
+		models: imageModels,
+		byID:   make(map[string]*models.ImageModel),
+	}
+
+	for i := range registry.models {
+		model := &registry.models[i]
+		if model.ID == "" {
+			return nil, fmt.Errorf("image model %d needs an id", i)
+		}
+		if registry.byID[model.ID] != nil {
+			return nil, fmt.Errorf("duplicate image model %s", model.ID)
+		}
+		switch model.Provider {
+		case "", ImageProviderReplicate:
+			if model.Model == "" {
+				return nil, fmt.Errorf("image model %s needs a Replicate model", model.ID)
+			}
+			switch model.Endpoint {
+			case models.ImageEndpointModel:
+			case models.ImageEndpointVersion:
+				if model.Version == "" {
+					return nil, fmt.Errorf("image model %s uses the version endpoint but has no version", model.ID)
+				}
+			default:
+				return nil, fmt.Errorf("image model %s has unknown endpoint %q", model.ID, model.Endpoint)
+			}
+		case ImageProviderStableDiffusion:
+		default:
+			return nil, fmt.Errorf("image model %s has unknown provider %q", model.ID, model.Provider)
+		}
+		for _, param := range model.Params {
+			switch param.Type {
+			case models.ImageParamString, models.ImageParamInteger, models.ImageParamNumber, models.ImageParamBoolean, models.ImageParamImage:
+			default:
+				return nil, fmt.Errorf("image model %s: parameter %s has unknown type %q", model.ID, param.Name, param.Type)
+			}
+		}
+		registry.byID[model.ID] = model
+	}
+
+	return registry, nil
+}
+
+// available reports whether the configured image provider runs the model. The mock
+// provider stands in for Replicate, so it offers the same models.
+func (r *ImageModelRegistry) available(model *models.ImageModel) bool {
+	provider := ImageProviderName()
+	if provider == ImageProviderMock {
+		provider = ImageProviderReplicate
+	}
+	if model.Provider == "" {
+		return provider == ImageProviderReplicate
+	}
+	return model.Provider == provider
+}
+
+// Models returns the available models supporting the operation, or all of them for an empty operation
+func (r *ImageModelRegistry) Models(operation string) []models.ImageModel {
+	result := []models.ImageModel{}
+	for i := range r.models {
+		model := r.models[i]
+		if r.available(&model) && (operation == "" || model.Supports(operation)) {
+			result = append(result, model)
+		}
+	}
+	return result
+}
+
+// Resolve returns the requested model, or the default one for the operation if modelID is empty.
+// Only models of the configured provider can be used.
+func (r *ImageModelRegistry) Resolve(modelID, operation string) (*models.ImageModel, error) {
+	if modelID == "" {
+		for i := range r.models {
+			if r.available(&r.models[i]) && r.models[i].Supports(operation) {
+				return &r.models[i], nil
+			}
+		}
+		return nil, fmt.Errorf("%w: no model supports %s", ErrImageModelNotFound, operation)
+	}
+
+	model, ok := r.byID[modelID]
+	if !ok || !r.available(model) {
+		return nil, fmt.Errorf("%w: %s", ErrImageModelNotFound, modelID)
+	}
+	if !model.Supports(operation) {
+		return nil, fmt.Errorf("image model %s does not support %s", modelID, operation)
+	}
+	return model, nil
+}
+
+// ValidateImageInput checks the input of a prediction against the model's schema and
+// returns it with the defaults filled in and numbers converted to their declared type
+func ValidateImageInput(model *models.ImageModel, input map[string]interface{}) (map[string]interface{}, error) {
+	validated := make(map[string]interface{})
+
+	names := make([]string, 0, len(input))
+	for name := range input {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		param := model.Param(name)
+		if param == nil {
+			return nil, fmt.Errorf("%s does not accept %s", model.ID, name)
+		}
+		if input[name] == nil {
+			continue
+		}
+		value, err := validateImageParam(param, input[name])
+		if err != nil {
+			return nil, err
+		}
+		validated[name] = value
+	}
+
+	for _, param := range model.Params {
+		if _, ok := validated[param.Name]; ok {
+			continue
+		}
+		if param.Default != nil {
+			value, err := validateImageParam(&param, param.Default)
+			if err != nil {
+				return nil, fmt.Errorf("invalid default of %s: %v", model.ID, err)
+			}
+			validated[param.Name] = value
+		} else if param.Required {
+			return nil, fmt.Errorf("%s is required", param.Name)
+		}
+	}
+
+	return validated, nil
+}
+
+func validateImageParam(param *models.ImageModelParam, value interface{}) (interface{}, error) {
+	switch param.Type {
+	case models.ImageParamString, models.ImageParamImage:
+		text, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s must be a string", param.Name)
+		}
+		if param.Required && strings.TrimSpace(text) == "" {
+			return nil, fmt.Errorf("%s is required", param.Name)
+		}
+		if len(param.Options) > 0 {
+			for _, option := range param.Options {
+				if text == option {
+					return text, nil
+				}
+			}
+			return nil, fmt.Errorf("%s must be one of %s", param.Name, strings.Join(param.Options, ", "))
+		}
+		return text, nil
+
+	case models.ImageParamBoolean:
+		flag, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s must be a boolean", param.Name)
+		}
+		return flag, nil
+
+	case models.ImageParamInteger, models.ImageParamNumber:
+		var number float64
+		switch v := value.(type) {
+		case float64:
+			number = v
+		case float32:
+			number = float64(v)
+		case int:
+			number = float64(v)
+		case int64:
+			number = float64(v)
+		default:
+			return nil, fmt.Errorf("%s must be a number", param.Name)
+		}
+		if param.Type == models.ImageParamInteger && number != math.Trunc(number) {
+			return nil, fmt.Errorf("%s must be a whole number", param.Name)
+		}
+		if param.Min != nil && number < *param.Min {
+			return nil, fmt.Errorf("%s must be at least %v", param.Name, *param.Min)
+		}
+		if param.Max != nil && number > *param.Max {
+			return nil, fmt.Errorf("%s must be at most %v", param.Name, *param.Max)
+		}
+		if param.Type == models.ImageParamInteger {
+			return int(number), nil
+		}
+		return number, nil
+	}
+
+	return nil, fmt.Errorf("%s has unknown type %q", param.Name, param.Type)
+}
+
+// DefaultImageModels returns the built-in image models
+func DefaultImageModels() []models.ImageModel {
+	bound := func(value float64) *float64 { return &value }
+	outputFormats := []string{"webp", "jpg", "png"}
+	aspectRatios := []string{"1:1", "16:9", "21:9", "3:2", "2:3", "4:5", "5:4", "3:4", "4:3", "9:16", "9:21"}
+
+	return []models.ImageModel{
+		{
+			ID:          "flux-schnell",
+			Name:        "FLUX.1 [schnell]",
+			Description: "Fast text-to-image model for quick drafts",
+			Model:       "black-forest-labs/flux-schnell",
+			Endpoint:    models.ImageEndpointModel,
+			Operations:  []string{models.ImageOperationGenerate},
+			Inputs:      map[string]string{"steps": "num_inference_steps"},
+			Params: []models.ImageModelParam{
+				{Name: "prompt", Type: models.ImageParamString, Required: true},
+				{Name: "width", Type: models.ImageParamInteger, Default: 512, Min: bound(256), Max: bound(1440)},
+				{Name: "height", Type: models.ImageParamInteger, Default: 512, Min: bound(256), Max: bound(1440)},
+				{Name: "aspect_ratio", Type: models.ImageParamString, Options: aspectRatios},
+				{Name: "num_outputs", Type: models.ImageParamInteger, Default: 1, Min: bound(1), Max: bound(4)},
+				{Name: "num_inference_steps", Type: models.ImageParamInteger, Min: bound(1), Max: bound(4)},
+				{Name: "seed", Type: models.ImageParamInteger, Min: bound(0)},
+				{Name: "output_format", Type: models.ImageParamString, Options: outputFormats},
+			},
+		},
+		{
+			ID:          "flux-dev",
+			Name:        "FLUX.1 [dev]",
+			Description: "Higher quality text-to-image model, slower than schnell",
+			Model:       "black-forest-labs/flux-dev",
+			Endpoint:    models.ImageEndpointModel,
+			Operations:  []string{models.ImageOperationGenerate, models.ImageOperationVariation},
+			Inputs:      map[string]string{"steps": "num_inference_steps", "strength": "prompt_strength"},
+			Params: []models.ImageModelParam{
+				{Name: "prompt", Type: models.ImageParamString, Required: true},
+				{Name: "image", Type: models.ImageParamImage, Description: "Source image for image-to-image"},
+				{Name: "prompt_strength", Type: models.ImageParamNumber, Default: 0.8, Min: bound(0), Max: bound(1), Description: "How far the result may move away from the source image"},
+				{Name: "aspect_ratio", Type: models.ImageParamString, Default: "1:1", Options: aspectRatios},
+				{Name: "num_outputs", Type: models.ImageParamInteger, Default: 1, Min: bound(1), Max: bound(4)},
+				{Name: "num_inference_steps", Type: models.ImageParamInteger, Min: bound(1), Max: bound(50)},
+				{Name: "guidance", Type: models.ImageParamNumber, Min: bound(0), Max: bound(10)},
+				{Name: "seed", Type: models.ImageParamInteger, Min: bound(0)},
+				{Name: "output_format", Type: models.ImageParamString, Options: outputFormats},
+			},
+		},
+		{
+			ID:          "sdxl",
+			Name:        "Stable Diffusion XL",
+			Description: "Text-to-image model with negative prompts and exact sizes",
+			Model:       "stability-ai/sdxl",
+			Version:     "7762fd07cf82c948538e41f63f77d685e02b063e37e496e96eefd46c929f9bdc",
+			Endpoint:    models.ImageEndpointVersion,
+			Operations:  []string{models.ImageOperationGenerate, models.ImageOperationVariation},
+			Inputs:      map[string]string{"steps": "num_inference_steps", "guidance": "guidance_scale", "strength": "prompt_strength"},
+			Params: []models.ImageModelParam{
+				{Name: "prompt", Type: models.ImageParamString, Required: true},
+				{Name: "negative_prompt", Type: models.ImageParamString},
+				{Name: "image", Type: models.ImageParamImage, Description: "Source image for image-to-image"},
+				{Name: "prompt_strength", Type: models.ImageParamNumber, Default: 0.8, Min: bound(0), Max: bound(1), Description: "How far the result may move away from the source image"},
+				{Name: "width", Type: models.ImageParamInteger, Default: 1024, Min: bound(128), Max: bound(1536)},
+				{Name: "height", Type: models.ImageParamInteger, Default: 1024, Min: bound(128), Max: bound(1536)},
+				{Name: "num_outputs", Type: models.ImageParamInteger, Default: 1, Min: bound(1), Max: bound(4)},
+				{Name: "num_inference_steps", Type: models.ImageParamInteger, Min: bound(1), Max: bound(500)},
+				{Name: "guidance_scale", Type: models.ImageParamNumber, Min: bound(1), Max: bound(50)},
+				{Name: "seed", Type: models.ImageParamInteger, Min: bound(0)},
+			},
+		},
+		{
+			ID:          "flux-dev-inpainting",
+			Name:        "FLUX.1 [dev] inpainting",
+			Description: "Repaints the masked area of an image",
+			Model:       "zsxkib/flux-dev-inpainting",
+			Version:     "11cca3274341de7aef06f04e4dab3d651ea8ac04eff003f23603d4fdf5b56ff0",
+			Endpoint:    models.ImageEndpointVersion,
+			Operations:  []string{models.ImageOperationInpaint},
+			Inputs:      map[string]string{"steps": "num_inference_steps", "guidance": "guidance_scale"},
+			Params: []models.ImageModelParam{
+				{Name: "prompt", Type: models.ImageParamString, Required: true},
+				{Name: "image", Type: models.ImageParamImage, Required: true},
+				{Name: "mask", Type: models.ImageParamImage, Required: true},
+				{Name: "num_outputs", Type: models.ImageParamInteger, Default: 1, Min: bound(1), Max: bound(4)},
+				{Name: "strength", Type: models.ImageParamNumber, Min: bound(0), Max: bound(1)},
+				{Name: "num_inference_steps", Type: models.ImageParamInteger, Min: bound(1), Max: bound(50)},
+				{Name: "guidance_scale", Type: models.ImageParamNumber, Min: bound(0), Max: bound(10)},
+				{Name: "seed", Type: models.ImageParamInteger, Min: bound(0)},
+				{Name: "output_format", Type: models.ImageParamString, Options: outputFormats},
+			},
+		},
+		{
+			ID:          "real-esrgan",
+			Name:        "Real-ESRGAN",
+			Description: "Upscales images while restoring detail",
+			Model:       "nightmareai/real-esrgan",
+			Version:     "f121d640bd286e1fdc67f9799164c1d5be36ff74576ee11c803ae5b665dd46aa",
+			Endpoint:    models.ImageEndpointVersion,
+			Operations:  []string{models.ImageOperationUpscale},
+			Params: []models.ImageModelParam{
+				{Name: "image", Type: models.ImageParamImage, Required: true},
+				{Name: "scale", Type: models.ImageParamInteger, Default: 2, Min: bound(1), Max: bound(10)},
+				{Name: "face_enhance", Type: models.ImageParamBoolean},
+			},
+		},
+		{
+			ID:          "rembg",
+			Name:        "Background removal",
+			Description: "Removes the background and returns a transparent PNG",
+			Model:       "cjwbw/rembg",
+			Version:     "fb8af171cfa1616ddcf1242c093f9c46bcada5ad4cf6f2fbe8b81b330ec5c003",
+			Endpoint:    models.ImageEndpointVersion,
+			Operations:  []string{models.ImageOperationRemoveBackground},
+			Params: []models.ImageModelParam{
+				{Name: "image", Type: models.ImageParamImage, Required: true},
+			},
+		},
+		{
+			ID:          "sd-webui",
+			Name:        "Stable Diffusion (self-hosted)",
+			Description: "The checkpoint loaded in the Stable Diffusion web UI",
+			Provider:    ImageProviderStableDiffusion,
+			Operations:  []string{models.ImageOperationGenerate, models.ImageOperationVariation, models.ImageOperationInpaint},
+			Inputs:      map[string]string{"num_outputs": "batch_size", "guidance": "cfg_scale", "strength": "denoising_strength"},
+			Params: []models.ImageModelParam{
+				{Name: "prompt", Type: models.ImageParamString, Required: true},
+				{Name: "negative_prompt", Type: models.ImageParamString},
+				{Name: "width", Type: models.ImageParamInteger, Default: 512, Min: bound(64), Max: bound(2048)},
+				{Name: "height", Type: models.ImageParamInteger, Default: 512, Min: bound(64), Max: bound(2048)},
+				{Name: "batch_size", Type: models.ImageParamInteger, Default: 1, Min: bound(1), Max: bound(8)},
+				{Name: "steps", Type: models.ImageParamInteger, Default: 20, Min: bound(1), Max: bound(150)},
+				{Name: "cfg_scale", Type: models.ImageParamNumber, Default: 7, Min: bound(1), Max: bound(30)},
+				{Name: "seed", Type: models.ImageParamInteger, Min: bound(-1)},
+				{Name: "sampler_name", Type: models.ImageParamString},
+				{Name: "image", Type: models.ImageParamImage, Description: "Source image for image-to-image and inpainting"},
+				{Name: "mask", Type: models.ImageParamImage, Description: "Area to repaint when inpainting"},
+				{Name: "denoising_strength", Type: models.ImageParamNumber, Min: bound(0), Max: bound(1)},
+			},
+		},
+		{
+			ID:          "sd-webui-upscale",
+			Name:        "Stable Diffusion upscaler (self-hosted)",
+			Description: "Upscales images with the web UI's upscaler",
+			Provider:    ImageProviderStableDiffusion,
+			Operations:  []string{models.ImageOperationUpscale},
+			Inputs:      map[string]string{"scale": "upscaling_resize"},
+			Params: []models.ImageModelParam{
+				{Name: "image", Type: models.ImageParamImage, Required: true},
+				{Name: "upscaling_resize", Type: models.ImageParamInteger, Default: 2, Min: bound(1), Max: bound(8)},
+				{Name: "upscaler_1", Type: models.ImageParamString, Default: "R-ESRGAN 4x+"},
+			},
+		},
+	}
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"backend/interfaces"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Image providers that can be selected with IMAGE_PROVIDER
+const (
+	ImageProviderReplicate       = "replicate"        // Replicate's hosted models, the default
+	ImageProviderStableDiffusion = "stable-diffusion" // A self-hosted server with the Stable Diffusion web UI API
+	ImageProviderMock            = "mock"             // Offline placeholder images for development and CI
+)
+
+// ErrNoImageOutput is returned when a provider reports success without any image
+var ErrNoImageOutput = errors.New("the model returned no images")
+
+// RequireImageOutput turns a successful run without outputs into ErrNoImageOutput, so callers
+// can rely on at least one output
+func RequireImageOutput(outputs []string, err error) ([]string, error) {
+	if err == nil && len(outputs) == 0 {
+		return nil, ErrNoImageOutput
+	}
+	return outputs, err
+}
+
+var (
+	imageProviderName     string
+	imageProviderNameOnce sync.Once
+)
+
+// ImageProviderName returns the configured image provider. The Stable Diffusion provider
+// reads the server URL from STABLE_DIFFUSION_API_URL.
+func ImageProviderName() string {
+	imageProviderNameOnce.Do(func() {
+		name := strings.ToLower(strings.TrimSpace(os.Getenv("IMAGE_PROVIDER")))
+		switch name {
+		case "":
+			name = ImageProviderReplicate
+		case ImageProviderReplicate, ImageProviderStableDiffusion, ImageProviderMock:
+		default:
+			log.Printf("Warning: unknown IMAGE_PROVIDER %q, using %s", name, ImageProviderReplicate)
+			name = ImageProviderReplicate
+		}
+		imageProviderName = name
+		log.Printf("Image provider: %s", imageProviderName)
+	})
+	return imageProviderName
+}
+
+// ImageProviderUsesReplicate reports whether images are generated on Replicate,
+// which needs the user's API key
+func ImageProviderUsesReplicate() bool {
+	return ImageProviderName() == ImageProviderReplicate
+}
+
+// NewImageProvider creates the configured image provider. The API key is only used by
+// Replicate; without one the server's REPLICATE_API_KEY is used.
+func NewImageProvider(replicateAPIKey string) interfaces.ImageProvider {
+	switch ImageProviderName() {
+	case ImageProviderStableDiffusion:
+		return NewStableDiffusionService(os.Getenv("STABLE_DIFFUSION_API_URL"))
+	case ImageProviderMock:
+		return NewMockImageService()
+	}
+
+	if replicateAPIKey == "" {
+		return NewReplicateService()
+	}
+	return NewReplicateServiceWithKey(replicateAPIKey)
+}
+
+// runDefaultImageModel runs the provider's default model of an operation, with the standard
+// inputs renamed to the model's schema, and returns the first output
+func runDefaultImageModel(provider interfaces.ImageProvider, operation string, inputs map[string]interface{}) (string, error) {
+	model, err := GetImageModelRegistry().Resolve("", operation)
+	if err != nil {
+		return "", err
+	}
+
+	input := make(map[string]interface{})
+	for name, value := range inputs {
+		input[model.InputName(name)] = value
+	}
+	input, err = ValidateImageInput(model, input)
+	if err != nil {
+		return "", err
+	}
+
+	outputs, err := RequireImageOutput(provider.RunModel(model, input))
+	if err != nil {
+		return "", err
+	}
+	return outputs[0], nil
+}
@@ -15,6 +15,21 @@ type JobManager struct {
 	mu   sync.RWMutex
 }
 
+var (
+	jobManager     *JobManager
+	jobManagerOnce sync.Once
+)
+
+// GetJobManager returns the job manager shared by the image and chat controllers.
+// Jobs older than a day are cleaned up every hour.
+func GetJobManager() *JobManager {
+	jobManagerOnce.Do(func() {
+		jobManager = NewJobManager()
+		jobManager.StartCleanupRoutine(time.Hour, 24*time.Hour)
+	})
+	return jobManager
+}
+
 // NewJobManager creates a new job manager
 func NewJobManager() *JobManager {
 	return &JobManager{
@@ -67,8 +82,14 @@ func (m *JobManager) UpdateJobStatus(jobID string, status models.JobStatus) bool
 	return true
 }
 
-// CompleteJob marks a job as completed with a result
-func (m *JobManager) CompleteJob(jobID string, result *models.Image) bool {
+// CompleteJob marks a job as completed with its resulting images. A job without
+// images is failed instead.
+func (m *JobManager) CompleteJob(jobID string, results []models.Image) bool {
+	if len(results) == 0 {
+		m.FailJob(jobID, ErrNoImageOutput.Error())
+		return false
+	}
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -78,9 +99,10 @@ func (m *JobManager) CompleteJob(jobID string, result *models.Image) bool {
 	}
 
 	job.Status = models.JobStatusCompleted
-	job.Result = result
+	job.Result = &results[0]
+	job.Results = results
 	job.UpdatedAt = time.Now().Unix()
-	log.Printf("Completed job %s with result %s", jobID, result.URL)
+	log.Printf("Completed job %s with result %s", jobID, job.Result.URL)
 	return true
 }
 
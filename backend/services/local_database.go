@@ -10,6 +10,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 )
@@ -48,7 +49,17 @@ func NewLocalDatabase() interfaces.DatabaseService {
 func (db *LocalDatabase) saveToFile(filePath string, data interface{}) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	
+	return writeJSONFile(filePath, data)
+}
+
+func (db *LocalDatabase) loadFromFile(filePath string, data interface{}) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return readJSONFile(filePath, data)
+}
+
+// writeJSONFile writes data as JSON; callers must hold db.mu
+func writeJSONFile(filePath string, data interface{}) error {
 	jsonData, err := json.MarshalIndent(data, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal data: %v", err)
@@ -63,12 +74,10 @@ func (db *LocalDatabase) saveToFile(filePath string, data interface{}) error {
 	return ioutil.WriteFile(filePath, jsonData, 0644)
 }
 
-func (db *LocalDatabase) loadFromFile(filePath string, data interface{}) error {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
-	
+// readJSONFile reads JSON into data; callers must hold db.mu
+func readJSONFile(filePath string, data interface{}) error {
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		return fmt.Errorf("file not found: %s", filePath)
+		return fmt.Errorf("file not found: %s: %w", filePath, os.ErrNotExist)
 	}
 	
 	jsonData, err := ioutil.ReadFile(filePath)
@@ -169,6 +178,32 @@ func (db *LocalDatabase) GetPublicAvatars(ctx context.Context) ([]*models.Avatar
 	return avatars, nil
 }
 
+// SearchPublicAvatars returns a page of public avatars, filtering and sorting them in memory
+func (db *LocalDatabase) SearchPublicAvatars(ctx context.Context, query models.AvatarQuery) (*models.AvatarPage, error) {
+	cursor, err := decodeAvatarCursor(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	
+	public, err := db.GetPublicAvatars(ctx)
+	if err != nil {
+		return nil, err
+	}
+	
+	var avatars []*models.Avatar
+	for _, avatar := range public {
+		if MatchesAvatarQuery(avatar, query) && (cursor == nil || avatarAfter(avatar, *cursor, query.Sort)) {
+			avatars = append(avatars, avatar)
+		}
+	}
+	SortAvatars(avatars, query.Sort)
+	
+	if len(avatars) > query.Limit+1 {
+		avatars = avatars[:query.Limit+1]
+	}
+	return avatarPage(avatars, query), nil
+}
+
 func (db *LocalDatabase) SaveAvatar(ctx context.Context, avatar *models.Avatar) error {
 	filePath := filepath.Join(db.dataDir, "avatars", fmt.Sprintf("%s.json", avatar.ID))
 	return db.saveToFile(filePath, avatar)
@@ -176,16 +211,108 @@ func (db *LocalDatabase) SaveAvatar(ctx context.Context, avatar *models.Avatar)
 
 func (db *LocalDatabase) UpdateAvatar(ctx context.Context, avatar *models.Avatar) error {
 	avatar.UpdatedAt = time.Now().Unix()
-	return db.SaveAvatar(ctx, avatar)
+	filePath := filepath.Join(db.dataDir, "avatars", fmt.Sprintf("%s.json", avatar.ID))
+	
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	
+	// Counters are only changed through UpdateAvatarStats, so the stored ones are kept
+	var stored models.Avatar
+	if err := readJSONFile(filePath, &stored); err != nil {
+		return err
+	}
+	avatar.AvatarStats = stored.AvatarStats
+	return writeJSONFile(filePath, avatar)
 }
 
 func (db *LocalDatabase) DeleteAvatar(ctx context.Context, avatarID string) error {
 	filePath := filepath.Join(db.dataDir, "avatars", fmt.Sprintf("%s.json", avatarID))
 	db.mu.Lock()
 	defer db.mu.Unlock()
+	if err := os.RemoveAll(filepath.Join(db.dataDir, "avatar_versions", avatarID)); err != nil {
+		return err
+	}
 	return os.Remove(filePath)
 }
 
+// UpdateAvatarStats changes an avatar's counters, reading and writing under one lock
+// so concurrent updates aren't lost
+func (db *LocalDatabase) UpdateAvatarStats(ctx context.Context, avatarID string, update func(stats *models.AvatarStats)) error {
+	filePath := filepath.Join(db.dataDir, "avatars", fmt.Sprintf("%s.json", avatarID))
+	
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	
+	var avatar models.Avatar
+	if err := readJSONFile(filePath, &avatar); err != nil {
+		return err
+	}
+	update(&avatar.AvatarStats)
+	return writeJSONFile(filePath, &avatar)
+}
+
+// Avatar version operations
+func (db *LocalDatabase) GetAvatarVersions(ctx context.Context, avatarID string) ([]*models.AvatarVersion, error) {
+	dir := filepath.Join(db.dataDir, "avatar_versions", avatarID)
+	files, err := db.listFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	
+	var versions []*models.AvatarVersion
+	for _, file := range files {
+		filePath := filepath.Join(dir, file)
+		var version models.AvatarVersion
+		if err := db.loadFromFile(filePath, &version); err != nil {
+			log.Printf("Warning: Failed to load avatar version file %s: %v", file, err)
+			continue
+		}
+		versions = append(versions, &version)
+	}
+	
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version < versions[j].Version
+	})
+	return versions, nil
+}
+
+func (db *LocalDatabase) GetAvatarVersion(ctx context.Context, avatarID string, version int) (*models.AvatarVersion, error) {
+	filePath := filepath.Join(db.dataDir, "avatar_versions", avatarID, fmt.Sprintf("%d.json", version))
+	var avatarVersion models.AvatarVersion
+	if err := db.loadFromFile(filePath, &avatarVersion); err != nil {
+		return nil, err
+	}
+	return &avatarVersion, nil
+}
+
+func (db *LocalDatabase) SaveAvatarVersion(ctx context.Context, version *models.AvatarVersion) error {
+	filePath := filepath.Join(db.dataDir, "avatar_versions", version.AvatarID, fmt.Sprintf("%d.json", version.Version))
+	jsonData, err := json.MarshalIndent(version, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal data: %v", err)
+	}
+	
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+	
+	// Versions are immutable, so never overwrite an existing one
+	file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return ErrAvatarVersionExists
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(jsonData); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
 // Chat operations
 func (db *LocalDatabase) GetChat(ctx context.Context, chatID string) (*models.Chat, error) {
 	filePath := filepath.Join(db.dataDir, "chats", fmt.Sprintf("%s.json", chatID))
@@ -279,6 +406,48 @@ func (db *LocalDatabase) DeleteImage(ctx context.Context, imageID string) error
 	return os.Remove(filePath)
 }
 
+// Moderation operations
+func (db *LocalDatabase) GetModerationCase(ctx context.Context, caseID string) (*models.ModerationCase, error) {
+	filePath := filepath.Join(db.dataDir, "moderation", fmt.Sprintf("%s.json", caseID))
+	var moderationCase models.ModerationCase
+	if err := db.loadFromFile(filePath, &moderationCase); err != nil {
+		return nil, err
+	}
+	return &moderationCase, nil
+}
+
+// GetModerationCases returns the cases with the given status, or all cases for an empty status, oldest first
+func (db *LocalDatabase) GetModerationCases(ctx context.Context, status string) ([]*models.ModerationCase, error) {
+	dir := filepath.Join(db.dataDir, "moderation")
+	files, err := db.listFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	
+	var cases []*models.ModerationCase
+	for _, file := range files {
+		filePath := filepath.Join(dir, file)
+		var moderationCase models.ModerationCase
+		if err := db.loadFromFile(filePath, &moderationCase); err != nil {
+			log.Printf("Warning: Failed to load moderation case file %s: %v", file, err)
+			continue
+		}
+		if status == "" || moderationCase.Status == status {
+			cases = append(cases, &moderationCase)
+		}
+	}
+	
+	sort.Slice(cases, func(i, j int) bool {
+		return cases[i].CreatedAt < cases[j].CreatedAt
+	})
+	return cases, nil
+}
+
+func (db *LocalDatabase) SaveModerationCase(ctx context.Context, moderationCase *models.ModerationCase) error {
+	filePath := filepath.Join(db.dataDir, "moderation", fmt.Sprintf("%s.json", moderationCase.ID))
+	return db.saveToFile(filePath, moderationCase)
+}
+
 // Settings operations
 func (db *LocalDatabase) GetUserSetting(ctx context.Context, userID, settingKey string) (map[string]interface{}, error) {
 	filePath := filepath.Join(db.dataDir, "settings", fmt.Sprintf("%s_%s.json", userID, settingKey))
@@ -167,4 +167,24 @@ func (s *LocalStorage) DeleteFile(filePath string) error {
 	
 	log.Printf("Local storage: File %s deleted successfully", filePath)
 	return nil
-} 
\ No newline at end of file
+}
+
+// DataURI returns a file stored by this service as a base64 data URI. Other URLs are
+// returned unchanged.
+func (s *LocalStorage) DataURI(url string) (string, error) {
+	if !strings.HasPrefix(url, s.baseURL+"/") {
+		return url, nil
+	}
+
+	relativePath := filepath.Clean(filepath.FromSlash(strings.TrimPrefix(url, s.baseURL+"/")))
+	if filepath.IsAbs(relativePath) || strings.HasPrefix(relativePath, "..") {
+		return "", fmt.Errorf("invalid storage path: %s", relativePath)
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.baseDir, relativePath))
+	if err != nil {
+		return "", fmt.Errorf("failed to read file: %v", err)
+	}
+
+	return fmt.Sprintf("data:%s;base64,%s", http.DetectContentType(data), base64.StdEncoding.EncodeToString(data)), nil
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"backend/models"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// defaultLorebookTokenBudget is the number of tokens all injected entries may use together
+	defaultLorebookTokenBudget = 1024
+
+	// LorebookScanDepth is the number of recent messages scanned for lorebook keywords
+	LorebookScanDepth = 6
+
+	// maxCachedWordPatterns caps the compiled keyword patterns kept between messages
+	maxCachedWordPatterns = 4096
+)
+
+var (
+	wordPatterns   = make(map[string]*regexp.Regexp)
+	wordPatternsMu sync.Mutex
+)
+
+// LorebookTokenBudget returns the total token budget for injected lorebook entries,
+// configured through LOREBOOK_TOKEN_BUDGET
+func LorebookTokenBudget() int {
+	if value := os.Getenv("LOREBOOK_TOKEN_BUDGET"); value != "" {
+		if budget, err := strconv.Atoi(value); err == nil && budget > 0 {
+			return budget
+		}
+		log.Printf("Warning: invalid LOREBOOK_TOKEN_BUDGET %q", value)
+	}
+	return defaultLorebookTokenBudget
+}
+
+// EstimateTokens gives a rough token count for text, assuming about four characters per token
+func EstimateTokens(text string) int {
+	return (len([]rune(text)) + 3) / 4
+}
+
+// SelectLoreEntries returns the enabled entries whose keywords appear in the text, highest priority
+// first, keeping within the total token budget. Entries longer than their own budget are shortened.
+// At equal priority, entries of earlier lorebooks win.
+func SelectLoreEntries(text string, budget int, lorebooks ...models.Lorebook) []models.LorebookEntry {
+	var triggered []models.LorebookEntry
+	seen := make(map[string]bool)
+	for _, lorebook := range lorebooks {
+		for _, entry := range lorebook {
+			if !entry.Enabled || seen[entry.ID] || !matchesAnyKeyword(text, entry.Keywords) {
+				continue
+			}
+			seen[entry.ID] = true
+			if entry.TokenBudget > 0 {
+				entry.Content = truncateToTokens(entry.Content, entry.TokenBudget)
+			}
+			triggered = append(triggered, entry)
+		}
+	}
+
+	sort.SliceStable(triggered, func(i, j int) bool {
+		return triggered[i].Priority > triggered[j].Priority
+	})
+
+	// Skip entries that don't fit, a smaller one further down may still fit
+	selected := []models.LorebookEntry{}
+	used := 0
+	for _, entry := range triggered {
+		tokens := EstimateTokens(entry.Content)
+		if used+tokens > budget {
+			continue
+		}
+		used += tokens
+		selected = append(selected, entry)
+	}
+	return selected
+}
+
+// matchesAnyKeyword reports whether any keyword appears in the text as a whole word, ignoring case
+func matchesAnyKeyword(text string, keywords []string) bool {
+	for _, keyword := range keywords {
+		if strings.TrimSpace(keyword) == "" {
+			continue
+		}
+		if wordPattern(keyword).MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
+
+// wordPattern matches a word or phrase case-insensitively when it is not part of a longer word.
+// Patterns are cached, since the same keywords are matched against every message.
+func wordPattern(word string) *regexp.Regexp {
+	word = strings.TrimSpace(word)
+
+	wordPatternsMu.Lock()
+	defer wordPatternsMu.Unlock()
+
+	if pattern, ok := wordPatterns[word]; ok {
+		return pattern
+	}
+	if len(wordPatterns) >= maxCachedWordPatterns {
+		wordPatterns = make(map[string]*regexp.Regexp)
+	}
+
+	pattern := regexp.MustCompile(`(?i)(^|[^\p{L}\p{N}])` + regexp.QuoteMeta(word) + `($|[^\p{L}\p{N}])`)
+	wordPatterns[word] = pattern
+	return pattern
+}
+
+// truncateToTokens shortens text to roughly the given number of tokens, cutting at a word boundary
+func truncateToTokens(text string, tokens int) string {
+	runes := []rune(text)
+	if len(runes) <= tokens*4 {
+		return text
+	}
+
+	cut := string(runes[:tokens*4])
+	if i := strings.LastIndexAny(cut, " \n\t"); i > 0 {
+		cut = cut[:i]
+	}
+	return strings.TrimSpace(cut) + "..."
+}
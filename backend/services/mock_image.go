@@ -1,60 +1,117 @@
 package services
 
 import (
+	"backend/models"
+	"bytes"
+	"encoding/base64"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/png"
 	"log"
-	"math/rand"
-	"time"
 )
 
-// MockImageService provides a mock implementation for image generation
-// This is useful for testing when the Replicate API is not available
-type MockImageService struct {
-	// Placeholder URLs for testing
-	mockImageURLs []string
-	random        *rand.Rand
-}
+const (
+	mockImageSize    = 512
+	mockImageMaxSize = 1024
+)
+
+// MockImageService generates placeholder PNG images locally, without network access.
+// Images are returned as data URIs and are deterministic for a given prompt, so it can
+// be used in development and CI (IMAGE_PROVIDER=mock).
+type MockImageService struct{}
 
 // NewMockImageService creates a new mock image service
 func NewMockImageService() *MockImageService {
-	// Create a properly seeded random number generator
-	source := rand.NewSource(time.Now().UnixNano())
-	rng := rand.New(source)
-	
-	return &MockImageService{
-		mockImageURLs: []string{
-			"https://images.unsplash.com/photo-1698778573682-346d219402b5?q=80&w=512&h=512&auto=format&fit=crop",
-			"https://images.unsplash.com/photo-1682687982167-d7fb3ed8541d?q=80&w=512&h=512&auto=format&fit=crop",
-			"https://images.unsplash.com/photo-1682687982093-4ca1a2bd9ae8?q=80&w=512&h=512&auto=format&fit=crop",
-			"https://images.unsplash.com/photo-1682687982183-c2937a74257c?q=80&w=512&h=512&auto=format&fit=crop",
-		},
-		random: rng,
-	}
+	return &MockImageService{}
 }
 
-// GenerateImage returns a mock image URL
+// GenerateImage returns a mock image for the prompt
 func (s *MockImageService) GenerateImage(prompt string) (string, error) {
 	log.Printf("Mock generating image with prompt: %s", prompt)
-	
-	// Simulate processing time
-	time.Sleep(2 * time.Second)
-	
-	// Return a random mock image URL
-	randomIndex := s.random.Intn(len(s.mockImageURLs))
-	
-	log.Printf("Mock image generated: %s", s.mockImageURLs[randomIndex])
-	return s.mockImageURLs[randomIndex], nil
-}
-
-// InpaintImage returns a mock inpainted image URL
+	return mockImage(prompt, 0, mockImageSize, mockImageSize)
+}
+
+// InpaintImage returns a mock inpainted image
 func (s *MockImageService) InpaintImage(imageURL, prompt, maskURL string) (string, error) {
 	log.Printf("Mock inpainting image with prompt: %s", prompt)
-	
-	// Simulate processing time
-	time.Sleep(2 * time.Second)
-	
-	// Return a random mock image URL
-	randomIndex := s.random.Intn(len(s.mockImageURLs))
-	
-	log.Printf("Mock inpainted image: %s", s.mockImageURLs[randomIndex])
-	return s.mockImageURLs[randomIndex], nil
-} 
\ No newline at end of file
+	return mockImage(prompt+imageURL, 0, mockImageSize, mockImageSize)
+}
+
+// RunModelAsync runs RunModel in the background
+func (s *MockImageService) RunModelAsync(model *models.ImageModel, input map[string]interface{}, done func([]string, error)) {
+	go func() {
+		done(s.RunModel(model, input))
+	}()
+}
+
+// RunModel returns one mock image per requested output for any model, sized by the
+// width and height inputs
+func (s *MockImageService) RunModel(model *models.ImageModel, input map[string]interface{}) ([]string, error) {
+	log.Printf("Mock running image model %s", model.ID)
+
+	count := 1
+	if outputs, ok := input[model.InputName("num_outputs")].(int); ok && outputs > 1 {
+		count = outputs
+	}
+	width := mockDimension(input[model.InputName("width")])
+	height := mockDimension(input[model.InputName("height")])
+	prompt, _ := input["prompt"].(string)
+
+	urls := make([]string, count)
+	for i := range urls {
+		url, err := mockImage(model.ID+prompt, i, width, height)
+		if err != nil {
+			return nil, err
+		}
+		urls[i] = url
+	}
+	return urls, nil
+}
+
+// mockDimension returns a requested image dimension, capped to keep mock images small
+func mockDimension(value interface{}) int {
+	size, ok := value.(int)
+	if !ok || size <= 0 {
+		return mockImageSize
+	}
+	if size > mockImageMaxSize {
+		return mockImageMaxSize
+	}
+	return size
+}
+
+// mockImage draws a gradient whose colors are derived from the seed and returns it
+// as a PNG data URI
+func mockImage(seed string, index, width, height int) (string, error) {
+	hash := fnv.New32a()
+	hash.Write([]byte(seed))
+	sum := hash.Sum32() + uint32(index)*0x9e3779b9
+
+	from := color.RGBA{R: uint8(sum), G: uint8(sum >> 8), B: uint8(sum >> 16), A: 255}
+	to := color.RGBA{R: ^from.B, G: ^from.R, B: ^from.G, A: 255}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			t := (x + y) * 255 / (width + height)
+			img.Set(x, y, color.RGBA{
+				R: blend(from.R, to.R, t),
+				G: blend(from.G, to.G, t),
+				B: blend(from.B, to.B, t),
+				A: 255,
+			})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+	return pngDataURI(base64.StdEncoding.EncodeToString(buf.Bytes())), nil
+}
+
+// blend mixes two color channels, t ranging from 0 (a) to 255 (b)
+func blend(a, b uint8, t int) uint8 {
+	return uint8((int(a)*(255-t) + int(b)*t) / 255)
+}
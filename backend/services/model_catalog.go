@@ -0,0 +1,188 @@
+package services
+
+import (
+	"backend/models"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultModelCatalogTTL = time.Hour
+
+var (
+	modelCatalog     *ModelCatalog
+	modelCatalogOnce sync.Once
+)
+
+// ModelFilter narrows down the models returned by the catalog.
+// Zero values mean "no restriction".
+type ModelFilter struct {
+	Query              string   // Free text matched against ID, name and description
+	Modality           string   // Substring of the modality, e.g. "image" or "text->text"
+	MinContext         int      // Minimum context length in tokens
+	MaxPromptPrice     *float64 // Maximum price per prompt token
+	MaxCompletionPrice *float64 // Maximum price per completion token
+	FreeOnly           bool
+}
+
+// ModelCatalog caches the OpenRouter model list and refreshes it in the background
+type ModelCatalog struct {
+	mu        sync.RWMutex
+	models    []models.OpenRouterModel
+	byID      map[string]models.OpenRouterModel
+	fetchedAt time.Time
+	ttl       time.Duration
+}
+
+// NewModelCatalog creates a new model catalog with the given TTL
+func NewModelCatalog(ttl time.Duration) *ModelCatalog {
+	return &ModelCatalog{
+		byID: make(map[string]models.OpenRouterModel),
+		ttl:  ttl,
+	}
+}
+
+// GetModelCatalog returns the shared model catalog, starting its refresh routine on first use.
+// The TTL can be configured with MODEL_CATALOG_TTL (e.g. "30m").
+func GetModelCatalog() *ModelCatalog {
+	modelCatalogOnce.Do(func() {
+		ttl := defaultModelCatalogTTL
+		if value := os.Getenv("MODEL_CATALOG_TTL"); value != "" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil || parsed <= 0 {
+				log.Printf("Warning: invalid MODEL_CATALOG_TTL %q, using %v", value, defaultModelCatalogTTL)
+			} else {
+				ttl = parsed
+			}
+		}
+
+		modelCatalog = NewModelCatalog(ttl)
+		modelCatalog.StartRefreshRoutine()
+	})
+	return modelCatalog
+}
+
+// Models returns all cached models, fetching them first if the cache is empty or stale.
+// If a refresh fails but stale data is available, the stale data is returned.
+func (mc *ModelCatalog) Models() ([]models.OpenRouterModel, error) {
+	mc.mu.RLock()
+	fresh := len(mc.models) > 0 && time.Since(mc.fetchedAt) < mc.ttl
+	mc.mu.RUnlock()
+
+	if !fresh {
+		if err := mc.Refresh(); err != nil {
+			mc.mu.RLock()
+			defer mc.mu.RUnlock()
+			if len(mc.models) == 0 {
+				return nil, err
+			}
+			log.Printf("Serving stale model catalog: %v", err)
+			return mc.models, nil
+		}
+	}
+
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+	return mc.models, nil
+}
+
+// Get returns a single model by ID
+func (mc *ModelCatalog) Get(modelID string) (*models.OpenRouterModel, error) {
+	if _, err := mc.Models(); err != nil {
+		return nil, err
+	}
+
+	mc.mu.RLock()
+	model, found := mc.byID[modelID]
+	mc.mu.RUnlock()
+
+	if !found {
+		return nil, fmt.Errorf("model %s not found", modelID)
+	}
+	return &model, nil
+}
+
+// Search returns the models matching the filter
+func (mc *ModelCatalog) Search(filter ModelFilter) ([]models.OpenRouterModel, error) {
+	all, err := mc.Models()
+	if err != nil {
+		return nil, err
+	}
+
+	query := strings.ToLower(strings.TrimSpace(filter.Query))
+	modality := strings.ToLower(filter.Modality)
+
+	result := []models.OpenRouterModel{}
+	for _, m := range all {
+		if query != "" &&
+			!strings.Contains(strings.ToLower(m.ID), query) &&
+			!strings.Contains(strings.ToLower(m.Name), query) &&
+			!strings.Contains(strings.ToLower(m.Description), query) {
+			continue
+		}
+		if modality != "" && !strings.Contains(strings.ToLower(m.Modality), modality) {
+			continue
+		}
+		if filter.MinContext > 0 && m.Context < filter.MinContext {
+			continue
+		}
+		if filter.MaxPromptPrice != nil && m.PricePerToken.Prompt > *filter.MaxPromptPrice {
+			continue
+		}
+		if filter.MaxCompletionPrice != nil && m.PricePerToken.Completion > *filter.MaxCompletionPrice {
+			continue
+		}
+		if filter.FreeOnly && !m.IsFree() {
+			continue
+		}
+		result = append(result, m)
+	}
+
+	return result, nil
+}
+
+// Refresh fetches the model list from OpenRouter and replaces the cached copy. The models
+// endpoint is public, so no user's API key is needed.
+func (mc *ModelCatalog) Refresh() error {
+	list, err := NewOpenRouterService("").GetModels()
+	if err != nil {
+		return fmt.Errorf("failed to refresh model catalog: %v", err)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	byID := make(map[string]models.OpenRouterModel, len(list))
+	for _, m := range list {
+		byID[m.ID] = m
+	}
+
+	mc.mu.Lock()
+	mc.models = list
+	mc.byID = byID
+	mc.fetchedAt = time.Now()
+	mc.mu.Unlock()
+
+	log.Printf("Model catalog refreshed with %d models", len(list))
+	return nil
+}
+
+// StartRefreshRoutine periodically refreshes the catalog
+func (mc *ModelCatalog) StartRefreshRoutine() {
+	go func() {
+		ticker := time.NewTicker(mc.ttl)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := mc.Refresh(); err != nil {
+				log.Printf("Error refreshing model catalog: %v", err)
+			}
+		}
+	}()
+	log.Printf("Started model catalog refresh routine (interval: %v)", mc.ttl)
+}
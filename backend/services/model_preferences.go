@@ -0,0 +1,161 @@
+package services
+
+import (
+	"backend/interfaces"
+	"backend/models"
+	"context"
+	"fmt"
+	"sync"
+)
+
+// ModelPreferencesSettingKey is the user setting that holds the model preferences
+const ModelPreferencesSettingKey = "model_preferences"
+
+// ModelPreferencesService stores users' default, favorite and per-avatar models
+type ModelPreferencesService struct {
+	db interfaces.DatabaseService
+	mu sync.Mutex
+}
+
+var (
+	modelPreferencesService     *ModelPreferencesService
+	modelPreferencesServiceOnce sync.Once
+)
+
+// GetModelPreferencesService returns the shared model preferences service. Preferences are
+// changed under its lock, so every controller has to use this instance.
+func GetModelPreferencesService() *ModelPreferencesService {
+	modelPreferencesServiceOnce.Do(func() {
+		modelPreferencesService = NewModelPreferencesService(GetDatabaseService())
+	})
+	return modelPreferencesService
+}
+
+// NewModelPreferencesService creates a new model preferences service
+func NewModelPreferencesService(db interfaces.DatabaseService) *ModelPreferencesService {
+	return &ModelPreferencesService{
+		db: db,
+	}
+}
+
+// Get returns the user's model preferences, or empty preferences if none are stored
+func (s *ModelPreferencesService) Get(ctx context.Context, userID string) (*models.ModelPreferences, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load(ctx, userID)
+}
+
+// Save stores the user's model preferences
+func (s *ModelPreferencesService) Save(ctx context.Context, userID string, prefs *models.ModelPreferences) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.save(ctx, userID, prefs)
+}
+
+// AddFavorite adds a model to the user's favorites, keeping the list free of duplicates
+func (s *ModelPreferencesService) AddFavorite(ctx context.Context, userID, modelID string) (*models.ModelPreferences, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prefs, err := s.load(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, favorite := range prefs.Favorites {
+		if favorite == modelID {
+			return prefs, nil
+		}
+	}
+
+	prefs.Favorites = append(prefs.Favorites, modelID)
+	return prefs, s.save(ctx, userID, prefs)
+}
+
+// RemoveFavorite removes a model from the user's favorites
+func (s *ModelPreferencesService) RemoveFavorite(ctx context.Context, userID, modelID string) (*models.ModelPreferences, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prefs, err := s.load(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	favorites := []string{}
+	for _, favorite := range prefs.Favorites {
+		if favorite != modelID {
+			favorites = append(favorites, favorite)
+		}
+	}
+	prefs.Favorites = favorites
+
+	return prefs, s.save(ctx, userID, prefs)
+}
+
+// SetAvatarModel sets the preferred model for an avatar; an empty model ID removes the preference
+func (s *ModelPreferencesService) SetAvatarModel(ctx context.Context, userID, avatarID, modelID string) (*models.ModelPreferences, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prefs, err := s.load(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if modelID == "" {
+		delete(prefs.AvatarModels, avatarID)
+	} else {
+		prefs.AvatarModels[avatarID] = modelID
+	}
+	return prefs, s.save(ctx, userID, prefs)
+}
+
+// ResolveModel picks the model for a new chat when none was requested:
+// the preferred model of the first avatar that has one, then the user's default model.
+// It returns an empty string if the user has no applicable preference.
+func (s *ModelPreferencesService) ResolveModel(ctx context.Context, userID string, avatarIDs []string) (string, error) {
+	prefs, err := s.Get(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+
+	for _, avatarID := range avatarIDs {
+		if modelID := prefs.AvatarModels[avatarID]; modelID != "" {
+			return modelID, nil
+		}
+	}
+
+	return prefs.DefaultModelID, nil
+}
+
+// load reads the user's model preferences, returning empty ones if none are stored yet.
+// Preferences that can't be read or decoded are an error, so they aren't overwritten.
+func (s *ModelPreferencesService) load(ctx context.Context, userID string) (*models.ModelPreferences, error) {
+	prefs := &models.ModelPreferences{}
+
+	data, err := s.db.GetUserSetting(ctx, userID, ModelPreferencesSettingKey)
+	if err != nil && !isSettingNotFound(err) {
+		return nil, fmt.Errorf("failed to read model preferences of user %s: %v", userID, err)
+	}
+	if err == nil {
+		if err := decodeSetting(data, prefs); err != nil {
+			return nil, fmt.Errorf("failed to decode model preferences of user %s: %v", userID, err)
+		}
+	}
+
+	if prefs.Favorites == nil {
+		prefs.Favorites = []string{}
+	}
+	if prefs.AvatarModels == nil {
+		prefs.AvatarModels = make(map[string]string)
+	}
+	return prefs, nil
+}
+
+// save writes the user's model preferences
+func (s *ModelPreferencesService) save(ctx context.Context, userID string, prefs *models.ModelPreferences) error {
+	data, err := encodeSetting(prefs)
+	if err != nil {
+		return err
+	}
+	return s.db.SaveUserSetting(ctx, userID, ModelPreferencesSettingKey, data)
+}
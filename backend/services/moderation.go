@@ -0,0 +1,522 @@
+package services
+
+import (
+	"backend/interfaces"
+	"backend/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// moderationReportThreshold is the number of distinct reporters after which unreviewed
+// content is left out of public listings until a moderator looks at it
+const moderationReportThreshold = 3
+
+// Errors returned by the moderation service
+var (
+	ErrModerationCaseNotFound = errors.New("moderation case not found")
+	ErrModerationTarget       = errors.New("reported content not found")
+	ErrModerationAction       = errors.New("unknown moderation action")
+	ErrUserBanned             = errors.New("user is banned")
+	ErrAlreadyReported        = errors.New("content already reported")
+)
+
+// ContentClassifier judges whether content breaks the content rules.
+// Implementations can inspect text only or also the images.
+type ContentClassifier interface {
+	Classify(ctx context.Context, content models.ModerationContent) (*models.ModerationVerdict, error)
+}
+
+// ModerationRule flags text containing one of its keywords. With context words set,
+// a keyword only counts when one of the context words appears in the same sentence.
+type ModerationRule struct {
+	Category string   `json:"category"`
+	Keywords []string `json:"keywords"`
+	Context  []string `json:"context,omitempty"`
+}
+
+// DefaultModerationRules returns the built-in rules of the keyword classifier
+func DefaultModerationRules() []ModerationRule {
+	return []ModerationRule{
+		{
+			Category: "sexual_minors",
+			Keywords: []string{"child", "children", "kid", "kids", "minor", "minors", "underage", "preteen", "schoolgirl", "schoolboy"},
+			Context:  []string{"sex", "sexual", "sexy", "nude", "naked", "explicit", "erotic", "nsfw", "porn", "lewd"},
+		},
+		{
+			Category: "sexual_minors",
+			Keywords: []string{"loli", "lolicon", "shotacon"},
+		},
+		{
+			Category: "self_harm",
+			Keywords: []string{"kill yourself", "suicide method", "suicide methods", "how to commit suicide"},
+		},
+		{
+			Category: "weapons",
+			Keywords: []string{"make a bomb", "build a bomb", "pipe bomb instructions", "synthesize nerve agent"},
+		},
+	}
+}
+
+// LoadModerationRules reads additional keyword rules from a JSON file
+func LoadModerationRules(path string) ([]ModerationRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []ModerationRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("invalid moderation rules: %v", err)
+	}
+	return rules, nil
+}
+
+// moderationSentencePattern splits text into the sentences context words are matched within
+var moderationSentencePattern = regexp.MustCompile(`[.!?\n]+`)
+
+// KeywordClassifier is a local, rule-based classifier that only looks at text
+type KeywordClassifier struct {
+	rules []compiledModerationRule
+}
+
+type compiledModerationRule struct {
+	category string
+	keywords []*regexp.Regexp
+	context  []*regexp.Regexp
+}
+
+// NewKeywordClassifier creates a keyword classifier from rules
+func NewKeywordClassifier(rules []ModerationRule) *KeywordClassifier {
+	compile := func(words []string) []*regexp.Regexp {
+		patterns := []*regexp.Regexp{}
+		for _, word := range words {
+			if strings.TrimSpace(word) != "" {
+				patterns = append(patterns, wordPattern(word))
+			}
+		}
+		return patterns
+	}
+
+	classifier := &KeywordClassifier{}
+	for _, rule := range rules {
+		classifier.rules = append(classifier.rules, compiledModerationRule{
+			category: rule.Category,
+			keywords: compile(rule.Keywords),
+			context:  compile(rule.Context),
+		})
+	}
+	return classifier
+}
+
+// Classify flags the text when any rule matches
+func (k *KeywordClassifier) Classify(ctx context.Context, content models.ModerationContent) (*models.ModerationVerdict, error) {
+	matches := func(patterns []*regexp.Regexp, text string) string {
+		for _, pattern := range patterns {
+			if match := pattern.FindString(text); match != "" {
+				return strings.TrimFunc(match, func(r rune) bool { return !isWordRune(r) })
+			}
+		}
+		return ""
+	}
+	sentences := moderationSentencePattern.Split(content.Text, -1)
+
+	verdict := &models.ModerationVerdict{Categories: []string{}}
+	var reasons []string
+	seen := make(map[string]bool)
+	for _, rule := range k.rules {
+		keyword := ""
+		if len(rule.context) == 0 {
+			keyword = matches(rule.keywords, content.Text)
+		} else {
+			// Unrelated sentences of a long description must not combine into a match
+			for _, sentence := range sentences {
+				if found := matches(rule.keywords, sentence); found != "" && matches(rule.context, sentence) != "" {
+					keyword = found
+					break
+				}
+			}
+		}
+		if keyword == "" {
+			continue
+		}
+		if !seen[rule.category] {
+			seen[rule.category] = true
+			verdict.Categories = append(verdict.Categories, rule.category)
+			reasons = append(reasons, fmt.Sprintf("%s (%q)", rule.category, keyword))
+		}
+	}
+
+	verdict.Flagged = len(verdict.Categories) > 0
+	if verdict.Flagged {
+		verdict.Reason = "Matched " + strings.Join(reasons, ", ")
+	}
+	return verdict, nil
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r)
+}
+
+var (
+	moderationService     *ModerationService
+	moderationServiceOnce sync.Once
+)
+
+// ModerationService screens published content, collects reports in a review queue
+// and applies moderator decisions
+type ModerationService struct {
+	db         interfaces.DatabaseService
+	classifier ContentClassifier
+	moderators map[string]bool
+	mu         sync.Mutex
+}
+
+// GetModerationService returns the shared moderation service. It uses the keyword classifier
+// with the rules from MODERATION_RULES_FILE added to the defaults; MODERATOR_USER_IDS is a
+// comma-separated list of the users allowed to moderate.
+func GetModerationService() *ModerationService {
+	moderationServiceOnce.Do(func() {
+		rules := DefaultModerationRules()
+		if path := os.Getenv("MODERATION_RULES_FILE"); path != "" {
+			extra, err := LoadModerationRules(path)
+			if err != nil {
+				log.Printf("Warning: could not load moderation rules from %s: %v", path, err)
+			} else {
+				rules = append(rules, extra...)
+			}
+		}
+
+		moderationService = NewModerationService(GetDatabaseService(), NewKeywordClassifier(rules), strings.Split(os.Getenv("MODERATOR_USER_IDS"), ","))
+	})
+	return moderationService
+}
+
+// NewModerationService creates a moderation service with the given classifier and moderators
+func NewModerationService(db interfaces.DatabaseService, classifier ContentClassifier, moderatorIDs []string) *ModerationService {
+	s := &ModerationService{
+		db:         db,
+		classifier: classifier,
+		moderators: make(map[string]bool),
+	}
+	for _, id := range moderatorIDs {
+		if id = strings.TrimSpace(id); id != "" {
+			s.moderators[id] = true
+		}
+	}
+	return s
+}
+
+// IsModerator reports whether the user may review content
+func (s *ModerationService) IsModerator(userID string) bool {
+	return s.moderators[userID]
+}
+
+// IsBanned reports whether a moderator banned the user
+func (s *ModerationService) IsBanned(ctx context.Context, userID string) bool {
+	user, err := s.db.GetUser(ctx, userID)
+	return err == nil && user.Banned
+}
+
+// ScreenAvatar classifies a public avatar before it is saved and reports whether it was flagged.
+// Flagged avatars are marked as pending and queued for review; avatars hidden by a moderator stay hidden.
+func (s *ModerationService) ScreenAvatar(ctx context.Context, avatar *models.Avatar) bool {
+	if !avatar.IsPublic {
+		return false
+	}
+
+	text := []string{avatar.Name, avatar.Description, avatar.Story, avatar.Persona}
+	text = append(text, avatar.Greetings...)
+	for _, dialogue := range avatar.ExampleDialogues {
+		text = append(text, dialogue.User, dialogue.Avatar)
+	}
+
+	content := models.ModerationContent{Text: strings.Join(text, "\n")}
+	if avatar.ProfileImageURL != "" {
+		content.ImageURLs = []string{avatar.ProfileImageURL}
+	}
+
+	return s.screen(ctx, models.ModerationTargetAvatar, avatar.ID, avatar.OwnerID, content, &avatar.Moderation)
+}
+
+// ScreenImage classifies an image and its prompt before it is saved to the gallery and reports
+// whether it was flagged
+func (s *ModerationService) ScreenImage(ctx context.Context, image *models.Image) bool {
+	content := models.ModerationContent{
+		Text:      image.Prompt,
+		ImageURLs: []string{image.URL},
+	}
+	return s.screen(ctx, models.ModerationTargetImage, image.ID, image.UserID, content, &image.Moderation)
+}
+
+func (s *ModerationService) screen(ctx context.Context, targetType, targetID, ownerID string, content models.ModerationContent, moderation *models.Moderation) bool {
+	verdict, err := s.classifier.Classify(ctx, content)
+	if err != nil {
+		// A classifier outage must not block publishing; reports still reach the queue
+		log.Printf("Error classifying %s %s: %v", targetType, targetID, err)
+		return false
+	}
+	if !verdict.Flagged {
+		return false
+	}
+
+	now := time.Now().Unix()
+	if !moderation.IsHidden() {
+		moderation.State = models.ModerationStatePending
+	}
+	moderation.Flags = verdict.Categories
+	moderation.Reason = verdict.Reason
+	moderation.UpdatedAt = now
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	moderationCase := s.openCase(ctx, targetType, targetID, ownerID, now)
+	moderationCase.Flags = verdict.Categories
+	moderationCase.Reports = append(moderationCase.Reports, models.ModerationReport{
+		Source:    models.ModerationSourceClassifier,
+		Reason:    verdict.Reason,
+		CreatedAt: now,
+	})
+	if err := s.db.SaveModerationCase(ctx, moderationCase); err != nil {
+		log.Printf("Error queueing %s %s for review: %v", targetType, targetID, err)
+	}
+	return true
+}
+
+// Report adds a user's report to the review queue. Each user can report the same content once
+// until it has been reviewed.
+func (s *ModerationService) Report(ctx context.Context, reporterID string, req models.ReportRequest) (*models.ModerationCase, error) {
+	if s.IsBanned(ctx, reporterID) {
+		return nil, ErrUserBanned
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ownerID, moderation, save, err := s.loadTarget(ctx, req.TargetType, req.TargetID, reporterID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	moderationCase := s.openCase(ctx, req.TargetType, req.TargetID, ownerID, now)
+
+	reporters := make(map[string]bool)
+	for _, report := range moderationCase.Reports {
+		if report.Source == models.ModerationSourceReport && report.CreatedAt >= lastActionTime(moderationCase) {
+			reporters[report.ReporterID] = true
+		}
+	}
+	if reporters[reporterID] {
+		return nil, ErrAlreadyReported
+	}
+
+	moderationCase.Reports = append(moderationCase.Reports, models.ModerationReport{
+		Source:     models.ModerationSourceReport,
+		ReporterID: reporterID,
+		Reason:     strings.TrimSpace(req.Reason),
+		Details:    strings.TrimSpace(req.Details),
+		CreatedAt:  now,
+	})
+	if err := s.db.SaveModerationCase(ctx, moderationCase); err != nil {
+		return nil, err
+	}
+
+	// Unreviewed content that keeps being reported is unlisted until a moderator decides
+	if len(reporters)+1 >= moderationReportThreshold && moderation.State == models.ModerationStateNone {
+		moderation.State = models.ModerationStatePending
+		moderation.Reason = "Reported by several users"
+		moderation.UpdatedAt = now
+		if err := save(); err != nil {
+			log.Printf("Error marking %s %s as pending: %v", req.TargetType, req.TargetID, err)
+		}
+	}
+
+	return moderationCase, nil
+}
+
+// Queue returns the cases with the given status, oldest first
+func (s *ModerationService) Queue(ctx context.Context, status string) ([]*models.ModerationCase, error) {
+	cases, err := s.db.GetModerationCases(ctx, status)
+	if err != nil {
+		return nil, err
+	}
+	if cases == nil {
+		cases = []*models.ModerationCase{}
+	}
+	return cases, nil
+}
+
+// Case returns a single moderation case
+func (s *ModerationService) Case(ctx context.Context, caseID string) (*models.ModerationCase, error) {
+	moderationCase, err := s.db.GetModerationCase(ctx, caseID)
+	if err != nil {
+		return nil, ErrModerationCaseNotFound
+	}
+	return moderationCase, nil
+}
+
+// Act applies a moderator's decision to the content of a case and resolves the case
+func (s *ModerationService) Act(ctx context.Context, caseID, moderatorID string, req models.ModerationActionRequest) (*models.ModerationCase, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	moderationCase, err := s.db.GetModerationCase(ctx, caseID)
+	if err != nil {
+		return nil, ErrModerationCaseNotFound
+	}
+
+	now := time.Now().Unix()
+	switch req.Action {
+	case models.ModerationActionApprove, models.ModerationActionHide, models.ModerationActionBan:
+		state := models.ModerationStateHidden
+		if req.Action == models.ModerationActionApprove {
+			state = models.ModerationStateApproved
+		}
+		if err := s.setState(ctx, moderationCase, state, moderatorID, req.Reason, now); err != nil {
+			return nil, err
+		}
+		if req.Action == models.ModerationActionBan {
+			if err := s.ban(ctx, moderationCase.OwnerID, req.Reason, now); err != nil {
+				return nil, err
+			}
+		}
+	case models.ModerationActionUnpublish:
+		if moderationCase.TargetType != models.ModerationTargetAvatar {
+			return nil, fmt.Errorf("%w: only avatars can be unpublished", ErrModerationAction)
+		}
+		avatar, err := s.db.GetAvatar(ctx, moderationCase.TargetID)
+		if err != nil {
+			return nil, ErrModerationTarget
+		}
+		avatar.IsPublic = false
+		avatar.Moderation.ReviewedBy = moderatorID
+		avatar.Moderation.Reason = req.Reason
+		avatar.Moderation.UpdatedAt = now
+		if err := s.db.UpdateAvatar(ctx, avatar); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrModerationAction
+	}
+
+	moderationCase.Status = models.ModerationCaseResolved
+	moderationCase.Actions = append(moderationCase.Actions, models.ModerationAction{
+		Action:      req.Action,
+		ModeratorID: moderatorID,
+		Reason:      req.Reason,
+		CreatedAt:   now,
+	})
+	moderationCase.UpdatedAt = now
+	if err := s.db.SaveModerationCase(ctx, moderationCase); err != nil {
+		return nil, err
+	}
+	return moderationCase, nil
+}
+
+// setState changes the moderation state of a case's content
+func (s *ModerationService) setState(ctx context.Context, moderationCase *models.ModerationCase, state models.ModerationState, moderatorID, reason string, now int64) error {
+	_, moderation, save, err := s.loadTarget(ctx, moderationCase.TargetType, moderationCase.TargetID, "")
+	if err != nil {
+		return err
+	}
+
+	moderation.State = state
+	moderation.ReviewedBy = moderatorID
+	moderation.Reason = reason
+	moderation.UpdatedAt = now
+	if state == models.ModerationStateApproved {
+		moderation.Flags = nil
+	}
+	return save()
+}
+
+// ban stops a user from publishing and unpublishes all of their public avatars
+func (s *ModerationService) ban(ctx context.Context, userID, reason string, now int64) error {
+	user, err := s.db.GetUser(ctx, userID)
+	if err != nil {
+		// Users are not always stored, e.g. with local authentication
+		user = &models.User{ID: userID, CreatedAt: now}
+	}
+	user.Banned = true
+	user.BannedReason = reason
+	user.UpdatedAt = now
+	if err := s.db.SaveUser(ctx, user); err != nil {
+		return err
+	}
+
+	avatars, err := s.db.GetUserAvatars(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, avatar := range avatars {
+		if !avatar.IsPublic {
+			continue
+		}
+		avatar.IsPublic = false
+		if err := s.db.UpdateAvatar(ctx, avatar); err != nil {
+			log.Printf("Error unpublishing avatar %s of banned user %s: %v", avatar.ID, userID, err)
+		}
+	}
+	return nil
+}
+
+// loadTarget loads the content of a case and returns its owner, its moderation status and a
+// function saving changes to it. A non-empty viewerID must be able to see the content.
+func (s *ModerationService) loadTarget(ctx context.Context, targetType, targetID, viewerID string) (string, *models.Moderation, func() error, error) {
+	switch targetType {
+	case models.ModerationTargetAvatar:
+		avatar, err := s.db.GetAvatar(ctx, targetID)
+		if err != nil || (viewerID != "" && !avatar.VisibleTo(viewerID)) {
+			return "", nil, nil, ErrModerationTarget
+		}
+		return avatar.OwnerID, &avatar.Moderation, func() error {
+			return s.db.UpdateAvatar(ctx, avatar)
+		}, nil
+	case models.ModerationTargetImage:
+		image, err := s.db.GetImage(ctx, targetID)
+		if err != nil || (viewerID != "" && !image.VisibleTo(viewerID)) {
+			return "", nil, nil, ErrModerationTarget
+		}
+		return image.UserID, &image.Moderation, func() error {
+			return s.db.SaveImage(ctx, image)
+		}, nil
+	}
+	return "", nil, nil, ErrModerationTarget
+}
+
+// openCase returns the case of the content, reopening a resolved one or starting a new one
+func (s *ModerationService) openCase(ctx context.Context, targetType, targetID, ownerID string, now int64) *models.ModerationCase {
+	caseID := targetType + "_" + targetID
+	moderationCase, err := s.db.GetModerationCase(ctx, caseID)
+	if err != nil {
+		moderationCase = &models.ModerationCase{
+			ID:         caseID,
+			TargetType: targetType,
+			TargetID:   targetID,
+			CreatedAt:  now,
+		}
+	}
+
+	moderationCase.OwnerID = ownerID
+	moderationCase.Status = models.ModerationCaseOpen
+	moderationCase.UpdatedAt = now
+	return moderationCase
+}
+
+// lastActionTime returns when a moderator last decided on the case, or 0
+func lastActionTime(moderationCase *models.ModerationCase) int64 {
+	if len(moderationCase.Actions) == 0 {
+		return 0
+	}
+	return moderationCase.Actions[len(moderationCase.Actions)-1].CreatedAt
+}
@@ -4,6 +4,7 @@ import (
 	"backend/models"
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,6 +12,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -19,37 +21,132 @@ const (
 	OPENROUTER_CREDITS_URL = "https://openrouter.ai/api/v1/credits"
 )
 
+var (
+	// openRouterClient is used for requests whose response is read at once
+	openRouterClient = &http.Client{Timeout: 3 * time.Minute}
+
+	// openRouterStreamClient is used for streams. A reply may take minutes to stream,
+	// so only the wait for the response headers is limited.
+	openRouterStreamClient = newOpenRouterStreamClient()
+)
+
+func newOpenRouterStreamClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = time.Minute
+	return &http.Client{Transport: transport}
+}
+
 type OpenRouterService struct {
-	APIKey string
+	APIKey      string
+	RetryPolicy RetryPolicy
+
+	ctx context.Context // Set by WithContext
 }
 
 type OpenRouterMessage struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
+
+	// Mixed text and image parts for vision models; when set they are sent instead of Content
+	Parts []OpenRouterContentPart `json:"-"`
+}
+
+// OpenRouterContentPart is a part of a message in the OpenAI content array format
+type OpenRouterContentPart struct {
+	Type     string              `json:"type"` // "text" or "image_url"
+	Text     string              `json:"text,omitempty"`
+	ImageURL *OpenRouterImageURL `json:"image_url,omitempty"`
+}
+
+// OpenRouterImageURL is the image of an "image_url" part, a URL or a base64 data URI
+type OpenRouterImageURL struct {
+	URL string `json:"url"`
+}
+
+// TextPart creates a text content part
+func TextPart(text string) OpenRouterContentPart {
+	return OpenRouterContentPart{Type: "text", Text: text}
+}
+
+// ImagePart creates an image content part
+func ImagePart(url string) OpenRouterContentPart {
+	return OpenRouterContentPart{Type: "image_url", ImageURL: &OpenRouterImageURL{URL: url}}
+}
+
+// ImageContentParts puts the text first, if any, followed by the images
+func ImageContentParts(text string, imageURLs []string) []OpenRouterContentPart {
+	parts := []OpenRouterContentPart{}
+	if text != "" {
+		parts = append(parts, TextPart(text))
+	}
+	for _, url := range imageURLs {
+		parts = append(parts, ImagePart(url))
+	}
+	return parts
+}
+
+// MarshalJSON sends the content as a string, or as an array of parts if the message has any
+func (m OpenRouterMessage) MarshalJSON() ([]byte, error) {
+	if len(m.Parts) == 0 {
+		return json.Marshal(struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		}{m.Role, m.Content})
+	}
+	return json.Marshal(struct {
+		Role    string                  `json:"role"`
+		Content []OpenRouterContentPart `json:"content"`
+	}{m.Role, m.Parts})
 }
 
 type OpenRouterRequest struct {
-	Model    string              `json:"model"`
-	Messages []OpenRouterMessage `json:"messages"`
-	Stream   bool                `json:"stream,omitempty"`
+	Model    string                  `json:"model"`
+	Messages []OpenRouterMessage     `json:"messages"`
+	Stream   bool                    `json:"stream,omitempty"`
+	Usage    *OpenRouterUsageOptions `json:"usage,omitempty"`
+}
+
+// OpenRouterUsageOptions asks OpenRouter to include token usage in the response.
+// For streaming requests the usage arrives in the last chunk before [DONE].
+type OpenRouterUsageOptions struct {
+	Include bool `json:"include"`
+}
+
+// OpenRouterUsage is the token accounting returned with a completion
+type OpenRouterUsage struct {
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	Cost             float64 `json:"cost"`
 }
 
 type OpenRouterResponse struct {
+	Model   string `json:"model"`
 	Choices []struct {
 		Message struct {
 			Role    string `json:"role"`
 			Content string `json:"content"`
 		} `json:"message"`
 	} `json:"choices"`
+	Usage *OpenRouterUsage `json:"usage,omitempty"`
 }
 
 type OpenRouterStreamResponse struct {
+	Model   string `json:"model"`
 	Choices []struct {
 		Delta struct {
 			Content string `json:"content"`
 		} `json:"delta"`
 		Index int `json:"index"`
 	} `json:"choices"`
+	Usage *OpenRouterUsage `json:"usage,omitempty"`
+}
+
+// OpenRouterCompletion is the result of a non-streaming chat completion
+type OpenRouterCompletion struct {
+	Content string
+	Model   string // Model that actually produced the completion
+	Usage   *OpenRouterUsage
 }
 
 type OpenRouterModelResponse struct {
@@ -89,28 +186,55 @@ type OpenRouterCredits struct {
 
 func NewOpenRouterService(apiKey string) *OpenRouterService {
 	return &OpenRouterService{
-		APIKey: apiKey,
+		APIKey:      apiKey,
+		RetryPolicy: DefaultRetryPolicy(),
 	}
 }
 
+// WithContext returns a copy of the service whose requests and retries are canceled with ctx,
+// e.g. when the client of the calling request disconnects
+func (s *OpenRouterService) WithContext(ctx context.Context) *OpenRouterService {
+	service := *s
+	service.ctx = ctx
+	return &service
+}
+
+// requestContext returns the context of the service's requests
+func (s *OpenRouterService) requestContext() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
 func (s *OpenRouterService) SendMessage(modelID string, messages []OpenRouterMessage) (string, error) {
+	completion, err := s.SendCompletion(modelID, messages)
+	if err != nil {
+		return "", err
+	}
+	return completion.Content, nil
+}
+
+// SendCompletion sends a chat completion request and returns the reply together with its token usage
+func (s *OpenRouterService) SendCompletion(modelID string, messages []OpenRouterMessage) (*OpenRouterCompletion, error) {
 	if s.APIKey == "" {
-		return "", errors.New("API key not set")
+		return nil, errors.New("API key not set")
 	}
 
 	reqBody := OpenRouterRequest{
 		Model:    modelID,
 		Messages: messages,
+		Usage:    &OpenRouterUsageOptions{Include: true},
 	}
 
 	jsonBody, err := json.Marshal(reqBody)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request body: %v", err)
+		return nil, fmt.Errorf("failed to marshal request body: %v", err)
 	}
 
-	req, err := http.NewRequest("POST", OPENROUTER_API_URL, bytes.NewBuffer(jsonBody))
+	req, err := http.NewRequestWithContext(s.requestContext(), "POST", OPENROUTER_API_URL, bytes.NewBuffer(jsonBody))
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %v", err)
+		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
@@ -118,48 +242,139 @@ func (s *OpenRouterService) SendMessage(modelID string, messages []OpenRouterMes
 	req.Header.Set("HTTP-Referer", "https://github.com/") // Required by OpenRouter
 	req.Header.Set("X-Title", "Chat Application")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := openRouterClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to send request: %v", err)
+		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	bodyBytes, _ := io.ReadAll(resp.Body)
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+		return nil, newOpenRouterAPIError(resp, bodyBytes)
 	}
 
 	// Try to decode the response
 	var openRouterResp OpenRouterResponse
 	if err := json.Unmarshal(bodyBytes, &openRouterResp); err != nil {
-		return "", fmt.Errorf("failed to decode response: %v\nResponse body: %s", err, string(bodyBytes))
+		return nil, fmt.Errorf("failed to decode response: %v\nResponse body: %s", err, string(bodyBytes))
 	}
 
 	if len(openRouterResp.Choices) == 0 {
-		return "", fmt.Errorf("no response from the model\nResponse body: %s", string(bodyBytes))
+		return nil, fmt.Errorf("no response from the model\nResponse body: %s", string(bodyBytes))
 	}
 
-	return openRouterResp.Choices[0].Message.Content, nil
+	completion := &OpenRouterCompletion{
+		Content: openRouterResp.Choices[0].Message.Content,
+		Model:   openRouterResp.Model,
+		Usage:   openRouterResp.Usage,
+	}
+	if completion.Model == "" {
+		completion.Model = modelID
+	}
+
+	return completion, nil
 }
 
-// GetModels fetches available models from OpenRouter
-func (s *OpenRouterService) GetModels() ([]models.OpenRouterModel, error) {
+// SendCompletionWithFallback sends a chat completion, retrying failed requests according to
+// the retry policy and moving on to the next model when one keeps failing or is unavailable.
+// The returned completion records the model that actually answered.
+func (s *OpenRouterService) SendCompletionWithFallback(modelIDs []string, messages []OpenRouterMessage) (*OpenRouterCompletion, error) {
 	if s.APIKey == "" {
 		return nil, errors.New("API key not set")
 	}
 
-	req, err := http.NewRequest("GET", OPENROUTER_MODELS_URL, nil)
+	var completion *OpenRouterCompletion
+	err := s.RetryPolicy.withFallback(s.requestContext(), modelIDs, func(modelID string) error {
+		var err error
+		completion, err = s.SendCompletion(modelID, messages)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return completion, nil
+}
+
+// OpenStream starts a streaming chat completion and returns the open response.
+// The caller is responsible for closing the response body.
+func (s *OpenRouterService) OpenStream(modelID string, messages []OpenRouterMessage) (*http.Response, error) {
+	if s.APIKey == "" {
+		return nil, errors.New("API key not set")
+	}
+
+	reqBody := OpenRouterRequest{
+		Model:    modelID,
+		Messages: messages,
+		Stream:   true,
+		Usage:    &OpenRouterUsageOptions{Include: true},
+	}
+
+	jsonBody, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request body: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(s.requestContext(), "POST", OPENROUTER_API_URL, bytes.NewBuffer(jsonBody))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
 
+	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.APIKey))
 	req.Header.Set("HTTP-Referer", "https://github.com/") // Required by OpenRouter
 	req.Header.Set("X-Title", "Chat Application")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := openRouterStreamClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, newOpenRouterAPIError(resp, bodyBytes)
+	}
+
+	return resp, nil
+}
+
+// OpenStreamWithFallback starts a streaming chat completion with the same retry and fallback
+// behaviour as SendCompletionWithFallback. Retries only cover establishing the stream.
+// It returns the open response and the model that accepted the request.
+func (s *OpenRouterService) OpenStreamWithFallback(modelIDs []string, messages []OpenRouterMessage) (*http.Response, string, error) {
+	if s.APIKey == "" {
+		return nil, "", errors.New("API key not set")
+	}
+
+	var resp *http.Response
+	var usedModel string
+	err := s.RetryPolicy.withFallback(s.requestContext(), modelIDs, func(modelID string) error {
+		var err error
+		resp, err = s.OpenStream(modelID, messages)
+		usedModel = modelID
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return resp, usedModel, nil
+}
+
+// GetModels fetches available models from OpenRouter
+func (s *OpenRouterService) GetModels() ([]models.OpenRouterModel, error) {
+	req, err := http.NewRequestWithContext(s.requestContext(), "GET", OPENROUTER_MODELS_URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	// The model list is public, the key is only sent when there is one
+	if s.APIKey != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.APIKey))
+	}
+	req.Header.Set("HTTP-Referer", "https://github.com/") // Required by OpenRouter
+	req.Header.Set("X-Title", "Chat Application")
+
+	resp, err := openRouterClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send request: %v", err)
 	}
@@ -193,6 +408,14 @@ func (s *OpenRouterService) GetModels() ([]models.OpenRouterModel, error) {
 			Name:        m.Name,
 			Description: m.Description,
 			Context:     m.Context,
+			Created:     m.Created,
+			Modality:    m.Architecture.Modality,
+			Tokenizer:   m.Architecture.Tokenizer,
+			IsModerated: m.TopProvider.IsModerated,
+		}
+		// max_completion_tokens is null for models without a limit
+		if maxTokens, ok := m.TopProvider.MaxCompletionTokens.(float64); ok {
+			model.MaxCompletionTokens = int(maxTokens)
 		}
 		model.PricePerToken.Prompt = promptPrice
 		model.PricePerToken.Completion = completionPrice
@@ -204,7 +427,7 @@ func (s *OpenRouterService) GetModels() ([]models.OpenRouterModel, error) {
 
 // ValidateAPIKey checks if the API key is valid by attempting to fetch models
 func (s *OpenRouterService) ValidateAPIKey() error {
-	req, err := http.NewRequest("GET", OPENROUTER_MODELS_URL, nil)
+	req, err := http.NewRequestWithContext(s.requestContext(), "GET", OPENROUTER_MODELS_URL, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %v", err)
 	}
@@ -213,8 +436,7 @@ func (s *OpenRouterService) ValidateAPIKey() error {
 	req.Header.Set("HTTP-Referer", "https://github.com/") // Required by OpenRouter
 	req.Header.Set("X-Title", "Chat Application")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := openRouterClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to send request: %v", err)
 	}
@@ -234,7 +456,7 @@ func (s *OpenRouterService) GetCredits() (*OpenRouterCredits, error) {
 		return nil, errors.New("API key not set")
 	}
 
-	req, err := http.NewRequest("GET", OPENROUTER_CREDITS_URL, nil)
+	req, err := http.NewRequestWithContext(s.requestContext(), "GET", OPENROUTER_CREDITS_URL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
@@ -243,8 +465,7 @@ func (s *OpenRouterService) GetCredits() (*OpenRouterCredits, error) {
 	req.Header.Set("HTTP-Referer", "https://github.com/") // Required by OpenRouter
 	req.Header.Set("X-Title", "Chat Application")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := openRouterClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send request: %v", err)
 	}
@@ -281,7 +502,7 @@ func (s *OpenRouterService) SendMessageStream(modelID string, messages []OpenRou
 		return fmt.Errorf("failed to marshal request body: %v", err)
 	}
 
-	req, err := http.NewRequest("POST", OPENROUTER_API_URL, bytes.NewBuffer(jsonBody))
+	req, err := http.NewRequestWithContext(s.requestContext(), "POST", OPENROUTER_API_URL, bytes.NewBuffer(jsonBody))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %v", err)
 	}
@@ -291,8 +512,7 @@ func (s *OpenRouterService) SendMessageStream(modelID string, messages []OpenRou
 	req.Header.Set("HTTP-Referer", "https://github.com/") // Required by OpenRouter
 	req.Header.Set("X-Title", "Chat Application")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := openRouterStreamClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to send request: %v", err)
 	}
@@ -0,0 +1,169 @@
+package services
+
+import (
+	"backend/models"
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+const (
+	// defaultPromptEnhancerModel rewrites prompts when neither the request nor the user picked a chat model
+	defaultPromptEnhancerModel = "openai/gpt-4o-mini"
+
+	// maxEnhancedPromptLength caps the rewritten prompt; image models ignore most of a longer one
+	maxEnhancedPromptLength = 2000
+)
+
+const defaultPromptEnhancerTemplate = `You write prompts for text-to-image models.
+Rewrite the user's prompt into a single detailed prompt that produces a striking image.
+Keep the subject and every detail the user asked for, and add composition, lighting, mood and level of detail.
+{{if eq .Operation "inpaint"}}The prompt describes what to paint into a masked area of an existing image, so describe only that content and how it blends with its surroundings.
+{{end}}{{if .Style}}Render it in this style: {{.Style}}{{if .StyleGuide}} ({{.StyleGuide}}){{end}}.
+{{end}}{{if .Model}}The prompt is for the image model {{.Model}}.
+{{end}}Write the prompt in English as comma-separated phrases, at most 120 words.
+Answer with the prompt only, without quotes, labels or explanations.`
+
+// ImagePromptStyles are the built-in styles with the guidance given to the rewriting model.
+// Other styles are passed to the model as written.
+var ImagePromptStyles = map[string]string{
+	"photorealistic": "a realistic photograph, natural lighting, sharp focus, realistic textures and camera details",
+	"cinematic":      "a film still, dramatic lighting, shallow depth of field, anamorphic framing, color grading",
+	"anime":          "anime illustration, clean line art, cel shading, vibrant colors, expressive characters",
+	"digital-art":    "polished digital painting, concept art quality, rich colors, detailed rendering",
+	"oil-painting":   "oil painting on canvas, visible brush strokes, classical composition, rich pigments",
+	"watercolor":     "watercolor painting, soft washes, paper texture, loose edges, gentle palette",
+	"pixel-art":      "pixel art, limited palette, crisp pixels, retro video game aesthetic",
+	"3d-render":      "3D render, physically based materials, global illumination, studio lighting",
+}
+
+var (
+	promptEnhancer     *PromptEnhancer
+	promptEnhancerOnce sync.Once
+)
+
+// PromptEnhancerData is the data available to the rewriting template
+type PromptEnhancerData struct {
+	Operation  string // Image operation, e.g. "generate" or "inpaint"
+	Style      string // Requested style, if any
+	StyleGuide string // Guidance for built-in styles
+	Model      string // Name of the image model, if known
+}
+
+// PromptEnhancer rewrites short image prompts into detailed ones with a chat model
+type PromptEnhancer struct {
+	template     *template.Template
+	defaultModel string
+}
+
+// GetPromptEnhancer returns the shared prompt enhancer. PROMPT_ENHANCER_MODEL sets the
+// fallback chat model and PROMPT_ENHANCER_TEMPLATE_FILE replaces the rewriting instructions.
+func GetPromptEnhancer() *PromptEnhancer {
+	promptEnhancerOnce.Do(func() {
+		text := defaultPromptEnhancerTemplate
+		if path := os.Getenv("PROMPT_ENHANCER_TEMPLATE_FILE"); path != "" {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				log.Printf("Warning: could not read prompt enhancer template %s, using the built-in one: %v", path, err)
+			} else {
+				text = string(data)
+			}
+		}
+
+		enhancer, err := NewPromptEnhancer(text, os.Getenv("PROMPT_ENHANCER_MODEL"))
+		if err != nil {
+			log.Printf("Warning: invalid prompt enhancer template, using the built-in one: %v", err)
+			enhancer, _ = NewPromptEnhancer(defaultPromptEnhancerTemplate, os.Getenv("PROMPT_ENHANCER_MODEL"))
+		}
+		promptEnhancer = enhancer
+	})
+	return promptEnhancer
+}
+
+// NewPromptEnhancer creates a prompt enhancer from a rewriting template. The default model
+// is used when no chat model is given.
+func NewPromptEnhancer(text, defaultModel string) (*PromptEnhancer, error) {
+	tmpl, err := template.New("enhance").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template: %v", err)
+	}
+	if err := tmpl.Execute(&bytes.Buffer{}, PromptEnhancerData{Operation: models.ImageOperationGenerate, Style: "anime"}); err != nil {
+		return nil, fmt.Errorf("failed to render template: %v", err)
+	}
+
+	if defaultModel == "" {
+		defaultModel = defaultPromptEnhancerModel
+	}
+	return &PromptEnhancer{
+		template:     tmpl,
+		defaultModel: defaultModel,
+	}, nil
+}
+
+// ModelChain returns the chat models to try, the given model first
+func (e *PromptEnhancer) ModelChain(modelID string) []string {
+	if modelID == "" || modelID == e.defaultModel {
+		return []string{e.defaultModel}
+	}
+	return []string{modelID, e.defaultModel}
+}
+
+// Enhance rewrites a prompt for an image operation. The completion is returned for usage
+// accounting, also when the answer can't be used.
+func (e *PromptEnhancer) Enhance(openRouter *OpenRouterService, modelIDs []string, prompt, style, operation string, imageModel *models.ImageModel) (string, *OpenRouterCompletion, error) {
+	prompt = strings.TrimSpace(prompt)
+	if prompt == "" {
+		return "", nil, fmt.Errorf("a prompt is required")
+	}
+
+	data := PromptEnhancerData{
+		Operation:  operation,
+		Style:      strings.TrimSpace(style),
+		StyleGuide: ImagePromptStyles[strings.ToLower(strings.TrimSpace(style))],
+	}
+	if imageModel != nil {
+		data.Model = imageModel.Name
+	}
+
+	var instructions bytes.Buffer
+	if err := e.template.Execute(&instructions, data); err != nil {
+		return "", nil, fmt.Errorf("failed to render template: %v", err)
+	}
+
+	messages := []OpenRouterMessage{
+		{Role: "system", Content: instructions.String()},
+		{Role: "user", Content: prompt},
+	}
+
+	completion, err := openRouter.SendCompletionWithFallback(modelIDs, messages)
+	if err != nil {
+		return "", nil, err
+	}
+
+	enhanced := cleanEnhancedPrompt(completion.Content)
+	if enhanced == "" {
+		return "", completion, fmt.Errorf("the chat model returned an empty prompt")
+	}
+	return enhanced, completion, nil
+}
+
+// cleanEnhancedPrompt strips labels and quotes that chat models tend to add despite the instructions
+func cleanEnhancedPrompt(content string) string {
+	content = strings.TrimSpace(content)
+	for _, label := range []string{"Enhanced prompt:", "Prompt:"} {
+		if len(content) >= len(label) && strings.EqualFold(content[:len(label)], label) {
+			content = strings.TrimSpace(content[len(label):])
+		}
+	}
+	content = strings.Trim(content, "\"'`")
+	content = strings.Join(strings.Fields(content), " ")
+
+	if runes := []rune(content); len(runes) > maxEnhancedPromptLength {
+		content = string(runes[:maxEnhancedPromptLength])
+	}
+	return content
+}
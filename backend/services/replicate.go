@@ -1,6 +1,7 @@
 package services
 
 import (
+	"backend/models"
 	"bytes"
 	"encoding/json"
 	"fmt"
@@ -14,9 +15,19 @@ import (
 
 type ReplicateService struct {
 	apiKey     string
+	baseURL    string
 	httpClient *http.Client
 }
 
+// replicateAPIURL returns the Replicate API base URL. REPLICATE_API_URL points the
+// service at another server, such as a local fake of the API.
+func replicateAPIURL() string {
+	if url := os.Getenv("REPLICATE_API_URL"); url != "" {
+		return strings.TrimSuffix(url, "/")
+	}
+	return "https://api.replicate.com/v1"
+}
+
 type ReplicateRequest struct {
 	Version string                 `json:"version"`
 	Input   map[string]interface{} `json:"input"`
@@ -38,7 +49,8 @@ func NewReplicateService() *ReplicateService {
 		log.Printf("WARNING: REPLICATE_API_KEY environment variable is empty")
 		// Return a service that will return errors when used
 		return &ReplicateService{
-			apiKey: "",
+			apiKey:  "",
+			baseURL: replicateAPIURL(),
 			httpClient: &http.Client{
 				Timeout: time.Minute * 5, // Increase timeout to 5 minutes
 			},
@@ -58,7 +70,8 @@ func NewReplicateService() *ReplicateService {
 	}
 
 	return &ReplicateService{
-		apiKey: apiKey,
+		apiKey:  apiKey,
+		baseURL: replicateAPIURL(),
 		httpClient: &http.Client{
 			Timeout: time.Minute * 5, // Increase timeout to 5 minutes
 		},
@@ -68,7 +81,8 @@ func NewReplicateService() *ReplicateService {
 // NewReplicateServiceWithKey creates a new ReplicateService with the provided API key
 func NewReplicateServiceWithKey(apiKey string) *ReplicateService {
 	return &ReplicateService{
-		apiKey: apiKey,
+		apiKey:  apiKey,
+		baseURL: replicateAPIURL(),
 		httpClient: &http.Client{
 			Timeout: time.Minute * 5,
 		},
@@ -88,7 +102,7 @@ func (s *ReplicateService) ValidateAPIKey() error {
 	}
 
 	// Make a lightweight API call to verify the key
-	req, err := http.NewRequest("GET", "https://api.replicate.com/v1/models", nil)
+	req, err := http.NewRequest("GET", s.baseURL+"/models", nil)
 	if err != nil {
 		return fmt.Errorf("Error creating request: %v", err)
 	}
@@ -113,39 +127,29 @@ func (s *ReplicateService) ValidateAPIKey() error {
 	return nil
 }
 
+// GenerateImage generates an image from a prompt with the default generation model
 func (s *ReplicateService) GenerateImage(prompt string) (string, error) {
-	// don't touch this model
-	modelVersion := "black-forest-labs/flux-schnell"
-
-	input := map[string]interface{}{
-		"prompt":      prompt,
-		"width":       512,
-		"height":      512,
-		"num_outputs": 1,
-	}
-
-	log.Printf("Generating image with model: %s", modelVersion)
-	log.Printf("Input parameters: %+v", input)
-
-	return s.predict(modelVersion, input)
+	return runDefaultImageModel(s, models.ImageOperationGenerate, map[string]interface{}{
+		"prompt": prompt,
+	})
 }
 
+// InpaintImage repaints the masked area of an image with the default inpainting model
 func (s *ReplicateService) InpaintImage(imageURL, prompt, maskURL string) (string, error) {
-	// Using the working model from the provided code
-	modelVersion := "zsxkib/flux-dev-inpainting:11cca3274341de7aef06f04e4dab3d651ea8ac04eff003f23603d4fdf5b56ff0"
-
-	// Match the input parameters from the working code
-	input := map[string]interface{}{
-		"image":       imageURL,
-		"mask":        maskURL,
-		"prompt":      prompt,
-		"num_outputs": 1,
-	}
+	return runDefaultImageModel(s, models.ImageOperationInpaint, map[string]interface{}{
+		"image":  imageURL,
+		"mask":   maskURL,
+		"prompt": prompt,
+	})
+}
 
-	log.Printf("Inpainting with model: %s", modelVersion)
+// RunModel runs a prediction with input that was validated against the model's schema
+// and returns the URLs of all outputs
+func (s *ReplicateService) RunModel(model *models.ImageModel, input map[string]interface{}) ([]string, error) {
+	log.Printf("Running image model %s (%s)", model.ID, model.Model)
 	log.Printf("Input parameters: %+v", input)
 
-	return s.predict(modelVersion, input)
+	return s.predict(model, input)
 }
 
 // Helper function to get status as string
@@ -165,75 +169,70 @@ func getStatusString(status interface{}) string {
 	}
 }
 
-func (s *ReplicateService) predict(version string, input map[string]interface{}) (string, error) {
-	// Check if API key is empty
-	if s.apiKey == "" {
-		return "", fmt.Errorf("Replicate API key is not set")
-	}
-
-	// Split version into model and version parts
-	parts := strings.Split(version, ":")
-	var modelName, versionID string
-	var useDirectModelEndpoint bool
-
-	if len(parts) == 2 {
-		// Format: "owner/model:version"
-		modelName = parts[0]
-		versionID = parts[1]
-	} else if len(parts) == 1 {
-		// Format: "owner/model" - might need to use direct model endpoint
-		modelName = parts[0]
-
-		// Special case for models that need direct model endpoint
-		if modelName == "black-forest-labs/flux-schnell" {
-			useDirectModelEndpoint = true
-			// No version needed for direct model endpoint
-		} else {
-			// For other models without explicit versions, we need a version
-			return "", fmt.Errorf("model without version not supported: %s", modelName)
-		}
-	} else {
-		return "", fmt.Errorf("invalid model version format: %s", version)
+// predict runs a prediction and waits for it by polling
+func (s *ReplicateService) predict(model *models.ImageModel, input map[string]interface{}) ([]string, error) {
+	prediction, err := s.createPrediction(model, input, "")
+	if err != nil {
+		return nil, err
 	}
 
-	// Log the model and version for debugging
-	if useDirectModelEndpoint {
-		log.Printf("Using direct model endpoint for: %s", modelName)
-	} else {
-		log.Printf("Using model: %s, version: %s", modelName, versionID)
-	}
+	// Poll for completion
+	maxRetries := 60                // Increase max retries
+	pollInterval := 3 * time.Second // Increase poll interval
 
-	var jsonData []byte
-	var err error
-	var apiURL string
+	for i := 0; i < maxRetries; i++ {
+		statusStr := getStatusString(prediction.Status)
+		log.Printf("Current status: %s (original: %v)", statusStr, prediction.Status)
 
-	if useDirectModelEndpoint {
-		// For direct model endpoint, we need to wrap the input in an "input" object
-		requestBody := map[string]interface{}{
-			"input": input,
+		if isPredictionDone(prediction) {
+			return predictionOutputs(prediction)
 		}
 
-		jsonData, err = json.Marshal(requestBody)
+		log.Printf("Waiting for prediction to complete, attempt %d/%d", i+1, maxRetries)
+		time.Sleep(pollInterval)
+
+		polled, err := s.GetPrediction(prediction.ID)
 		if err != nil {
-			return "", fmt.Errorf("error marshaling request: %v", err)
+			log.Printf("Error polling prediction (will retry): %v", err)
+			continue
 		}
+		prediction = polled
+	}
 
-		// Use the direct model endpoint
-		apiURL = fmt.Sprintf("https://api.replicate.com/v1/models/%s/predictions", modelName)
-	} else {
-		// Standard endpoint with version
-		reqBody := ReplicateRequest{
-			Version: versionID,
-			Input:   input,
-		}
+	return nil, fmt.Errorf("timeout waiting for prediction after %d attempts", maxRetries)
+}
 
-		jsonData, err = json.Marshal(reqBody)
-		if err != nil {
-			return "", fmt.Errorf("error marshaling request: %v", err)
-		}
+// createPrediction starts a prediction. With a webhook URL, Replicate calls it once the
+// prediction has completed.
+func (s *ReplicateService) createPrediction(model *models.ImageModel, input map[string]interface{}, webhookURL string) (*ReplicateResponse, error) {
+	// Check if API key is empty
+	if s.apiKey == "" {
+		return nil, fmt.Errorf("Replicate API key is not set")
+	}
 
-		// Use the standard predictions endpoint
-		apiURL = "https://api.replicate.com/v1/predictions"
+	requestBody := map[string]interface{}{
+		"input": input,
+	}
+	if webhookURL != "" {
+		requestBody["webhook"] = webhookURL
+		requestBody["webhook_events_filter"] = []string{"completed"}
+	}
+
+	var apiURL string
+	if model.Endpoint == models.ImageEndpointModel {
+		// Model endpoints run the latest version, so the request only carries the input
+		log.Printf("Using direct model endpoint for: %s", model.Model)
+		apiURL = fmt.Sprintf("%s/models/%s/predictions", s.baseURL, model.Model)
+	} else {
+		// Standard endpoint with a pinned version
+		log.Printf("Using model: %s, version: %s", model.Model, model.Version)
+		requestBody["version"] = model.Version
+		apiURL = fmt.Sprintf("%s/predictions", s.baseURL)
+	}
+
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request: %v", err)
 	}
 
 	// Log the request body and URL for debugging
@@ -242,145 +241,129 @@ func (s *ReplicateService) predict(version string, input map[string]interface{})
 
 	req, err := http.NewRequest("POST", apiURL, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return "", fmt.Errorf("error creating request: %v", err)
+		return nil, fmt.Errorf("error creating request: %v", err)
 	}
 
+	bodyBytes, statusCode, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check if the response status code is not successful
+	if statusCode != http.StatusOK && statusCode != http.StatusCreated {
+		return nil, fmt.Errorf("API returned error status %d: %s", statusCode, string(bodyBytes))
+	}
+
+	var prediction ReplicateResponse
+	if err := json.Unmarshal(bodyBytes, &prediction); err != nil {
+		return nil, fmt.Errorf("error decoding response: %v, body: %s", err, string(bodyBytes))
+	}
+
+	// Log the parsed response
+	log.Printf("Parsed response - ID: %s, Status: %v, Error: %s",
+		prediction.ID, prediction.Status, prediction.Error)
+
+	if prediction.ID == "" {
+		return nil, fmt.Errorf("no prediction ID returned from API, body: %s", string(bodyBytes))
+	}
+
+	return &prediction, nil
+}
+
+// GetPrediction fetches the current state of a prediction
+func (s *ReplicateService) GetPrediction(predictionID string) (*ReplicateResponse, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/predictions/%s", s.baseURL, predictionID), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating poll request: %v", err)
+	}
+
+	bodyBytes, statusCode, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if statusCode != http.StatusOK {
+		return nil, fmt.Errorf("API returned error status %d during polling: %s", statusCode, string(bodyBytes))
+	}
+
+	var prediction ReplicateResponse
+	if err := json.Unmarshal(bodyBytes, &prediction); err != nil {
+		return nil, fmt.Errorf("error decoding poll response: %v, body: %s", err, string(bodyBytes))
+	}
+	return &prediction, nil
+}
+
+// do sends an authenticated request to the Replicate API and returns the response body
+func (s *ReplicateService) do(req *http.Request) ([]byte, int, error) {
 	// Debug log to check the authorization header
-	authHeader := fmt.Sprintf("Token %s", s.apiKey)
 	if len(s.apiKey) > 10 {
 		log.Printf("Using Authorization header: Token %s...", s.apiKey[:10]) // Only log first few characters for security
 	} else {
 		log.Printf("WARNING: API key is too short, authentication will likely fail")
 	}
 
-	req.Header.Set("Authorization", authHeader)
+	req.Header.Set("Authorization", fmt.Sprintf("Token %s", s.apiKey))
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := s.httpClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("error making request: %v", err)
+		return nil, 0, fmt.Errorf("error making request: %v", err)
 	}
 	defer resp.Body.Close()
 
-	// Read the response body for debugging
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("error reading response body: %v", err)
+		return nil, 0, fmt.Errorf("error reading response body: %v", err)
 	}
 
 	// Log the raw response for debugging
 	log.Printf("Replicate API response status: %d", resp.StatusCode)
 	log.Printf("Replicate API response body: %s", string(bodyBytes))
 
-	// Check if the response status code is not successful
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		return "", fmt.Errorf("API returned error status %d: %s", resp.StatusCode, string(bodyBytes))
-	}
-
-	// Create a new reader with the same data for JSON decoding
-	respBodyReader := bytes.NewReader(bodyBytes)
+	return bodyBytes, resp.StatusCode, nil
+}
 
-	var prediction ReplicateResponse
-	if err := json.NewDecoder(respBodyReader).Decode(&prediction); err != nil {
-		return "", fmt.Errorf("error decoding response: %v, body: %s", err, string(bodyBytes))
+// isPredictionDone reports whether a prediction has reached a final state
+func isPredictionDone(prediction *ReplicateResponse) bool {
+	switch getStatusString(prediction.Status) {
+	case "succeeded", "failed", "canceled":
+		return true
 	}
+	return false
+}
 
-	// Log the parsed response
-	log.Printf("Parsed response - ID: %s, Status: %v, Error: %s",
-		prediction.ID, prediction.Status, prediction.Error)
-
-	if prediction.ID == "" {
-		return "", fmt.Errorf("no prediction ID returned from API, body: %s", string(bodyBytes))
+// predictionOutputs returns the output URLs of a finished prediction
+func predictionOutputs(prediction *ReplicateResponse) ([]string, error) {
+	switch getStatusString(prediction.Status) {
+	case "failed":
+		return nil, fmt.Errorf("prediction failed: %s", prediction.Error)
+	case "canceled":
+		return nil, fmt.Errorf("prediction was canceled")
 	}
 
-	// Poll for completion
-	maxRetries := 60                // Increase max retries
-	pollInterval := 3 * time.Second // Increase poll interval
-
-	for i := 0; i < maxRetries; i++ {
-		statusStr := getStatusString(prediction.Status)
-		log.Printf("Current status: %s (original: %v)", statusStr, prediction.Status)
-
-		if statusStr == "succeeded" {
-			// Handle the output based on its type
-			if prediction.Output != nil {
-				log.Printf("Prediction succeeded with output type: %T, value: %v", prediction.Output, prediction.Output)
-
-				// Handle different output types
-				switch output := prediction.Output.(type) {
-				case []interface{}:
-					if len(output) > 0 {
-						// Convert the first element to string
-						if str, ok := output[0].(string); ok {
-							return str, nil
-						}
-					}
-				case string:
-					// If output is directly a string
-					return output, nil
-				}
-
-				// If we couldn't extract a string, return the raw output as JSON
-				outputJSON, _ := json.Marshal(prediction.Output)
-				return string(outputJSON), nil
+	if prediction.Output == nil {
+		return nil, fmt.Errorf("prediction succeeded but no output was returned")
+	}
+	log.Printf("Prediction succeeded with output type: %T, value: %v", prediction.Output, prediction.Output)
+
+	// Handle different output types
+	switch output := prediction.Output.(type) {
+	case []interface{}:
+		// Models generating several images return one URL per image
+		var urls []string
+		for _, item := range output {
+			if str, ok := item.(string); ok {
+				urls = append(urls, str)
 			}
-
-			return "", fmt.Errorf("prediction succeeded but no output was returned")
-		}
-
-		if statusStr == "failed" {
-			return "", fmt.Errorf("prediction failed: %s", prediction.Error)
 		}
-
-		log.Printf("Waiting for prediction to complete, attempt %d/%d", i+1, maxRetries)
-		time.Sleep(pollInterval)
-
-		pollURL := fmt.Sprintf("https://api.replicate.com/v1/predictions/%s", prediction.ID)
-		req, err = http.NewRequest("GET", pollURL, nil)
-		if err != nil {
-			return "", fmt.Errorf("error creating poll request: %v", err)
+		if len(urls) > 0 {
+			return urls, nil
 		}
-
-		req.Header.Set("Authorization", authHeader)
-		req.Header.Set("Content-Type", "application/json")
-
-		resp, err = s.httpClient.Do(req)
-		if err != nil {
-			log.Printf("Error polling prediction (will retry): %v", err)
-			continue
-		}
-
-		// Read the poll response body for debugging
-		pollBodyBytes, err := io.ReadAll(resp.Body)
-		if err != nil {
-			resp.Body.Close()
-			return "", fmt.Errorf("error reading poll response body: %v", err)
-		}
-
-		// Log the raw poll response for debugging
-		log.Printf("Replicate API poll response status: %d", resp.StatusCode)
-		log.Printf("Replicate API poll response body: %s", string(pollBodyBytes))
-
-		// Check if the poll response status code is not successful
-		if resp.StatusCode != http.StatusOK {
-			resp.Body.Close()
-			log.Printf("API returned error status %d during polling: %s", resp.StatusCode, string(pollBodyBytes))
-			continue
-		}
-
-		// Create a new reader with the same data for JSON decoding
-		pollBodyReader := bytes.NewReader(pollBodyBytes)
-
-		// Reset the prediction object before decoding
-		prediction = ReplicateResponse{}
-
-		if err := json.NewDecoder(pollBodyReader).Decode(&prediction); err != nil {
-			resp.Body.Close()
-			log.Printf("Error decoding poll response (will retry): %v, body: %s", err, string(pollBodyBytes))
-			continue
-		}
-		resp.Body.Close()
+	case string:
+		// If output is directly a string
+		return []string{output}, nil
 	}
 
-	return "", fmt.Errorf("timeout waiting for prediction after %d attempts", maxRetries)
+	// If we couldn't extract a string, return the raw output as JSON
+	outputJSON, _ := json.Marshal(prediction.Output)
+	return []string{string(outputJSON)}, nil
 }
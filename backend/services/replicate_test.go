@@ -0,0 +1,301 @@
+package services
+
+import (
+	"backend/models"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testWebhookSecret = "whsec_dGVzdC13ZWJob29rLXNlY3JldA=="
+
+// fakeReplicate serves the prediction endpoints of the Replicate API. Predictions are
+// created as "processing" and succeed once they have been polled pollsUntilDone times.
+type fakeReplicate struct {
+	t              *testing.T
+	pollsUntilDone int
+
+	mu       sync.Mutex
+	created  []map[string]interface{}
+	paths    []string
+	polls    map[string]int
+	authSeen string
+}
+
+func newFakeReplicate(t *testing.T, pollsUntilDone int) *fakeReplicate {
+	fake := &fakeReplicate{t: t, pollsUntilDone: pollsUntilDone, polls: make(map[string]int)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	t.Setenv("REPLICATE_API_URL", server.URL+"/v1/")
+	return fake
+}
+
+func (f *fakeReplicate) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.authSeen = r.Header.Get("Authorization")
+	f.paths = append(f.paths, r.Method+" "+r.URL.Path)
+
+	switch {
+	case r.Method == http.MethodPost:
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			f.t.Errorf("invalid prediction request: %v", err)
+		}
+		f.created = append(f.created, body)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(ReplicateResponse{ID: "pred-1", Status: "processing"})
+
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/predictions/pred-1":
+		f.polls["pred-1"]++
+		prediction := ReplicateResponse{ID: "pred-1", Status: "processing"}
+		if f.polls["pred-1"] >= f.pollsUntilDone {
+			prediction.Status = "succeeded"
+			prediction.Output = []string{"https://example.com/a.png", "https://example.com/b.png"}
+		}
+		json.NewEncoder(w).Encode(prediction)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func testImageModel(endpoint string) *models.ImageModel {
+	return &models.ImageModel{ID: "test", Model: "owner/model", Version: "abc123", Endpoint: endpoint}
+}
+
+func TestCreatePredictionUsesEndpoint(t *testing.T) {
+	fake := newFakeReplicate(t, 1)
+	service := NewReplicateServiceWithKey("r8_testkey1234")
+
+	if _, err := service.createPrediction(testImageModel(models.ImageEndpointModel), map[string]interface{}{"prompt": "cat"}, ""); err != nil {
+		t.Fatalf("model endpoint: %v", err)
+	}
+	if _, err := service.createPrediction(testImageModel(models.ImageEndpointVersion), map[string]interface{}{"prompt": "cat"}, "https://app.example.com/hook"); err != nil {
+		t.Fatalf("version endpoint: %v", err)
+	}
+
+	if fake.paths[0] != "POST /v1/models/owner/model/predictions" || fake.paths[1] != "POST /v1/predictions" {
+		t.Fatalf("unexpected request paths %v", fake.paths)
+	}
+	if fake.authSeen != "Token r8_testkey1234" {
+		t.Errorf("Authorization = %q", fake.authSeen)
+	}
+	if _, ok := fake.created[0]["version"]; ok {
+		t.Errorf("model endpoint request carries a version: %v", fake.created[0])
+	}
+	if _, ok := fake.created[0]["webhook"]; ok {
+		t.Errorf("request without webhook URL carries a webhook: %v", fake.created[0])
+	}
+	if fake.created[1]["version"] != "abc123" || fake.created[1]["webhook"] != "https://app.example.com/hook" {
+		t.Errorf("version endpoint request = %v", fake.created[1])
+	}
+}
+
+func TestCreatePredictionRequiresKey(t *testing.T) {
+	newFakeReplicate(t, 1)
+	if _, err := NewReplicateServiceWithKey("").createPrediction(testImageModel(models.ImageEndpointModel), nil, ""); err == nil {
+		t.Fatal("expected an error without an API key")
+	}
+}
+
+func TestGetPrediction(t *testing.T) {
+	newFakeReplicate(t, 1)
+	service := NewReplicateServiceWithKey("r8_testkey1234")
+
+	prediction, err := service.GetPrediction("pred-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputs, err := predictionOutputs(prediction)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outputs) != 2 || outputs[0] != "https://example.com/a.png" {
+		t.Errorf("outputs = %v", outputs)
+	}
+
+	if _, err := service.GetPrediction("missing"); err == nil {
+		t.Error("expected an error for an unknown prediction")
+	}
+}
+
+// signWebhook signs a webhook body the way Replicate does
+func signWebhook(t *testing.T, webhookID, timestamp string, body []byte) string {
+	key, err := base64.StdEncoding.DecodeString(testWebhookSecret[len("whsec_"):])
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(webhookID + "." + timestamp + "."))
+	mac.Write(body)
+	return "v1," + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyWebhook(t *testing.T) {
+	tracker := NewPredictionTracker("https://app.example.com/hook", testWebhookSecret, time.Minute)
+	body := []byte(`{"id":"pred-1","status":"succeeded"}`)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-webhookTimestampTolerance-time.Minute).Unix(), 10)
+
+	tests := []struct {
+		name       string
+		timestamp  string
+		signatures string
+		body       []byte
+		valid      bool
+	}{
+		{"valid", now, signWebhook(t, "msg_1", now, body), body, true},
+		{"valid among several", now, "v1,bm9wZQ== " + signWebhook(t, "msg_1", now, body), body, true},
+		{"tampered body", now, signWebhook(t, "msg_1", now, body), []byte(`{"id":"pred-1","status":"failed"}`), false},
+		{"other message id", now, signWebhook(t, "msg_2", now, body), body, false},
+		{"stale timestamp", stale, signWebhook(t, "msg_1", stale, body), body, false},
+		{"invalid timestamp", "soon", signWebhook(t, "msg_1", "soon", body), body, false},
+		{"missing signature", now, "", body, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tracker.VerifyWebhook("msg_1", tt.timestamp, tt.signatures, tt.body)
+			if tt.valid && err != nil {
+				t.Fatalf("expected a valid signature, got %v", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidWebhookSignature) {
+				t.Fatalf("expected ErrInvalidWebhookSignature, got %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyWebhookDisabled(t *testing.T) {
+	tracker := NewPredictionTracker("", testWebhookSecret, time.Minute)
+	if tracker.WebhookURL() != "" {
+		t.Fatal("webhooks should be disabled without a URL")
+	}
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	body := []byte(`{}`)
+	if err := tracker.VerifyWebhook("msg_1", now, signWebhook(t, "msg_1", now, body), body); !errors.Is(err, ErrInvalidWebhookSignature) {
+		t.Fatalf("expected ErrInvalidWebhookSignature, got %v", err)
+	}
+}
+
+// result is what a tracked prediction finished with
+type result struct {
+	outputs []string
+	err     error
+}
+
+func trackForTest(tracker *PredictionTracker, id string, service *ReplicateService) chan result {
+	finished := make(chan result, 1)
+	tracker.Track(id, service, func(outputs []string, err error) {
+		finished <- result{outputs, err}
+	})
+	return finished
+}
+
+func waitForResult(t *testing.T, finished chan result) result {
+	select {
+	case r := <-finished:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("prediction was not completed")
+		return result{}
+	}
+}
+
+func TestComplete(t *testing.T) {
+	tracker := NewPredictionTracker("https://app.example.com/hook", testWebhookSecret, time.Minute)
+	finished := trackForTest(tracker, "pred-1", nil)
+
+	if tracker.Complete(&ReplicateResponse{ID: "pred-1", Status: "processing"}) {
+		t.Fatal("a running prediction must not complete")
+	}
+	if tracker.Complete(&ReplicateResponse{ID: "other", Status: "succeeded", Output: "x"}) {
+		t.Fatal("an untracked prediction must not complete")
+	}
+	if !tracker.Complete(&ReplicateResponse{ID: "pred-1", Status: "succeeded", Output: []interface{}{"https://example.com/a.png"}}) {
+		t.Fatal("the tracked prediction should complete")
+	}
+
+	r := waitForResult(t, finished)
+	if r.err != nil || len(r.outputs) != 1 || r.outputs[0] != "https://example.com/a.png" {
+		t.Fatalf("done called with %v, %v", r.outputs, r.err)
+	}
+
+	// A repeated webhook for the same prediction is ignored
+	if tracker.Complete(&ReplicateResponse{ID: "pred-1", Status: "succeeded", Output: "x"}) {
+		t.Fatal("a prediction must only complete once")
+	}
+}
+
+func TestCompleteFailedPrediction(t *testing.T) {
+	tracker := NewPredictionTracker("https://app.example.com/hook", testWebhookSecret, time.Minute)
+	finished := trackForTest(tracker, "pred-1", nil)
+
+	if !tracker.Complete(&ReplicateResponse{ID: "pred-1", Status: "failed", Error: "NSFW"}) {
+		t.Fatal("the tracked prediction should complete")
+	}
+	if r := waitForResult(t, finished); r.err == nil {
+		t.Fatalf("expected an error, got outputs %v", r.outputs)
+	}
+}
+
+func TestPollLateFallback(t *testing.T) {
+	fake := newFakeReplicate(t, 2)
+	service := NewReplicateServiceWithKey("r8_testkey1234")
+	tracker := NewPredictionTracker("https://app.example.com/hook", testWebhookSecret, time.Hour)
+	finished := trackForTest(tracker, "pred-1", service)
+
+	// Predictions are not polled before the fallback delay
+	tracker.pollLate(0)
+	if len(fake.paths) != 0 {
+		t.Fatalf("polled before the fallback delay: %v", fake.paths)
+	}
+
+	tracker.fallbackDelay = 0
+
+	// The first poll still finds the prediction running
+	tracker.pollLate(0)
+	select {
+	case r := <-finished:
+		t.Fatalf("completed while running: %v, %v", r.outputs, r.err)
+	default:
+	}
+
+	tracker.pollLate(0)
+	r := waitForResult(t, finished)
+	if r.err != nil || len(r.outputs) != 2 {
+		t.Fatalf("done called with %v, %v", r.outputs, r.err)
+	}
+	if fake.polls["pred-1"] != 2 {
+		t.Errorf("polled %d times, want 2", fake.polls["pred-1"])
+	}
+}
+
+func TestPollLateTimeout(t *testing.T) {
+	newFakeReplicate(t, 100)
+	service := NewReplicateServiceWithKey("r8_testkey1234")
+	tracker := NewPredictionTracker("https://app.example.com/hook", testWebhookSecret, 0)
+	finished := trackForTest(tracker, "pred-1", service)
+
+	tracker.mu.Lock()
+	tracker.pending["pred-1"].startedAt = time.Now().Add(-predictionTimeout - time.Minute)
+	tracker.mu.Unlock()
+
+	tracker.pollLate(0)
+	if r := waitForResult(t, finished); r.err == nil {
+		t.Fatalf("expected a timeout error, got outputs %v", r.outputs)
+	}
+	if tracker.Complete(&ReplicateResponse{ID: "pred-1", Status: "succeeded", Output: "x"}) {
+		t.Fatal("a timed out prediction must no longer be tracked")
+	}
+}
@@ -0,0 +1,244 @@
+package services
+
+import (
+	"backend/models"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPredictionFallbackDelay = 30 * time.Second
+	predictionPollInterval         = 10 * time.Second
+	predictionTimeout              = 10 * time.Minute
+	webhookTimestampTolerance      = 5 * time.Minute
+)
+
+// ErrInvalidWebhookSignature is returned for webhook requests that weren't signed by Replicate
+var ErrInvalidWebhookSignature = errors.New("invalid webhook signature")
+
+var (
+	predictionTracker     *PredictionTracker
+	predictionTrackerOnce sync.Once
+)
+
+// pendingPrediction is a prediction waiting for its webhook
+type pendingPrediction struct {
+	service    *ReplicateService
+	startedAt  time.Time
+	lastPolled time.Time
+	done       func([]string, error)
+}
+
+// PredictionTracker completes predictions started with a webhook. Predictions whose webhook
+// doesn't arrive in time are polled instead, so jobs also finish when Replicate can't reach
+// the server.
+type PredictionTracker struct {
+	webhookURL    string
+	secret        []byte
+	fallbackDelay time.Duration
+
+	mu      sync.Mutex
+	pending map[string]*pendingPrediction
+}
+
+// GetPredictionTracker returns the shared tracker, starting its polling fallback on first use.
+// Webhooks are used when both REPLICATE_WEBHOOK_URL (the public URL of /api/webhooks/replicate)
+// and REPLICATE_WEBHOOK_SECRET are set; REPLICATE_WEBHOOK_FALLBACK sets how long to wait for a
+// webhook before polling (e.g. "1m").
+func GetPredictionTracker() *PredictionTracker {
+	predictionTrackerOnce.Do(func() {
+		delay := defaultPredictionFallbackDelay
+		if value := os.Getenv("REPLICATE_WEBHOOK_FALLBACK"); value != "" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil || parsed <= 0 {
+				log.Printf("Warning: invalid REPLICATE_WEBHOOK_FALLBACK %q, using %v", value, defaultPredictionFallbackDelay)
+			} else {
+				delay = parsed
+			}
+		}
+
+		predictionTracker = NewPredictionTracker(os.Getenv("REPLICATE_WEBHOOK_URL"), os.Getenv("REPLICATE_WEBHOOK_SECRET"), delay)
+		predictionTracker.StartPollingRoutine(predictionPollInterval)
+	})
+	return predictionTracker
+}
+
+// NewPredictionTracker creates a tracker. The secret is the webhook signing secret
+// from Replicate ("whsec_..."); without it webhooks are disabled.
+func NewPredictionTracker(webhookURL, secret string, fallbackDelay time.Duration) *PredictionTracker {
+	tracker := &PredictionTracker{
+		fallbackDelay: fallbackDelay,
+		pending:       make(map[string]*pendingPrediction),
+	}
+
+	if webhookURL == "" {
+		return tracker
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(secret, "whsec_"))
+	if secret == "" || err != nil {
+		log.Printf("Warning: REPLICATE_WEBHOOK_SECRET is missing or invalid, predictions are polled instead of using webhooks")
+		return tracker
+	}
+
+	tracker.webhookURL = webhookURL
+	tracker.secret = key
+	return tracker
+}
+
+// WebhookURL returns the URL Replicate should call, or "" if webhooks are disabled
+func (t *PredictionTracker) WebhookURL() string {
+	return t.webhookURL
+}
+
+// Track waits for a prediction started with the webhook URL. done is called once,
+// in its own goroutine, with the output URLs or the error.
+func (t *PredictionTracker) Track(predictionID string, service *ReplicateService, done func([]string, error)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.pending[predictionID] = &pendingPrediction{
+		service:   service,
+		startedAt: time.Now(),
+		done:      done,
+	}
+}
+
+// Complete finishes a tracked prediction that reached a final state. It reports whether
+// the prediction was being tracked.
+func (t *PredictionTracker) Complete(prediction *ReplicateResponse) bool {
+	if !isPredictionDone(prediction) {
+		return false
+	}
+
+	t.mu.Lock()
+	pending, ok := t.pending[prediction.ID]
+	delete(t.pending, prediction.ID)
+	t.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	outputs, err := predictionOutputs(prediction)
+	go pending.done(outputs, err)
+	return true
+}
+
+// VerifyWebhook checks the signature of a webhook request. Replicate signs
+// "<webhook-id>.<webhook-timestamp>.<body>" with HMAC-SHA256 and sends one or more
+// space-separated "v1,<base64 signature>" values in the webhook-signature header.
+func (t *PredictionTracker) VerifyWebhook(webhookID, timestamp, signatures string, body []byte) error {
+	if t.secret == nil {
+		return fmt.Errorf("%w: webhooks are disabled", ErrInvalidWebhookSignature)
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: invalid timestamp", ErrInvalidWebhookSignature)
+	}
+	if math.Abs(time.Since(time.Unix(seconds, 0)).Seconds()) > webhookTimestampTolerance.Seconds() {
+		return fmt.Errorf("%w: timestamp too old", ErrInvalidWebhookSignature)
+	}
+
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte(webhookID + "." + timestamp + "."))
+	mac.Write(body)
+	expected := mac.Sum(nil)
+
+	for _, signature := range strings.Fields(signatures) {
+		version, value, found := strings.Cut(signature, ",")
+		if !found || version != "v1" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err == nil && hmac.Equal(decoded, expected) {
+			return nil
+		}
+	}
+	return ErrInvalidWebhookSignature
+}
+
+// StartPollingRoutine periodically polls predictions whose webhook is late
+func (t *PredictionTracker) StartPollingRoutine(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			t.pollLate(interval)
+		}
+	}()
+}
+
+// pollLate polls the predictions that have been waiting longer than the fallback delay
+// and fails those that exceeded the prediction timeout
+func (t *PredictionTracker) pollLate(interval time.Duration) {
+	now := time.Now()
+
+	t.mu.Lock()
+	late := make(map[string]*pendingPrediction)
+	for id, pending := range t.pending {
+		if now.Sub(pending.startedAt) < t.fallbackDelay || now.Sub(pending.lastPolled) < interval {
+			continue
+		}
+		pending.lastPolled = now
+		late[id] = pending
+	}
+	t.mu.Unlock()
+
+	for id, pending := range late {
+		prediction, err := pending.service.GetPrediction(id)
+		if err == nil && t.Complete(prediction) {
+			log.Printf("Prediction %s completed without a webhook, found by polling", id)
+			continue
+		}
+		if err != nil {
+			log.Printf("Error polling prediction %s: %v", id, err)
+		}
+
+		if now.Sub(pending.startedAt) > predictionTimeout {
+			t.mu.Lock()
+			_, ok := t.pending[id]
+			delete(t.pending, id)
+			t.mu.Unlock()
+			if ok {
+				go pending.done(nil, fmt.Errorf("timeout waiting for prediction after %v", predictionTimeout))
+			}
+		}
+	}
+}
+
+// RunModelAsync runs a prediction and calls done with its outputs. With webhooks enabled
+// no goroutine waits for the prediction; otherwise it is polled like RunModel.
+func (s *ReplicateService) RunModelAsync(model *models.ImageModel, input map[string]interface{}, done func([]string, error)) {
+	tracker := GetPredictionTracker()
+	if tracker.WebhookURL() == "" {
+		go func() {
+			done(s.RunModel(model, input))
+		}()
+		return
+	}
+
+	log.Printf("Running image model %s (%s) with a webhook", model.ID, model.Model)
+	prediction, err := s.createPrediction(model, input, tracker.WebhookURL())
+	if err != nil {
+		go done(nil, err)
+		return
+	}
+
+	tracker.Track(prediction.ID, s, done)
+	// Fast models can finish before the prediction is tracked
+	if isPredictionDone(prediction) {
+		tracker.Complete(prediction)
+	}
+}
@@ -0,0 +1,183 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy controls how failed OpenRouter requests are retried before
+// falling back to the next model
+type RetryPolicy struct {
+	MaxAttempts    int // Attempts per model, including the first one
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	Jitter         float64 // Fraction of each delay that is randomized (0-1)
+}
+
+// DefaultRetryPolicy returns the retry policy configured through the environment:
+// OPENROUTER_RETRY_ATTEMPTS, OPENROUTER_RETRY_INITIAL_BACKOFF and OPENROUTER_RETRY_MAX_BACKOFF
+func DefaultRetryPolicy() RetryPolicy {
+	policy := RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     8 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+
+	if value := os.Getenv("OPENROUTER_RETRY_ATTEMPTS"); value != "" {
+		if attempts, err := strconv.Atoi(value); err == nil && attempts > 0 {
+			policy.MaxAttempts = attempts
+		} else {
+			log.Printf("Warning: invalid OPENROUTER_RETRY_ATTEMPTS %q", value)
+		}
+	}
+	if value := os.Getenv("OPENROUTER_RETRY_INITIAL_BACKOFF"); value != "" {
+		if backoff, err := time.ParseDuration(value); err == nil && backoff >= 0 {
+			policy.InitialBackoff = backoff
+		} else {
+			log.Printf("Warning: invalid OPENROUTER_RETRY_INITIAL_BACKOFF %q", value)
+		}
+	}
+	if value := os.Getenv("OPENROUTER_RETRY_MAX_BACKOFF"); value != "" {
+		if backoff, err := time.ParseDuration(value); err == nil && backoff >= 0 {
+			policy.MaxBackoff = backoff
+		} else {
+			log.Printf("Warning: invalid OPENROUTER_RETRY_MAX_BACKOFF %q", value)
+		}
+	}
+
+	return policy
+}
+
+// Backoff returns the delay before the given retry (1 for the first retry),
+// using exponential backoff with jitter
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+	delay := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(retry-1))
+	if maxDelay := float64(p.MaxBackoff); delay > maxDelay {
+		delay = maxDelay
+	}
+
+	if p.Jitter > 0 {
+		delay = delay * (1 - p.Jitter + 2*p.Jitter*rand.Float64())
+	}
+	return time.Duration(delay)
+}
+
+// OpenRouterAPIError is returned when OpenRouter answers with a non-200 status
+type OpenRouterAPIError struct {
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration // From the Retry-After header, if present
+}
+
+func (e *OpenRouterAPIError) Error() string {
+	return fmt.Sprintf("API request failed with status %d: %s", e.StatusCode, e.Body)
+}
+
+// newOpenRouterAPIError builds an API error from a failed response
+func newOpenRouterAPIError(resp *http.Response, body []byte) *OpenRouterAPIError {
+	apiErr := &OpenRouterAPIError{
+		StatusCode: resp.StatusCode,
+		Body:       string(body),
+	}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return apiErr
+}
+
+// isRetryableError reports whether a request may succeed if simply tried again.
+// Network errors, timeouts, rate limits and server errors are retryable; canceled
+// requests and answers that can't be decoded are not.
+func isRetryableError(err error) bool {
+	var apiErr *OpenRouterAPIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusRequestTimeout ||
+			apiErr.StatusCode == http.StatusTooManyRequests ||
+			apiErr.StatusCode >= 500
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// isModelUnavailableError reports whether the requested model cannot serve the request,
+// in which case retrying the same model is pointless but a fallback model may work
+func isModelUnavailableError(err error) bool {
+	var apiErr *OpenRouterAPIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	if apiErr.StatusCode == http.StatusNotFound {
+		return true
+	}
+	body := strings.ToLower(apiErr.Body)
+	return apiErr.StatusCode == http.StatusBadRequest &&
+		(strings.Contains(body, "not a valid model") || strings.Contains(body, "no endpoints found"))
+}
+
+// withFallback calls the given function for each model in order, retrying each
+// according to the policy, until one succeeds. Errors that neither a retry nor
+// another model can fix (e.g. an invalid API key) are returned immediately, as is
+// the context's error once it is done.
+func (p RetryPolicy) withFallback(ctx context.Context, modelIDs []string, call func(modelID string) error) error {
+	if len(modelIDs) == 0 {
+		return errors.New("no model specified")
+	}
+
+	var lastErr error
+	for i, modelID := range modelIDs {
+		for attempt := 1; attempt <= p.MaxAttempts; attempt++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			lastErr = call(modelID)
+			if lastErr == nil {
+				return nil
+			}
+			if isModelUnavailableError(lastErr) || !isRetryableError(lastErr) || attempt == p.MaxAttempts {
+				break
+			}
+
+			delay := p.Backoff(attempt)
+			var apiErr *OpenRouterAPIError
+			if errors.As(lastErr, &apiErr) && apiErr.RetryAfter > delay {
+				delay = apiErr.RetryAfter
+				if delay > p.MaxBackoff {
+					delay = p.MaxBackoff
+				}
+			}
+
+			log.Printf("Request to model %s failed (attempt %d/%d), retrying in %v: %v", modelID, attempt, p.MaxAttempts, delay, lastErr)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		if !isModelUnavailableError(lastErr) && !isRetryableError(lastErr) {
+			return lastErr
+		}
+		if i < len(modelIDs)-1 {
+			log.Printf("Model %s failed, falling back to %s: %v", modelID, modelIDs[i+1], lastErr)
+		}
+	}
+
+	return lastErr
+}
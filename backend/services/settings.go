@@ -0,0 +1,45 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// isSettingNotFound reports whether GetUserSetting failed only because the setting
+// doesn't exist yet, as opposed to a read error that must not be mistaken for an
+// empty setting
+func isSettingNotFound(err error) bool {
+	return errors.Is(err, os.ErrNotExist) || status.Code(err) == codes.NotFound
+}
+
+// decodeSetting converts a user setting document into a typed struct.
+// Settings come back as generic maps from both Firestore and the local JSON files,
+// so a JSON round trip is the simplest way to get consistent number handling.
+func decodeSetting(data map[string]interface{}, v interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal setting: %v", err)
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("failed to decode setting: %v", err)
+	}
+	return nil
+}
+
+// encodeSetting converts a typed struct into a user setting document
+func encodeSetting(v interface{}) (map[string]interface{}, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal setting: %v", err)
+	}
+	data := make(map[string]interface{})
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, fmt.Errorf("failed to encode setting: %v", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"backend/models"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// StableDiffusionService generates images on a self-hosted server with the Stable Diffusion
+// web UI API (/sdapi/v1), as served by Automatic1111 and compatible UIs. Images are returned
+// as PNG data URIs. ComfyUI's workflow API is not supported; ComfyUI servers need an extension
+// that serves the /sdapi/v1 endpoints.
+type StableDiffusionService struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewStableDiffusionService creates a client for the server at baseURL,
+// http://127.0.0.1:7860 if empty
+func NewStableDiffusionService(baseURL string) *StableDiffusionService {
+	if baseURL == "" {
+		baseURL = "http://127.0.0.1:7860"
+	}
+	return &StableDiffusionService{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		httpClient: &http.Client{
+			Timeout: time.Minute * 5,
+		},
+	}
+}
+
+// GenerateImage generates an image from a prompt with the default generation model
+func (s *StableDiffusionService) GenerateImage(prompt string) (string, error) {
+	return runDefaultImageModel(s, models.ImageOperationGenerate, map[string]interface{}{
+		"prompt": prompt,
+	})
+}
+
+// InpaintImage repaints the masked area of an image with the default inpainting model
+func (s *StableDiffusionService) InpaintImage(imageURL, prompt, maskURL string) (string, error) {
+	return runDefaultImageModel(s, models.ImageOperationInpaint, map[string]interface{}{
+		"image":  imageURL,
+		"mask":   maskURL,
+		"prompt": prompt,
+	})
+}
+
+// RunModel runs txt2img, or img2img when the input has a source image. Models that only
+// upscale use the extras endpoint.
+func (s *StableDiffusionService) RunModel(model *models.ImageModel, input map[string]interface{}) ([]string, error) {
+	log.Printf("Running Stable Diffusion model %s", model.ID)
+
+	payload := make(map[string]interface{})
+	for name, value := range input {
+		payload[name] = value
+	}
+
+	// Source images are sent inline as base64
+	for _, name := range []string{"image", "mask"} {
+		source, ok := payload[name].(string)
+		if !ok || source == "" {
+			delete(payload, name)
+			continue
+		}
+		encoded, err := LoadStoredImage(source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load %s: %v", name, err)
+		}
+		payload[name] = encoded
+	}
+
+	if model.Supports(models.ImageOperationUpscale) && !model.Supports(models.ImageOperationGenerate) {
+		var result struct {
+			Image string `json:"image"`
+		}
+		if err := s.post("/sdapi/v1/extra-single-image", payload, &result); err != nil {
+			return nil, err
+		}
+		return []string{pngDataURI(result.Image)}, nil
+	}
+
+	if model.Model != "" {
+		payload["override_settings"] = map[string]interface{}{"sd_model_checkpoint": model.Model}
+	}
+
+	endpoint := "/sdapi/v1/txt2img"
+	if image, ok := payload["image"]; ok {
+		endpoint = "/sdapi/v1/img2img"
+		payload["init_images"] = []interface{}{image}
+		delete(payload, "image")
+	}
+
+	var result struct {
+		Images []string `json:"images"`
+	}
+	if err := s.post(endpoint, payload, &result); err != nil {
+		return nil, err
+	}
+	if len(result.Images) == 0 {
+		return nil, fmt.Errorf("Stable Diffusion returned no images")
+	}
+
+	// Some UIs append extra images such as masks; keep one per requested image
+	if batch, ok := payload["batch_size"].(int); ok && batch > 0 && len(result.Images) > batch {
+		result.Images = result.Images[:batch]
+	}
+
+	urls := make([]string, 0, len(result.Images))
+	for _, image := range result.Images {
+		urls = append(urls, pngDataURI(image))
+	}
+	return urls, nil
+}
+
+// RunModelAsync runs RunModel in the background
+func (s *StableDiffusionService) RunModelAsync(model *models.ImageModel, input map[string]interface{}, done func([]string, error)) {
+	go func() {
+		done(s.RunModel(model, input))
+	}()
+}
+
+// post sends a JSON request to the web UI API and decodes the response
+func (s *StableDiffusionService) post(path string, payload interface{}, result interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error marshaling request: %v", err)
+	}
+
+	resp, err := s.httpClient.Post(s.baseURL+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error making request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response body: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Stable Diffusion API returned status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	if err := json.Unmarshal(respBody, result); err != nil {
+		return fmt.Errorf("error decoding response: %v", err)
+	}
+	return nil
+}
+
+// pngDataURI wraps base64 PNG data in a data URI
+func pngDataURI(data string) string {
+	if strings.HasPrefix(data, "data:") {
+		return data
+	}
+	return "data:image/png;base64," + data
+}
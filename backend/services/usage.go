@@ -0,0 +1,194 @@
+package services
+
+import (
+	"backend/interfaces"
+	"backend/models"
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// UsageSettingKey is the user setting that holds the per-user usage ledger
+const UsageSettingKey = "usage"
+
+// UsageLedgerDays is the number of days the daily breakdown is kept for. Older days
+// are dropped from the ledger and only remain part of the all-time totals.
+const UsageLedgerDays = 366
+
+var (
+	usageTracker     *UsageTracker
+	usageTrackerOnce sync.Once
+)
+
+// UsageTracker prices completions and keeps the per-user usage ledger
+type UsageTracker struct {
+	db interfaces.DatabaseService
+	mu sync.Mutex
+}
+
+// GetUsageTracker returns the shared usage tracker. Ledgers are updated under its lock,
+// so every controller recording usage has to use this instance.
+func GetUsageTracker() *UsageTracker {
+	usageTrackerOnce.Do(func() {
+		usageTracker = NewUsageTracker(GetDatabaseService())
+	})
+	return usageTracker
+}
+
+// NewUsageTracker creates a new usage tracker
+func NewUsageTracker(db interfaces.DatabaseService) *UsageTracker {
+	return &UsageTracker{
+		db: db,
+	}
+}
+
+// PriceCompletion converts the usage reported by OpenRouter into a TokenUsage
+// with the cost computed from the model's per-token prices
+func (t *UsageTracker) PriceCompletion(modelID string, usage *OpenRouterUsage) *models.TokenUsage {
+	if usage == nil {
+		return nil
+	}
+
+	result := &models.TokenUsage{
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		TotalTokens:      usage.TotalTokens,
+	}
+	if result.TotalTokens == 0 {
+		result.TotalTokens = result.PromptTokens + result.CompletionTokens
+	}
+
+	model, err := GetModelCatalog().Get(modelID)
+	if err != nil {
+		// Fall back to the cost reported by OpenRouter, if any
+		log.Printf("Could not price usage for model %s: %v", modelID, err)
+		result.Cost = usage.Cost
+		return result
+	}
+
+	result.Cost = float64(result.PromptTokens)*model.PricePerToken.Prompt +
+		float64(result.CompletionTokens)*model.PricePerToken.Completion
+	return result
+}
+
+// RecordMessages adds the usage of the given assistant messages to the user's ledger
+func (t *UsageTracker) RecordMessages(ctx context.Context, userID string, messages ...models.Message) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ledger, err := t.loadLedger(ctx, userID)
+	if err != nil {
+		return err
+	}
+	changed := false
+
+	for _, msg := range messages {
+		if msg.Usage == nil {
+			continue
+		}
+
+		day := time.Unix(msg.Timestamp, 0).UTC().Format("2006-01-02")
+		if ledger.Daily[day] == nil {
+			ledger.Daily[day] = make(map[string]*models.UsageTotals)
+		}
+		bucket := ledger.Daily[day][msg.ModelID]
+		if bucket == nil {
+			bucket = &models.UsageTotals{}
+			ledger.Daily[day][msg.ModelID] = bucket
+		}
+
+		bucket.Add(msg.Usage)
+		ledger.Totals.Add(msg.Usage)
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+
+	cutoff := time.Now().UTC().AddDate(0, 0, -UsageLedgerDays+1).Format("2006-01-02")
+	for day := range ledger.Daily {
+		if day < cutoff {
+			delete(ledger.Daily, day)
+		}
+	}
+
+	data, err := encodeSetting(ledger)
+	if err != nil {
+		return err
+	}
+	return t.db.SaveUserSetting(ctx, userID, UsageSettingKey, data)
+}
+
+// GetReport returns the user's usage totals and a daily breakdown by model for the last given number of days
+func (t *UsageTracker) GetReport(ctx context.Context, userID string, days int) (*models.UsageReport, error) {
+	t.mu.Lock()
+	ledger, err := t.loadLedger(ctx, userID)
+	t.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().UTC().AddDate(0, 0, -days+1).Format("2006-01-02")
+
+	report := &models.UsageReport{
+		Totals: ledger.Totals,
+		Daily:  []models.DailyUsage{},
+	}
+
+	for day, byModel := range ledger.Daily {
+		if day < cutoff {
+			continue
+		}
+
+		daily := models.DailyUsage{Date: day}
+		for modelID, totals := range byModel {
+			daily.Models = append(daily.Models, models.ModelUsage{
+				ModelID:     modelID,
+				UsageTotals: *totals,
+			})
+			daily.Totals.PromptTokens += totals.PromptTokens
+			daily.Totals.CompletionTokens += totals.CompletionTokens
+			daily.Totals.TotalTokens += totals.TotalTokens
+			daily.Totals.Cost += totals.Cost
+			daily.Totals.Messages += totals.Messages
+		}
+
+		// Most expensive models first
+		sort.Slice(daily.Models, func(i, j int) bool {
+			return daily.Models[i].Cost > daily.Models[j].Cost
+		})
+		report.Daily = append(report.Daily, daily)
+	}
+
+	// Newest days first
+	sort.Slice(report.Daily, func(i, j int) bool {
+		return report.Daily[i].Date > report.Daily[j].Date
+	})
+
+	return report, nil
+}
+
+// loadLedger reads the user's usage ledger, returning an empty one if none exists yet.
+// A ledger that can't be read or decoded is an error, so it isn't overwritten by the next save.
+func (t *UsageTracker) loadLedger(ctx context.Context, userID string) (*models.UsageLedger, error) {
+	ledger := &models.UsageLedger{}
+
+	data, err := t.db.GetUserSetting(ctx, userID, UsageSettingKey)
+	if err != nil && !isSettingNotFound(err) {
+		return nil, fmt.Errorf("failed to read usage ledger of user %s: %v", userID, err)
+	}
+	if err == nil {
+		if err := decodeSetting(data, ledger); err != nil {
+			return nil, fmt.Errorf("failed to decode usage ledger of user %s: %v", userID, err)
+		}
+	}
+
+	if ledger.Daily == nil {
+		ledger.Daily = make(map[string]map[string]*models.UsageTotals)
+	}
+	return ledger, nil
+}